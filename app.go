@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+
+	"go-redirect/catalog"
+	"go-redirect/geo"
+	"go-redirect/handlers"
+	"go-redirect/middleware"
+	"go-redirect/models"
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+// runtimeOptions selects the files a runtime is built from.
+type runtimeOptions struct {
+	ConfigPath string
+	CSVPath    string
+	// Geo names the GeoLite2 databases; missing files are reported by /ready and
+	// picked up once they appear. GeoRequired refuses to start without a database
+	// answering countries, which the bot filter's geo rule needs.
+	Geo         geo.Paths
+	GeoRequired bool
+}
+
+// runtime is the wiring shared by serve and the commands that exercise the real
+// handlers offline (simulate, route-explain).
+type runtime struct {
+	cfg       *models.Config
+	catalog   *catalog.Service
+	geo       *geo.Service
+	reloader  *configReloader
+	bots      botFilterHandle
+	botFilter fiber.Handler
+}
+
+// botFilterHandle is the part of the bot filter the server manages directly.
+type botFilterHandle interface {
+	Reconfigure(middleware.BotFilterConfig) error
+	SaveState(path string) error
+	LoadState(path string) error
+	StatsHandler(c *fiber.Ctx) error
+	ChallengeHandler(c *fiber.Ctx) error
+}
+
+func newRuntime(opts runtimeOptions) (*runtime, error) {
+	layers := utils.ConfigLayersFromEnv(opts.ConfigPath)
+	cfg, err := layers.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// CSV is hot reloaded by the catalog; YAML products arrive via config reloads.
+	productCatalog, err := catalog.NewService("", opts.CSVPath)
+	if err != nil {
+		return nil, err
+	}
+	handlers.Catalog = productCatalog
+	middleware.Catalog = productCatalog
+
+	// One lookup per IP serves the bot filter, redirect logs and ASN enrichment.
+	geoService := geo.NewService(geo.OpenMMDB(opts.Geo), 0)
+	status := geoService.Status()
+	for _, db := range status.Databases {
+		if !db.Loaded {
+			utils.LogInfo(utils.LogEntry{
+				Type:  "geo_db_warning",
+				Extra: map[string]interface{}{"database": db.Name, "path": db.Path, "error": db.Error},
+			})
+		}
+	}
+	if opts.GeoRequired && !status.Ready {
+		geoService.Close()
+		return nil, fmt.Errorf("geo: no city or country database could be opened (%s, %s)", opts.Geo.City, opts.Geo.Country)
+	}
+	geo.Use(geoService)
+	utils.ASNLookup = geo.LookupASN
+
+	bf, err := middleware.NewBotFilter(botFilterConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	reloader, err := newConfigReloader(layers, cfg, bf, productCatalog)
+	if err != nil {
+		return nil, err
+	}
+	return &runtime{
+		cfg:       cfg,
+		catalog:   productCatalog,
+		geo:       geoService,
+		reloader:  reloader,
+		bots:      bf,
+		botFilter: middleware.ConditionalBotFilter(bf),
+	}, nil
+}
+
+// newApp registers the visitor-facing routes: redirect, pre-sale, article, the
+// product catalog, postbacks from ad networks and the health probes.
+func (rt *runtime) newApp() *fiber.App {
+	engine := html.New("./views", ".html")
+	app := fiber.New(fiber.Config{Views: engine})
+
+	// ========== Request ID and client IP ==========
+	app.Use(middleware.RequestID())
+	app.Use(middleware.ResolveClientIP())
+
+	// ========== Health & public pages (no bot filter) ==========
+	app.Get("/health", handlers.HealthHandler)
+	app.Get("/ready", handlers.ReadinessHandler)
+	app.Get("/article", handlers.ArticleHandler)
+	app.Get("/article/:slug", middleware.RequestLogger(), handlers.ArticlePageHandler)
+	app.Get("/main", handlers.MainHandler)
+	app.Get("/api/products", handlers.ProductsAPIHandler)
+
+	// ========== Postback endpoint (logging only, no bot filter) ==========
+	app.Get("/postback", middleware.RequestLogger(), handlers.PostbackHandler)
+
+	// ========== Bot filter challenge answers (no bot filter) ==========
+	app.Post(middleware.ChallengePath, rt.bots.ChallengeHandler)
+
+	// ========== Protected routes with bot filter + logging ==========
+	// Main redirect endpoint
+	app.Get("/", middleware.RequestLogger(), rt.botFilter, handlers.RedirectHandler)
+	// Pre-sale endpoint
+	app.Get("/pre-sale", middleware.RequestLogger(), rt.botFilter, handlers.PreSaleHandler)
+
+	return app
+}
+
+// newAdminApp registers the dashboard and operational routes, served on
+// admin.listen. Viewers can read logs and status; the config and changing
+// state need an operator.
+func (rt *runtime) newAdminApp() *fiber.App {
+	engine := html.New("./views", ".html")
+	app := fiber.New(fiber.Config{Views: engine})
+	auth := middleware.NewAdminAuth(func() models.Admin {
+		return rt.reloader.Config().Admin
+	})
+	viewer := auth.Require(models.RoleViewer)
+	operator := auth.Require(models.RoleOperator)
+
+	app.Use(middleware.RequestID())
+	app.Use(middleware.ResolveClientIP())
+
+	// ========== Read-only (viewer) ==========
+	app.Get("/", func(c *fiber.Ctx) error { return c.Redirect("/dashboard") })
+	app.Get("/dashboard", viewer, handlers.DashboardHandler)
+	app.Get("/logs", viewer, handlers.LogsHandler)
+	app.Get("/sse", viewer, handlers.SSEHandler)
+	app.Get("/postbacks", viewer, handlers.GetPostbacks)
+	app.Get("/presale-experiments", viewer, handlers.ExperimentsHandler)
+	app.Get("/bot-filter-status", viewer, handlers.BotFilterStatusHandler)
+	app.Get("/bot-filter-rules", viewer, rt.bots.StatsHandler)
+	app.Get("/admin/audit", viewer, handlers.AuditHandler)
+	app.Get("/admin/bans", viewer, handlers.BansHandler)
+
+	// ========== Config and state changes (operator) ==========
+	app.Get("/admin/config", operator, rt.reloader.ConfigHandler)
+	app.Post("/toggle-bot-filter", operator, handlers.ToggleBotFilterHandler)
+	app.Delete("/bot-filter-toggles/:id", operator, handlers.CancelBotFilterToggleHandler)
+	app.Post("/admin/reload-config", operator, rt.reloader.Handler)
+	app.Post("/admin/bans/:id/extend", operator, handlers.ExtendBanHandler)
+	app.Delete("/admin/bans/:id", operator, handlers.LiftBanHandler)
+	app.Post("/admin/bypass-links", operator, handlers.BypassLinkHandler)
+
+	return app
+}
@@ -0,0 +1,215 @@
+package articles
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-redirect/models"
+	"go-redirect/utils"
+
+	"github.com/yuin/goldmark"
+	"gopkg.in/yaml.v3"
+)
+
+// Article is a Markdown file with YAML front matter:
+//
+//	---
+//	slug: tips-belanja
+//	title: Tips Belanja Hemat
+//	campaign: popcash-id
+//	publish_date: 2025-09-01
+//	---
+type Article struct {
+	Slug        string    `yaml:"slug"`
+	Title       string    `yaml:"title"`
+	Description string    `yaml:"description"`
+	Campaign    string    `yaml:"campaign"`
+	PublishDate time.Time `yaml:"publish_date"`
+	// CTA selects where product cards link: "presale" (default) or "direct".
+	CTA string `yaml:"cta"`
+
+	Body string `yaml:"-"`
+	Path string `yaml:"-"`
+}
+
+// Embed is a parsed {{product ...}} shortcode: either a fixed product id or a pool.
+type Embed struct {
+	ID    string
+	Pool  string
+	Limit int
+}
+
+var (
+	shortcodeRe = regexp.MustCompile(`\{\{\s*product\s+([^}]*)\}\}`)
+	attrRe      = regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
+)
+
+// Index holds the parsed articles of a directory by slug. It rescans the directory
+// at most every checkEvery, parsing only files whose size or modification time
+// changed; a file that fails to parse is logged once and left out until fixed.
+type Index struct {
+	dir        string
+	checkEvery time.Duration
+
+	bySlug    atomic.Pointer[map[string]*Article]
+	refreshMu sync.Mutex
+	checked   time.Time
+	files     map[string]indexedFile
+}
+
+type indexedFile struct {
+	mod     time.Time
+	size    int64
+	article *Article
+}
+
+// NewIndex parses every *.md file in dir.
+func NewIndex(dir string, checkEvery time.Duration) *Index {
+	x := &Index{dir: dir, checkEvery: checkEvery, files: map[string]indexedFile{}}
+	x.refresh(time.Now())
+	return x
+}
+
+// Dir is the directory the index reads.
+func (x *Index) Dir() string {
+	return x.dir
+}
+
+// Get returns the article with slug; unpublished articles are not found.
+func (x *Index) Get(slug string, now time.Time) (*Article, bool) {
+	if x.refreshMu.TryLock() {
+		if time.Since(x.checked) >= x.checkEvery {
+			x.refreshLocked(time.Now())
+		}
+		x.refreshMu.Unlock()
+	}
+	a, ok := (*x.bySlug.Load())[slug]
+	if !ok || (!a.PublishDate.IsZero() && a.PublishDate.After(now)) {
+		return nil, false
+	}
+	return a, true
+}
+
+func (x *Index) refresh(now time.Time) {
+	x.refreshMu.Lock()
+	defer x.refreshMu.Unlock()
+	x.refreshLocked(now)
+}
+
+// refreshLocked rebuilds the slug map; the first file claiming a slug wins.
+func (x *Index) refreshLocked(now time.Time) {
+	x.checked = now
+	paths, _ := filepath.Glob(filepath.Join(x.dir, "*.md"))
+	files := make(map[string]indexedFile, len(paths))
+	bySlug := map[string]*Article{}
+	for _, path := range paths {
+		st, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		f, ok := x.files[path]
+		if !ok || !f.mod.Equal(st.ModTime()) || f.size != st.Size() {
+			f = indexedFile{mod: st.ModTime(), size: st.Size()}
+			data, err := os.ReadFile(path)
+			if err == nil {
+				f.article, err = Parse(path, data)
+			}
+			if err != nil {
+				utils.LogInfo(utils.LogEntry{
+					Type:  "article_parse_error",
+					Extra: map[string]interface{}{"path": path, "error": err.Error()},
+				})
+			}
+		}
+		files[path] = f
+		if a := f.article; a != nil {
+			if _, dup := bySlug[a.Slug]; !dup {
+				bySlug[a.Slug] = a
+			}
+		}
+	}
+	x.files = files
+	x.bySlug.Store(&bySlug)
+}
+
+// Parse splits front matter from the Markdown body. The slug defaults to the file name.
+func Parse(path string, data []byte) (*Article, error) {
+	a := &Article{Path: path}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if strings.HasPrefix(text, "---\n") {
+		end := strings.Index(text[4:], "\n---")
+		if end < 0 {
+			return nil, fmt.Errorf("%s: unterminated front matter", path)
+		}
+		if err := yaml.Unmarshal([]byte(text[4:4+end]), a); err != nil {
+			return nil, fmt.Errorf("%s: front matter: %w", path, err)
+		}
+		text = strings.TrimPrefix(text[4+end+4:], "\n")
+	}
+	if a.Slug == "" {
+		a.Slug = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	a.Body = text
+	return a, nil
+}
+
+// Render converts the body to HTML, replacing each product shortcode with the cards
+// produced by card for the products resolve returns.
+func (a *Article) Render(resolve func(Embed) []models.Product, card func(models.Product) (template.HTML, error)) (template.HTML, error) {
+	var cards []template.HTML
+	src := shortcodeRe.ReplaceAllStringFunc(a.Body, func(code string) string {
+		embed := parseEmbed(shortcodeRe.FindStringSubmatch(code)[1])
+		var buf strings.Builder
+		for _, p := range resolve(embed) {
+			h, err := card(p)
+			if err != nil {
+				continue
+			}
+			buf.WriteString(string(h))
+		}
+		cards = append(cards, template.HTML(buf.String()))
+		// Own paragraph so goldmark emits <p>marker</p>, which is swapped back below.
+		return "\n\n" + embedMarker(len(cards)-1) + "\n\n"
+	})
+
+	var out bytes.Buffer
+	if err := goldmark.Convert([]byte(src), &out); err != nil {
+		return "", err
+	}
+	html := out.String()
+	for i, h := range cards {
+		html = strings.Replace(html, "<p>"+embedMarker(i)+"</p>", string(h), 1)
+	}
+	return template.HTML(html), nil
+}
+
+func parseEmbed(attrs string) Embed {
+	e := Embed{Limit: 1}
+	for _, m := range attrRe.FindAllStringSubmatch(attrs, -1) {
+		switch m[1] {
+		case "id":
+			e.ID = m[2]
+		case "pool":
+			e.Pool = m[2]
+		case "limit":
+			if n, err := strconv.Atoi(m[2]); err == nil && n > 0 {
+				e.Limit = n
+			}
+		}
+	}
+	return e
+}
+
+func embedMarker(i int) string {
+	return fmt.Sprintf("GOREDIRECTEMBED%d", i)
+}
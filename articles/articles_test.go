@@ -0,0 +1,96 @@
+package articles
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-redirect/models"
+	"go-redirect/utils"
+)
+
+func TestParseAndRender(t *testing.T) {
+	src := "---\nslug: tips\ntitle: Tips\ncampaign: c1\npublish_date: 2025-09-15\n---\n\n## Hello\n\n{{product id=\"42\"}}\n\n{{ product pool=\"baby\" limit=\"2\" }}\n"
+	a, err := Parse("content/articles/x.md", []byte(src))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if a.Slug != "tips" || a.Title != "Tips" || a.Campaign != "c1" || a.PublishDate.Year() != 2025 {
+		t.Errorf("unexpected front matter: %+v", a)
+	}
+
+	var embeds []Embed
+	html, err := a.Render(
+		func(e Embed) []models.Product {
+			embeds = append(embeds, e)
+			return []models.Product{{ID: e.ID + e.Pool}}
+		},
+		func(p models.Product) (template.HTML, error) {
+			return template.HTML(`<div class="card">` + p.ID + `</div>`), nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if len(embeds) != 2 || embeds[0].ID != "42" || embeds[1].Pool != "baby" || embeds[1].Limit != 2 {
+		t.Errorf("unexpected embeds: %+v", embeds)
+	}
+	for _, exp := range []string{"<h2>Hello</h2>", `<div class="card">42</div>`, `<div class="card">baby</div>`} {
+		if !strings.Contains(string(html), exp) {
+			t.Errorf("expected %s in %s", exp, html)
+		}
+	}
+	if strings.Contains(string(html), "GOREDIRECTEMBED") {
+		t.Errorf("marker left in output: %s", html)
+	}
+}
+
+func TestIndexHidesUnpublished(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	x := NewIndex("../content/articles", time.Minute)
+	if _, ok := x.Get("tips-belanja-hemat", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("expected no article before publish date")
+	}
+	if a, ok := x.Get("tips-belanja-hemat", time.Now()); !ok || a.Title == "" {
+		t.Errorf("expected published article, got %v", a)
+	}
+}
+
+func TestIndexSkipsBrokenFilesAndPicksUpEdits(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	dir := t.TempDir()
+	write := func(name, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("good.md", "---\ntitle: Good\n---\nbody\n")
+	write("broken.md", "---\ntitle: [unclosed\n---\nbody\n")
+
+	x := NewIndex(dir, 0)
+	if a, ok := x.Get("good", time.Now()); !ok || a.Title != "Good" {
+		t.Fatalf("a broken file must not hide the others, got %v", a)
+	}
+	if _, ok := x.Get("broken", time.Now()); ok {
+		t.Errorf("broken article served")
+	}
+
+	write("broken.md", "---\ntitle: Fixed now\n---\nbody\n")
+	if a, ok := x.Get("broken", time.Now()); !ok || a.Title != "Fixed now" {
+		t.Errorf("expected the fixed file to be picked up, got %v", a)
+	}
+
+	var parseErrors int
+	utils.ForEachLogEntry(func(e utils.LogEntry) {
+		if e.Type == "article_parse_error" {
+			parseErrors++
+		}
+	})
+	if parseErrors != 1 {
+		t.Errorf("broken file logged %d times, want once", parseErrors)
+	}
+}
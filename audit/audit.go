@@ -0,0 +1,145 @@
+// Package audit records state-changing administrative actions (who did what, when,
+// and what changed) in an append-only file kept apart from the traffic logs.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go-redirect/middleware"
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Entry is one administrative action. Before and After hold the changed values
+// only, with secrets redacted by the caller; Error is set when the action failed.
+type Entry struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Actor     string      `json:"actor"`
+	Role      string      `json:"role,omitempty"`
+	Source    string      `json:"source"`
+	Action    string      `json:"action"`
+	Target    string      `json:"target"`
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+	IP        string      `json:"ip,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+var mu sync.Mutex
+
+// Path is the audit file, in its own directory under the log folder so the
+// traffic log readers never pick it up.
+func Path() string {
+	return filepath.Join(utils.LogFolder(), "audit", "audit.jsonl")
+}
+
+// FromRequest starts an entry for an admin API call, filled with the authenticated
+// caller, source IP and request ID.
+func FromRequest(c *fiber.Ctx) Entry {
+	name, role := middleware.AdminPrincipal(c)
+	return Entry{
+		Actor:     name,
+		Role:      role,
+		Source:    "admin-api",
+		IP:        middleware.ClientIP(c),
+		RequestID: middleware.GetRequestID(c),
+	}
+}
+
+// Record appends e to the audit file and syncs it, so an entry is on disk before
+// the action is reported as done. A failed write is also logged as
+// audit_write_failed in the traffic log.
+func Record(e Entry) error {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	err := write(e)
+	if err != nil {
+		utils.LogInfo(utils.LogEntry{
+			Type:  "audit_write_failed",
+			Extra: map[string]interface{}{"action": e.Action, "actor": e.Actor, "error": err.Error()},
+		})
+	}
+	return err
+}
+
+func write(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	path := Path()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Filter selects entries for Query. Zero fields match everything.
+type Filter struct {
+	Actor  string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Since.IsZero() || !e.Timestamp.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Timestamp.Before(f.Until))
+}
+
+// Query returns the entries matching f, newest first. A missing file is an empty
+// trail.
+func Query(f Filter) ([]Entry, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	file, err := os.Open(Path())
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	out := []Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && f.match(e) {
+			out = append(out, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordAndQuery(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	if got, err := Query(Filter{}); err != nil || len(got) != 0 {
+		t.Fatalf("empty trail: %v %v", got, err)
+	}
+
+	start := time.Now()
+	Record(Entry{Actor: "ana", Action: "bot_filter.toggle", Target: "bot_filter.enabled", Before: true, After: false})
+	Record(Entry{Actor: "system", Source: "sighup", Action: "config.reload", Error: "bad regex"})
+	Record(Entry{Actor: "ana", Action: "config.reload", After: map[string]string{"bot_filter.rate_limit_max": "20"}})
+
+	all, err := Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Action != "config.reload" || all[2].Action != "bot_filter.toggle" {
+		t.Fatalf("want newest first, got %+v", all)
+	}
+	if all[2].Before != true || all[2].After != false || all[2].Timestamp.Before(start) {
+		t.Errorf("toggle entry not round-tripped: %+v", all[2])
+	}
+
+	byAna, _ := Query(Filter{Actor: "ana", Action: "config.reload"})
+	if len(byAna) != 1 || byAna[0].Actor != "ana" {
+		t.Errorf("actor+action filter: %+v", byAna)
+	}
+	if limited, _ := Query(Filter{Limit: 1}); len(limited) != 1 || limited[0].Actor != "ana" {
+		t.Errorf("limit: %+v", limited)
+	}
+	if future, _ := Query(Filter{Since: time.Now().Add(time.Hour)}); len(future) != 0 {
+		t.Errorf("since filter: %+v", future)
+	}
+}
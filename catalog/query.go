@@ -0,0 +1,188 @@
+package catalog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-redirect/models"
+)
+
+// Sort options accepted by Query.Sort.
+const (
+	SortDefault    = ""
+	SortRelevance  = "relevance"
+	SortCommission = "commission"
+	SortSales      = "sales"
+	SortPriceAsc   = "price_asc"
+	SortPriceDesc  = "price_desc"
+	SortName       = "name"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// Query filters, sorts and paginates products. Text terms must all appear in the
+// product name or description (case-insensitive).
+type Query struct {
+	Text     string `json:"q,omitempty"`
+	Category string `json:"category,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Sort     string `json:"sort,omitempty"`
+	Page     int    `json:"page"`
+	PerPage  int    `json:"per_page"`
+}
+
+// Facet is a category or tag with the number of products matching the text query.
+type Facet struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type Result struct {
+	Query    Query            `json:"query"`
+	Products []models.Product `json:"products"`
+	Total    int              `json:"total"`
+	// Matched counts the products matching the text query alone, like the facets.
+	Matched    int     `json:"matched"`
+	Pages      int     `json:"pages"`
+	Categories []Facet `json:"categories"`
+	Tags       []Facet `json:"tags"`
+}
+
+// ParseQuery builds a Query from string params (e.g. a request's query string).
+func ParseQuery(get func(key string) string) Query {
+	q := Query{
+		Text:     strings.TrimSpace(get("q")),
+		Category: strings.TrimSpace(get("category")),
+		Tag:      strings.ToLower(strings.TrimSpace(get("tag"))),
+		Sort:     strings.TrimSpace(get("sort")),
+	}
+	q.Page, _ = strconv.Atoi(get("page"))
+	q.PerPage, _ = strconv.Atoi(get("per_page"))
+	return q
+}
+
+// Search runs q over products. Facets are computed after the text filter but before
+// the category/tag filters, so they show what selecting each one would return.
+func Search(products []models.Product, q Query) Result {
+	if q.PerPage <= 0 {
+		q.PerPage = defaultPerPage
+	}
+	if q.PerPage > maxPerPage {
+		q.PerPage = maxPerPage
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+
+	terms := strings.Fields(strings.ToLower(q.Text))
+	type hit struct {
+		p     models.Product
+		score int
+	}
+	var hits []hit
+	matched := 0
+	categories := map[string]int{}
+	tags := map[string]int{}
+	for _, p := range products {
+		score, ok := matchText(p, terms)
+		if !ok {
+			continue
+		}
+		matched++
+		if p.Category != "" {
+			categories[p.Category]++
+		}
+		for _, t := range p.Tags {
+			tags[t]++
+		}
+		if q.Category != "" && !strings.EqualFold(p.Category, q.Category) {
+			continue
+		}
+		if q.Tag != "" && !hasTag(p, q.Tag) {
+			continue
+		}
+		hits = append(hits, hit{p, score})
+	}
+
+	sortBy := q.Sort
+	if sortBy == SortDefault && len(terms) > 0 {
+		sortBy = SortRelevance
+	}
+	less := map[string]func(a, b hit) bool{
+		SortRelevance:  func(a, b hit) bool { return a.score > b.score },
+		SortCommission: func(a, b hit) bool { return a.p.Commission > b.p.Commission },
+		SortSales:      func(a, b hit) bool { return a.p.Sales > b.p.Sales },
+		SortPriceAsc:   func(a, b hit) bool { return a.p.Price < b.p.Price },
+		SortPriceDesc:  func(a, b hit) bool { return a.p.Price > b.p.Price },
+		SortName:       func(a, b hit) bool { return strings.ToLower(a.p.Description) < strings.ToLower(b.p.Description) },
+	}[sortBy]
+	if less != nil {
+		sort.SliceStable(hits, func(i, j int) bool { return less(hits[i], hits[j]) })
+	}
+
+	res := Result{
+		Query:      q,
+		Products:   []models.Product{},
+		Total:      len(hits),
+		Matched:    matched,
+		Pages:      (len(hits) + q.PerPage - 1) / q.PerPage,
+		Categories: facets(categories),
+		Tags:       facets(tags),
+	}
+	start := (q.Page - 1) * q.PerPage
+	for i := start; i < len(hits) && i < start+q.PerPage; i++ {
+		res.Products = append(res.Products, hits[i].p)
+	}
+	return res
+}
+
+// matchText requires every term in name or description; name hits score higher.
+func matchText(p models.Product, terms []string) (int, bool) {
+	if len(terms) == 0 {
+		return 0, true
+	}
+	name := strings.ToLower(p.Name)
+	desc := strings.ToLower(p.Description)
+	score := 0
+	for _, t := range terms {
+		inName := strings.Contains(name, t)
+		inDesc := strings.Contains(desc, t)
+		if !inName && !inDesc {
+			return 0, false
+		}
+		if inName {
+			score += 2
+		}
+		if inDesc {
+			score += strings.Count(desc, t)
+		}
+	}
+	return score, true
+}
+
+func hasTag(p models.Product, tag string) bool {
+	for _, t := range p.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func facets(m map[string]int) []Facet {
+	out := make([]Facet, 0, len(m))
+	for name, n := range m {
+		out = append(out, Facet{Name: name, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,56 @@
+package catalog
+
+import (
+	"testing"
+
+	"go-redirect/models"
+)
+
+func TestSearch(t *testing.T) {
+	products := []models.Product{
+		{ID: "1", Name: "Speeds", Description: "Playmat Bayi lipat", Category: "bayi", Tags: []string{"playmat"}, Commission: 6000, Sales: 10000, Price: 56000},
+		{ID: "2", Name: "Cetaphil", Description: "Baby lotion", Category: "bayi", Tags: []string{"lotion"}, Commission: 20000, Sales: 8000, Price: 170000},
+		{ID: "3", Name: "Polytron", Description: "Smart TV 32 inch", Category: "elektronik", Tags: []string{"tv"}, Commission: 23000, Sales: 2000, Price: 2300000},
+		{ID: "4", Name: "Calary", Description: "Playmat Bayi motif", Category: "bayi", Tags: []string{"playmat"}, Commission: 6048, Sales: 9000, Price: 50000},
+	}
+
+	ids := func(r Result) string {
+		s := ""
+		for _, p := range r.Products {
+			s += p.ID
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		ids   string
+		total int
+		pages int
+	}{
+		{"all", Query{}, "1234", 4, 1},
+		{"text and", Query{Text: "playmat bayi"}, "14", 2, 1},
+		{"text miss", Query{Text: "kulkas"}, "", 0, 0},
+		{"category", Query{Category: "bayi", Sort: SortCommission}, "241", 3, 1},
+		{"tag", Query{Tag: "PLAYMAT", Sort: SortSales}, "14", 2, 1},
+		{"price asc", Query{Sort: SortPriceAsc}, "4123", 4, 1},
+		{"page 2", Query{Sort: SortCommission, Page: 2, PerPage: 3}, "1", 4, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Search(products, tt.query)
+			if got := ids(r); got != tt.ids || r.Total != tt.total || r.Pages != tt.pages {
+				t.Errorf("[%s] expected %s total=%d pages=%d, got %s total=%d pages=%d", tt.name, tt.ids, tt.total, tt.pages, got, r.Total, r.Pages)
+			}
+		})
+	}
+
+	r := Search(products, Query{Text: "playmat", Category: "elektronik"})
+	if len(r.Categories) != 1 || r.Categories[0] != (Facet{"bayi", 2}) {
+		t.Errorf("expected facets from text matches only, got %+v", r.Categories)
+	}
+	if r.Total != 0 || r.Matched != 2 {
+		t.Errorf("expected total 0 and matched 2, got %d and %d", r.Total, r.Matched)
+	}
+}
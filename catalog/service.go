@@ -0,0 +1,278 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-redirect/models"
+	"go-redirect/utils"
+)
+
+// Snapshot is an immutable, indexed version of the product catalog. Handlers take one
+// snapshot per request and never see a half-applied reload.
+type Snapshot struct {
+	Version  int
+	LoadedAt time.Time
+	// YAMLProducts are the redirect rotation from config.yaml, CSVProducts the
+	// pre-sale/browse catalog from config.csv.
+	YAMLProducts []models.Product
+	CSVProducts  []models.Product
+
+	all  []models.Product
+	byID map[string]models.Product
+	// csvModTime is the modification time of the CSV file the snapshot was read from.
+	csvModTime time.Time
+}
+
+// NewSnapshot validates and indexes products. IDs must be unique across both sources
+// (empty IDs are allowed for YAML rotation entries), every product needs a URL and
+// weights cannot be negative.
+func NewSnapshot(yamlProducts, csvProducts []models.Product) (*Snapshot, error) {
+	s := &Snapshot{
+		LoadedAt:     time.Now(),
+		YAMLProducts: yamlProducts,
+		CSVProducts:  csvProducts,
+		byID:         make(map[string]models.Product),
+	}
+	s.all = make([]models.Product, 0, len(yamlProducts)+len(csvProducts))
+	s.all = append(s.all, yamlProducts...)
+	s.all = append(s.all, csvProducts...)
+	if len(s.all) == 0 {
+		return nil, fmt.Errorf("catalog is empty")
+	}
+	for i, p := range s.all {
+		label := p.ID
+		if label == "" {
+			label = fmt.Sprintf("#%d %q", i, p.Name)
+		}
+		if p.URL == "" {
+			return nil, fmt.Errorf("product %s: missing url", label)
+		}
+		if p.Percentage < 0 {
+			return nil, fmt.Errorf("product %s: negative percentage", label)
+		}
+		if p.ID == "" {
+			continue
+		}
+		if _, dup := s.byID[p.ID]; dup {
+			return nil, fmt.Errorf("product %s: duplicate id", p.ID)
+		}
+		s.byID[p.ID] = p
+	}
+	return s, nil
+}
+
+// Get looks a product up by ID.
+func (s *Snapshot) Get(id string) (models.Product, bool) {
+	if id == "" {
+		return models.Product{}, false
+	}
+	p, ok := s.byID[id]
+	return p, ok
+}
+
+// All returns YAML products followed by CSV products. Callers must not modify it.
+func (s *Snapshot) All() []models.Product {
+	return s.all
+}
+
+// Service holds the current snapshot loaded from the YAML config and the CSV file and
+// swaps in a new one atomically when they change. A reload that fails keeps the last
+// good snapshot.
+type Service struct {
+	yamlPath string
+	csvPath  string
+
+	current  atomic.Pointer[Snapshot]
+	reloadMu sync.Mutex
+	modTimes map[string]time.Time
+}
+
+// NewService loads the catalog once; an error here means there is no good version yet.
+// With an empty yamlPath the YAML products are owned by the caller, which supplies
+// them through Stage and Commit; CSV reloads then keep the last committed ones.
+func NewService(yamlPath, csvPath string) (*Service, error) {
+	s := &Service{yamlPath: yamlPath, csvPath: csvPath, modTimes: map[string]time.Time{}}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewStatic wraps a fixed snapshot, for tests and tools that do not watch files.
+func NewStatic(snap *Snapshot) *Service {
+	s := &Service{modTimes: map[string]time.Time{}}
+	snap.Version = 1
+	s.current.Store(snap)
+	return s
+}
+
+// Snapshot returns the current catalog version.
+func (s *Service) Snapshot() *Snapshot {
+	return s.current.Load()
+}
+
+// Reload reads both files, validates them and swaps the result in.
+func (s *Service) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	mod := map[string]time.Time{}
+	for _, p := range []string{s.yamlPath, s.csvPath} {
+		if p == "" {
+			continue
+		}
+		st, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		mod[p] = st.ModTime()
+	}
+
+	var yamlProducts []models.Product
+	if prev := s.current.Load(); prev != nil {
+		yamlProducts = prev.YAMLProducts
+	}
+	if s.yamlPath != "" {
+		cfg, err := utils.LoadConfig(s.yamlPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.yamlPath, err)
+		}
+		yamlProducts = cfg.Products
+	}
+	var csvProducts []models.Product
+	if s.csvPath != "" {
+		var err error
+		if csvProducts, err = utils.LoadProductsCSV(s.csvPath); err != nil {
+			return fmt.Errorf("%s: %w", s.csvPath, err)
+		}
+	}
+
+	snap, err := NewSnapshot(yamlProducts, csvProducts)
+	if err != nil {
+		return err
+	}
+	snap.csvModTime = mod[s.csvPath]
+	s.store(snap)
+	s.modTimes = mod
+	return nil
+}
+
+// Stage builds and validates a snapshot from yamlProducts and the CSV file without
+// swapping it in, so a config reload can check every part before committing any.
+func (s *Service) Stage(yamlProducts []models.Product) (*Snapshot, error) {
+	if s.csvPath == "" {
+		var csvProducts []models.Product
+		if prev := s.current.Load(); prev != nil {
+			csvProducts = prev.CSVProducts
+		}
+		return NewSnapshot(yamlProducts, csvProducts)
+	}
+	st, err := os.Stat(s.csvPath)
+	if err != nil {
+		return nil, err
+	}
+	csvProducts, err := utils.LoadProductsCSV(s.csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.csvPath, err)
+	}
+	snap, err := NewSnapshot(yamlProducts, csvProducts)
+	if err != nil {
+		return nil, err
+	}
+	snap.csvModTime = st.ModTime()
+	return snap, nil
+}
+
+// Commit swaps in a snapshot returned by Stage. If the CSV file changed since,
+// e.g. because Watch reloaded it in between, the snapshot is rebuilt from the
+// current file so the newer CSV is not overwritten; should that fail, the staged
+// snapshot is used and the failure logged.
+func (s *Service) Commit(snap *Snapshot) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	if s.csvPath != "" {
+		if st, err := os.Stat(s.csvPath); err == nil && !st.ModTime().Equal(snap.csvModTime) {
+			fresh, err := s.Stage(snap.YAMLProducts)
+			if err != nil {
+				utils.LogInfo(utils.LogEntry{
+					Type:  "catalog_reload_failed",
+					Extra: map[string]interface{}{"error": err.Error(), "kept_csv_modified": snap.csvModTime},
+				})
+			} else {
+				snap = fresh
+				s.modTimes[s.csvPath] = fresh.csvModTime
+			}
+		}
+	}
+	s.store(snap)
+}
+
+func (s *Service) store(snap *Snapshot) {
+	if prev := s.current.Load(); prev != nil {
+		snap.Version = prev.Version + 1
+	} else {
+		snap.Version = 1
+	}
+	s.current.Store(snap)
+}
+
+// Watch polls the source files every interval and reloads when either one changes.
+// Results are logged as catalog_reloaded / catalog_reload_failed.
+func (s *Service) Watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !s.changed() {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			utils.LogInfo(utils.LogEntry{
+				Type: "catalog_reload_failed",
+				Extra: map[string]interface{}{
+					"error":        err.Error(),
+					"kept_version": s.Snapshot().Version,
+				},
+			})
+			// Remember the bad files so the same failure is not retried every tick.
+			s.reloadMu.Lock()
+			for _, p := range []string{s.yamlPath, s.csvPath} {
+				if st, err := os.Stat(p); err == nil {
+					s.modTimes[p] = st.ModTime()
+				}
+			}
+			s.reloadMu.Unlock()
+			continue
+		}
+		snap := s.Snapshot()
+		utils.LogInfo(utils.LogEntry{
+			Type: "catalog_reloaded",
+			Extra: map[string]interface{}{
+				"version":       snap.Version,
+				"yaml_products": len(snap.YAMLProducts),
+				"csv_products":  len(snap.CSVProducts),
+			},
+		})
+	}
+}
+
+func (s *Service) changed() bool {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	for _, p := range []string{s.yamlPath, s.csvPath} {
+		if p == "" {
+			continue
+		}
+		st, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if !st.ModTime().Equal(s.modTimes[p]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-redirect/models"
+)
+
+func TestServiceReloadKeepsLastGood(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "config.yaml")
+	csvPath := filepath.Join(dir, "config.csv")
+	write := func(path, body string) {
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(yamlPath, "products:\n  - name: Rotation\n    url: https://example.com/r\n    percentage: 1\n")
+	write(csvPath, "ID Produk,Nama Produk,Nama Toko,Link Komisi Ekstra\n1,Meja,Speeds,https://example.com/1\n")
+
+	svc, err := NewService(yamlPath, csvPath)
+	if err != nil {
+		t.Fatalf("NewService failed: %v", err)
+	}
+	snap := svc.Snapshot()
+	if snap.Version != 1 || len(snap.YAMLProducts) != 1 || len(snap.CSVProducts) != 1 {
+		t.Fatalf("unexpected first snapshot: %+v", snap)
+	}
+	if p, ok := snap.Get("1"); !ok || p.Name != "Speeds" {
+		t.Errorf("expected product 1 indexed, got %+v %v", p, ok)
+	}
+
+	write(csvPath, "ID Produk,Nama Produk,Nama Toko,Link Komisi Ekstra\n1,Meja,Speeds,https://example.com/1\n1,Dup,Other,https://example.com/2\n")
+	if err := svc.Reload(); err == nil {
+		t.Errorf("expected duplicate id to fail validation")
+	}
+	if svc.Snapshot() != snap {
+		t.Errorf("failed reload must keep the last good snapshot")
+	}
+
+	write(csvPath, "ID Produk,Nama Produk,Nama Toko,Link Komisi Ekstra\n1,Meja,Speeds,https://example.com/1\n2,Kipas,Cantik,https://example.com/2\n")
+	if err := svc.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if next := svc.Snapshot(); next.Version != 2 || len(next.CSVProducts) != 2 {
+		t.Errorf("expected version 2 with 2 csv products, got %+v", next)
+	}
+}
+
+func TestCommitKeepsCSVReloadedAfterStage(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "config.csv")
+	write := func(body string, mod time.Time) {
+		if err := os.WriteFile(csvPath, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(csvPath, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const header = "ID Produk,Nama Produk,Nama Toko,Link Komisi Ekstra\n"
+	now := time.Now()
+	write(header+"1,Meja,Speeds,https://example.com/1\n", now.Add(-time.Hour))
+	svc, err := NewService("", csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A config reload stages new YAML products, then the CSV watcher commits
+	// a newer CSV before the config reload commits.
+	staged, err := svc.Stage([]models.Product{{Name: "Rotation", URL: "https://example.com/r", Percentage: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(header+"1,Meja,Speeds,https://example.com/1\n2,Kipas,Cantik,https://example.com/2\n", now)
+	if err := svc.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	svc.Commit(staged)
+
+	snap := svc.Snapshot()
+	if len(snap.YAMLProducts) != 1 || len(snap.CSVProducts) != 2 {
+		t.Errorf("expected the staged YAML with the newer CSV, got %d yaml and %d csv products", len(snap.YAMLProducts), len(snap.CSVProducts))
+	}
+}
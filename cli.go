@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go-redirect/models"
+	"go-redirect/utils"
+)
+
+const (
+	defaultConfigPath = "config/config.yaml"
+	defaultCSVPath    = "config/config.csv"
+)
+
+// command is one go-redirect subcommand. run returns the process exit code:
+// 0 on success, 1 on failure and 2 on bad usage.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+func commands() []command {
+	return []command{
+		{"serve", "start the HTTP server (default when no command is given)", runServe},
+		{"validate-config", "check the config and product CSV without starting the server", runValidateConfig},
+		{"print-config", "print the effective config with secrets redacted", runPrintConfig},
+		{"import-products", "validate a product CSV and install it as the catalog", runImportProducts},
+		{"import-conversions", "record conversions from a CSV as postback_received log entries", runImportConversions},
+		{"logs", "query or export the JSONL request logs (logs query|export)", runLogs},
+		{"simulate", "send synthetic traffic through the real handlers and report the split", runSimulate},
+		{"route-explain", "show how a single request URL would be routed", runRouteExplain},
+		{"hash-password", "bcrypt a password read from stdin for admin.users", runHashPassword},
+		{"new-api-key", "generate an admin API key or bypass key and the hash to configure", runNewAPIKey},
+	}
+}
+
+// run dispatches os.Args[1:] to a subcommand.
+func run(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+	for _, cmd := range commands() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stdout)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: go-redirect <command> [flags]")
+	fmt.Fprintln(w)
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-20s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `run "go-redirect <command> -h" for the flags of a command`)
+}
+
+// newFlagSet returns a flag set that reports errors instead of exiting, so every
+// command controls its own exit code.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: go-redirect %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args and maps flag errors to exit code 2 (0 for -h).
+func parseFlags(fs *flag.FlagSet, args []string) (code int, ok bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0, false
+		}
+		return 2, false
+	}
+	return 0, true
+}
+
+// configFlag registers the -config flag every command shares.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", defaultConfigPath, "base config file; GOREDIRECT_PROFILE and GOREDIRECT_* overrides apply on top")
+}
+
+// loadConfig loads the config exactly as serve does and prints problems to stderr.
+func loadConfig(path string) (*models.Config, utils.ConfigLayers, bool) {
+	layers := utils.ConfigLayersFromEnv(path)
+	cfg, err := layers.Load()
+	if err != nil {
+		printConfigError(path, err)
+		return nil, layers, false
+	}
+	return cfg, layers, true
+}
+
+func fail(format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	return 1
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-redirect/utils"
+)
+
+func TestRunExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(bad, []byte("bot_filter: {rate_limt_max: 1}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"no-such-command"}, 2},
+		{[]string{"validate-config", "-no-such-flag"}, 2},
+		{[]string{"validate-config", "-products", "", bad}, 1},
+		{[]string{"logs"}, 2},
+		{[]string{"import-products"}, 2},
+	}
+	for _, tc := range cases {
+		if got := run(tc.args); got != tc.want {
+			t.Errorf("run(%q) = %d, want %d", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestImportConversionsSkipsLoggedSubIDs(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("LOG_PATH", filepath.Join(dir, "logs"))
+	in := filepath.Join(dir, "conv.csv")
+	if err := os.WriteFile(in, []byte("sub_id,payout\nA1,100\nB2,50\nA1,100\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := run([]string{"import-conversions", in}); code != 0 {
+		t.Fatalf("first import exit code %d", code)
+	}
+	if code := run([]string{"import-conversions", in}); code != 0 {
+		t.Fatalf("second import exit code %d", code)
+	}
+
+	got := map[string]int{}
+	if err := utils.ForEachLogEntry(func(e utils.LogEntry) {
+		if e.Type == "postback_received" {
+			got[e.Extra["sub_id"].(string)]++
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got["A1"] != 1 || got["B2"] != 1 || len(got) != 2 {
+		t.Fatalf("postbacks by sub_id = %v, want each once", got)
+	}
+}
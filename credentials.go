@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// runHashPassword implements `go-redirect hash-password`: it reads a password from
+// the first line of stdin, so it stays out of shell history, and prints the bcrypt
+// hash for admin.users[].password_hash.
+func runHashPassword(args []string) int {
+	fs := newFlagSet("hash-password", "< password")
+	cost := fs.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		if err != nil && err != io.EOF {
+			return fail("reading password: %v", err)
+		}
+		return fail("no password on stdin")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		return fail("%v", err)
+	}
+	fmt.Println(string(hash))
+	return 0
+}
+
+// runNewAPIKey implements `go-redirect new-api-key`: it generates a random key to
+// hand to the script and the SHA-256 to put in admin.api_keys[].key_sha256 (or
+// bot_filter.bypass.keys[].key_sha256).
+func runNewAPIKey(args []string) int {
+	fs := newFlagSet("new-api-key", "")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fail("%v", err)
+	}
+	key := hex.EncodeToString(buf)
+	sum := sha256.Sum256([]byte(key))
+	fmt.Printf("key:        %s\n", key)
+	fmt.Printf("key_sha256: %s\n", hex.EncodeToString(sum[:]))
+	return 0
+}
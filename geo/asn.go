@@ -0,0 +1,21 @@
+package geo
+
+import "sort"
+
+// ASNCategories returns the names of the asn_categories asn is listed in, sorted.
+func ASNCategories(categories map[string][]uint, asn uint) []string {
+	if asn == 0 {
+		return nil
+	}
+	var out []string
+	for name, list := range categories {
+		for _, n := range list {
+			if n == asn {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -1,58 +1,93 @@
+// Package geo answers where an IP is and which network announces it. Lookups go
+// through one Service that asks its Provider once per IP and caches the answer.
 package geo
 
 import (
 	"net"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"go-redirect/models"
-
-	"github.com/oschwald/geoip2-golang"
 )
 
-var DB *geoip2.Reader
+// Record is everything the provider knows about one IP.
+type Record struct {
+	Info models.GeoInfo
+	// HasCountry and HasASN are false when no database answering them is loaded;
+	// an IP that is simply not in a loaded database leaves its fields empty.
+	HasCountry bool
+	HasASN     bool
+}
 
-func InitGeoDB(path string) error {
-	var err error
-	DB, err = geoip2.Open(path)
-	return err
+// Provider does the combined lookup. ip is nil for unparsable addresses, in which
+// case only the Has* flags are reported.
+type Provider interface {
+	Lookup(ip net.IP) Record
+	Status() []DBStatus
 }
 
-func GetGeoInfo(ipStr string) models.GeoInfo {
-	if strings.Contains(ipStr, ",") {
-		parts := strings.Split(ipStr, ",")
-		ipStr = strings.TrimSpace(parts[0])
+// DBStatus describes one database behind a provider, for /ready.
+type DBStatus struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path,omitempty"`
+	Loaded    bool      `json:"loaded"`
+	BuildDate time.Time `json:"build_date,omitempty"`
+	LoadedAt  time.Time `json:"loaded_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+var current atomic.Pointer[Service]
+
+// Use makes s the service behind the package-level lookups; set by main.
+func Use(s *Service) {
+	current.Store(s)
+}
+
+// Current returns the service in use, or one without databases before Use.
+func Current() *Service {
+	if s := current.Load(); s != nil {
+		return s
 	}
+	return NewService(nil, 0)
+}
 
-	ip := net.ParseIP(ipStr)
-	if ip == nil || DB == nil {
-		return models.GeoInfo{
-			Country:  "Unknown",
-			Region:   "Unknown",
-			City:     "Unknown",
-			Timezone: "Unknown",
-		}
+// Lookup is Current().Lookup.
+func Lookup(ipStr string) Record {
+	return Current().Lookup(ipStr)
+}
+
+// Close closes the service in use, if any.
+func Close() error {
+	if s := current.Swap(nil); s != nil {
+		return s.Close()
 	}
+	return nil
+}
 
-	record, err := DB.City(ip)
-	if err != nil {
-		return models.GeoInfo{
-			Country:  "Unknown",
-			Region:   "Unknown",
-			City:     "Unknown",
-			Timezone: "Unknown",
+// GetGeoInfo returns the location and network of ipStr for logs, with "Unknown"
+// for what is not known.
+func GetGeoInfo(ipStr string) models.GeoInfo {
+	info := Lookup(ipStr).Info
+	for _, f := range []*string{&info.Country, &info.Region, &info.City, &info.Timezone} {
+		if *f == "" {
+			*f = "Unknown"
 		}
 	}
+	return info
+}
 
-	geo := models.GeoInfo{
-		Country:   record.Country.Names["en"],
-		City:      record.City.Names["en"],
-		Timezone:  record.Location.TimeZone,
-		Latitude:  record.Location.Latitude,
-		Longitude: record.Location.Longitude,
-	}
-	if len(record.Subdivisions) > 0 {
-		geo.Region = record.Subdivisions[0].Names["en"]
-	}
+// LookupASN returns the autonomous system announcing ipStr. ok is false when no
+// ASN database is loaded; asn is 0 when the IP is not in it.
+func LookupASN(ipStr string) (asn uint, org string, ok bool) {
+	r := Lookup(ipStr)
+	return r.Info.ASN, r.Info.ASOrg, r.HasASN
+}
 
-	return geo
+// parseIP takes the first address of a forwarding header value.
+func parseIP(ipStr string) net.IP {
+	if i := strings.Index(ipStr, ","); i >= 0 {
+		ipStr = ipStr[:i]
+	}
+	return net.ParseIP(strings.TrimSpace(ipStr))
 }
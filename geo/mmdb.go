@@ -0,0 +1,170 @@
+package geo
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Paths names the MaxMind databases to open; an empty path skips that database.
+type Paths struct {
+	City    string
+	Country string
+	ASN     string
+}
+
+// MMDB is the Provider backed by GeoLite2 files. Refresh reopens a file whose
+// mtime changed and swaps it in; until the new file opens cleanly the old one
+// keeps serving.
+type MMDB struct {
+	mu                 sync.RWMutex
+	city, country, asn *database
+}
+
+type database struct {
+	name, path string
+	reader     *geoip2.Reader
+	modTime    time.Time
+	loadedAt   time.Time
+	err        error
+}
+
+// OpenMMDB opens the databases in p. Files that fail to open are reported by
+// Status and retried by Refresh.
+func OpenMMDB(p Paths) *MMDB {
+	m := &MMDB{}
+	for _, d := range []struct {
+		slot       **database
+		name, path string
+	}{{&m.city, "city", p.City}, {&m.country, "country", p.Country}, {&m.asn, "asn", p.ASN}} {
+		if d.path == "" {
+			continue
+		}
+		db := &database{name: d.name, path: d.path}
+		db.reopen()
+		*d.slot = db
+	}
+	return m
+}
+
+// reopen loads the file when its mtime differs from the loaded one and reports
+// whether the reader was replaced. The caller must hold the write lock, or own db.
+func (db *database) reopen() bool {
+	st, err := os.Stat(db.path)
+	if err != nil {
+		db.err = err
+		return false
+	}
+	if db.reader != nil && st.ModTime().Equal(db.modTime) {
+		return false
+	}
+	r, err := geoip2.Open(db.path)
+	if err != nil {
+		// Keep the previous reader (if any) and retry on the next mtime change.
+		db.err, db.modTime = err, st.ModTime()
+		return false
+	}
+	if db.reader != nil {
+		db.reader.Close()
+	}
+	db.reader, db.modTime, db.loadedAt, db.err = r, st.ModTime(), time.Now(), nil
+	return true
+}
+
+func (db *database) loaded() bool {
+	return db != nil && db.reader != nil
+}
+
+// Lookup reads country, city and ASN in one pass. The City database answers the
+// country when loaded; the Country database is the fallback.
+func (m *MMDB) Lookup(ip net.IP) Record {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r := Record{
+		HasCountry: m.city.loaded() || m.country.loaded(),
+		HasASN:     m.asn.loaded(),
+	}
+	if ip == nil {
+		return r
+	}
+	if m.city.loaded() {
+		if rec, err := m.city.reader.City(ip); err == nil {
+			r.Info.Country = rec.Country.Names["en"]
+			r.Info.CountryCode = rec.Country.IsoCode
+			r.Info.City = rec.City.Names["en"]
+			r.Info.Timezone = rec.Location.TimeZone
+			r.Info.Latitude = rec.Location.Latitude
+			r.Info.Longitude = rec.Location.Longitude
+			if len(rec.Subdivisions) > 0 {
+				r.Info.Region = rec.Subdivisions[0].Names["en"]
+			}
+		}
+	}
+	if r.Info.CountryCode == "" && m.country.loaded() {
+		if rec, err := m.country.reader.Country(ip); err == nil {
+			r.Info.Country = rec.Country.Names["en"]
+			r.Info.CountryCode = rec.Country.IsoCode
+		}
+	}
+	if m.asn.loaded() {
+		if rec, err := m.asn.reader.ASN(ip); err == nil {
+			r.Info.ASN = rec.AutonomousSystemNumber
+			r.Info.ASOrg = rec.AutonomousSystemOrganization
+		}
+	}
+	return r
+}
+
+// Status reports every configured database.
+func (m *MMDB) Status() []DBStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []DBStatus
+	for _, db := range []*database{m.city, m.country, m.asn} {
+		if db == nil {
+			continue
+		}
+		st := DBStatus{Name: db.name, Path: db.path, Loaded: db.reader != nil, LoadedAt: db.loadedAt}
+		if db.reader != nil {
+			st.BuildDate = time.Unix(int64(db.reader.Metadata().BuildEpoch), 0).UTC()
+		}
+		if db.err != nil {
+			st.Error = db.err.Error()
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// Refresh reopens databases changed on disk and reports whether any was swapped.
+func (m *MMDB) Refresh() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	swapped := false
+	for _, db := range []*database{m.city, m.country, m.asn} {
+		if db != nil && db.reopen() {
+			swapped = true
+		}
+	}
+	return swapped
+}
+
+// Close releases the readers.
+func (m *MMDB) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	for _, db := range []*database{m.city, m.country, m.asn} {
+		if db.loaded() {
+			if cerr := db.reader.Close(); cerr != nil {
+				err = cerr
+			}
+			db.reader = nil
+		}
+	}
+	return err
+}
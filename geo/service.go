@@ -0,0 +1,162 @@
+package geo
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-redirect/models"
+	"go-redirect/utils"
+)
+
+// DefaultCacheSize is the number of IPs a Service remembers.
+const DefaultCacheSize = 50000
+
+// Service caches provider lookups by IP in an LRU.
+type Service struct {
+	provider Provider
+
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+
+	hits, misses atomic.Int64
+}
+
+type cacheEntry struct {
+	key string
+	rec Record
+}
+
+// NewService wraps p; size <= 0 means DefaultCacheSize. A nil provider knows nothing.
+func NewService(p Provider, size int) *Service {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	return &Service{provider: p, size: size, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// Lookup returns the record for ipStr (the first address if it is a list).
+func (s *Service) Lookup(ipStr string) Record {
+	if s.provider == nil {
+		return Record{}
+	}
+	ip := parseIP(ipStr)
+	if ip == nil {
+		return s.provider.Lookup(nil)
+	}
+	key := ip.String()
+
+	s.mu.Lock()
+	if el, ok := s.items[key]; ok {
+		s.order.MoveToFront(el)
+		rec := el.Value.(*cacheEntry).rec
+		s.mu.Unlock()
+		s.hits.Add(1)
+		return rec
+	}
+	s.mu.Unlock()
+
+	s.misses.Add(1)
+	rec := s.provider.Lookup(ip)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		el.Value.(*cacheEntry).rec = rec
+		s.order.MoveToFront(el)
+		return rec
+	}
+	s.items[key] = s.order.PushFront(&cacheEntry{key: key, rec: rec})
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*cacheEntry).key)
+	}
+	return rec
+}
+
+// Purge empties the cache.
+func (s *Service) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.order.Init()
+	s.items = map[string]*list.Element{}
+}
+
+// CacheStatus is the cache part of Status.
+type CacheStatus struct {
+	Size   int   `json:"size"`
+	Len    int   `json:"len"`
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// Status is what /ready reports about geo lookups.
+type Status struct {
+	// Ready is false when no database can answer countries, which turns off the
+	// bot filter's geo rule.
+	Ready     bool        `json:"ready"`
+	Databases []DBStatus  `json:"databases"`
+	Cache     CacheStatus `json:"cache"`
+}
+
+func (s *Service) Status() Status {
+	st := Status{Databases: []DBStatus{}}
+	if s.provider != nil {
+		st.Databases = s.provider.Status()
+		st.Ready = s.provider.Lookup(nil).HasCountry
+	}
+	s.mu.Lock()
+	st.Cache = CacheStatus{Size: s.size, Len: s.order.Len(), Hits: s.hits.Load(), Misses: s.misses.Load()}
+	s.mu.Unlock()
+	return st
+}
+
+// Watch swaps in databases updated on disk, for providers that support it, and
+// drops the cached answers of the old ones.
+func (s *Service) Watch(interval time.Duration) {
+	r, ok := s.provider.(interface{ Refresh() bool })
+	if !ok {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !r.Refresh() {
+			continue
+		}
+		s.Purge()
+		utils.LogInfo(utils.LogEntry{
+			Type:  "geo_db_reloaded",
+			Extra: map[string]interface{}{"databases": s.provider.Status()},
+		})
+	}
+}
+
+// Close releases the provider's databases, if it holds any.
+func (s *Service) Close() error {
+	if c, ok := s.provider.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Fake is an in-memory Provider for tests: each IP maps to its GeoInfo and any
+// other IP is unknown. It reports both countries and ASNs as available.
+type Fake map[string]models.GeoInfo
+
+func (f Fake) Lookup(ip net.IP) Record {
+	r := Record{HasCountry: true, HasASN: true}
+	if ip != nil {
+		r.Info = f[ip.String()]
+	}
+	return r
+}
+
+func (f Fake) Status() []DBStatus {
+	return []DBStatus{{Name: "fake", Loaded: true}}
+}
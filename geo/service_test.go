@@ -0,0 +1,71 @@
+package geo
+
+import (
+	"net"
+	"testing"
+
+	"go-redirect/models"
+)
+
+// countingProvider wraps Fake and counts lookups that reach it.
+type countingProvider struct {
+	Fake
+	calls int
+}
+
+func (p *countingProvider) Lookup(ip net.IP) Record {
+	p.calls++
+	return p.Fake.Lookup(ip)
+}
+
+func TestServiceCachesLookups(t *testing.T) {
+	p := &countingProvider{Fake: Fake{
+		"36.68.1.1": {CountryCode: "ID", ASN: 23693, ASOrg: "Telkomsel"},
+		"8.8.8.8":   {CountryCode: "US", ASN: 15169},
+	}}
+	s := NewService(p, 2)
+
+	if r := s.Lookup("36.68.1.1, 10.0.0.1"); r.Info.CountryCode != "ID" || r.Info.ASN != 23693 || !r.HasCountry {
+		t.Errorf("first address of a forwarded list: %+v", r)
+	}
+	s.Lookup("36.68.1.1")
+	if p.calls != 1 {
+		t.Errorf("provider called %d times for one IP, want 1", p.calls)
+	}
+
+	s.Lookup("8.8.8.8")
+	s.Lookup("1.1.1.1") // evicts 36.68.1.1, the least recently used
+	s.Lookup("36.68.1.1")
+	if p.calls != 4 {
+		t.Errorf("provider called %d times, want 4 after eviction", p.calls)
+	}
+	if st := s.Status(); !st.Ready || st.Cache.Len != 2 || st.Cache.Hits != 1 || st.Cache.Misses != 4 {
+		t.Errorf("status: %+v", st)
+	}
+
+	s.Purge()
+	before := p.calls
+	s.Lookup("8.8.8.8")
+	if p.calls != before+1 {
+		t.Errorf("purge kept cached records")
+	}
+}
+
+func TestGetGeoInfoWithoutDatabases(t *testing.T) {
+	Use(NewService(OpenMMDB(Paths{Country: "does-not-exist.mmdb"}), 0))
+	defer Close()
+
+	info := GetGeoInfo("36.68.1.1")
+	if info.Country != "Unknown" || info.City != "Unknown" || info.ASN != 0 {
+		t.Errorf("GetGeoInfo = %+v", info)
+	}
+	st := Current().Status()
+	if st.Ready || len(st.Databases) != 1 || st.Databases[0].Loaded || st.Databases[0].Error == "" {
+		t.Errorf("status: %+v", st)
+	}
+
+	Use(NewService(Fake{"36.68.1.1": models.GeoInfo{Country: "Indonesia", CountryCode: "ID"}}, 0))
+	if info := GetGeoInfo("36.68.1.1"); info.Country != "Indonesia" || info.City != "Unknown" {
+		t.Errorf("GetGeoInfo with fake = %+v", info)
+	}
+}
@@ -1,7 +1,174 @@
 package handlers
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"bytes"
+	"go-redirect/articles"
+	"go-redirect/catalog"
+	"go-redirect/middleware"
+	"go-redirect/models"
+	"go-redirect/utils"
+	"html/template"
+	"math/rand/v2"
+	"net/url"
+	"sync/atomic"
+	"time"
 
+	"github.com/gofiber/fiber/v2"
+	"github.com/mssola/user_agent"
+)
+
+var productCardTmpl = template.Must(template.New("product-card").Parse(`<a class="product-card" href="{{.Link}}">
+{{if .Product.Image}}<img src="{{.Product.Image}}" alt="{{.Product.Name}}" loading="lazy">{{end}}
+<span class="product-card__body">
+<strong>{{if .Product.Description}}{{.Product.Description}}{{else}}{{.Product.Name}}{{end}}</strong>
+{{if .Product.Name}}<small>{{.Product.Name}}</small>{{end}}
+<span class="cta">🎁 Cek Promo</span>
+</span>
+</a>`))
+
+// legacyArticleSlug is the article the old static /article page became.
+const legacyArticleSlug = "tips-belanja-hemat"
+
+// ArticleHandler sends the old /article URL, query included, to its Markdown
+// article, so its visits are logged and counted with the others.
 func ArticleHandler(c *fiber.Ctx) error {
-	return c.Render("article-1", fiber.Map{})
+	target := "/article/" + legacyArticleSlug
+	if q := string(c.Request().URI().QueryString()); q != "" {
+		target += "?" + q
+	}
+	return c.Redirect(target, fiber.StatusMovedPermanently)
+}
+
+// articleCheckEvery is how often the articles dir is checked for edited files.
+const articleCheckEvery = 5 * time.Second
+
+// articleIndex is the parsed articles dir; a config reload naming another dir
+// replaces it on the next request.
+var articleIndex atomic.Pointer[articles.Index]
+
+func articlesIn(dir string) *articles.Index {
+	if x := articleIndex.Load(); x != nil && x.Dir() == dir {
+		return x
+	}
+	x := articles.NewIndex(dir, articleCheckEvery)
+	articleIndex.Store(x)
+	return x
+}
+
+// ArticlePageHandler renders a Markdown article from the articles dir with its product embeds.
+func ArticlePageHandler(c *fiber.Ctx) error {
+	dir := CurrentSettings().ArticlesDir
+	article, ok := articlesIn(dir).Get(c.Params("slug"), time.Now())
+	if !ok {
+		return c.Status(404).SendString("Article not found")
+	}
+
+	snap := Catalog.Snapshot()
+	var embedded []string
+	body, err := article.Render(
+		func(e articles.Embed) []models.Product {
+			picked := resolveEmbed(snap, e)
+			for _, p := range picked {
+				embedded = append(embedded, p.ID)
+			}
+			return picked
+		},
+		func(p models.Product) (template.HTML, error) {
+			var buf bytes.Buffer
+			err := productCardTmpl.Execute(&buf, fiber.Map{"Product": p, "Link": articleProductLink(article, p)})
+			return template.HTML(buf.String()), err
+		},
+	)
+	if err != nil {
+		return c.Status(500).SendString("Article unavailable")
+	}
+
+	ua := user_agent.New(c.Get("User-Agent"))
+	browser, _ := ua.Browser()
+	device := "Desktop"
+	if ua.Mobile() {
+		device = "Mobile"
+	}
+	queryParams := make(map[string]string)
+	c.Request().URI().QueryArgs().VisitAll(func(k, v []byte) {
+		queryParams[string(k)] = string(v)
+	})
+	utils.LogInfo(utils.LogEntry{
+		Type:        models.TypeRouteArticle,
+		Timestamp:   time.Now(),
+		URL:         c.OriginalURL(),
+		IP:          middleware.ClientIP(c),
+		UserAgent:   c.Get("User-Agent"),
+		Browser:     browser,
+		OS:          ua.OS(),
+		Device:      device,
+		Referer:     c.Get("Referer"),
+		QueryParams: queryParams,
+		Extra: map[string]interface{}{
+			"article":  article.Slug,
+			"campaign": article.Campaign,
+			"products": embedded,
+		},
+	})
+
+	return c.Render("article", fiber.Map{
+		"Title":       article.Title,
+		"Description": article.Description,
+		"PublishDate": article.PublishDate,
+		"Body":        body,
+	})
+}
+
+// resolveEmbed returns the product for an id shortcode, or up to Limit distinct
+// products of a pool picked by Percentage weight.
+func resolveEmbed(snap *catalog.Snapshot, e articles.Embed) []models.Product {
+	if e.ID != "" {
+		if p, ok := snap.Get(e.ID); ok {
+			return []models.Product{p}
+		}
+		return nil
+	}
+
+	var pool []models.Product
+	for _, p := range snap.All() {
+		if p.ID != "" && e.Pool != "" && p.Pool == e.Pool {
+			pool = append(pool, p)
+		}
+	}
+	var picked []models.Product
+	for len(picked) < e.Limit && len(pool) > 0 {
+		total := 0.0
+		for _, p := range pool {
+			total += p.Percentage
+		}
+		i := len(pool) - 1
+		if total > 0 {
+			r := rand.Float64() * total
+			sum := 0.0
+			for j, p := range pool {
+				sum += p.Percentage
+				if r <= sum {
+					i = j
+					break
+				}
+			}
+		}
+		picked = append(picked, pool[i])
+		pool = append(pool[:i], pool[i+1:]...)
+	}
+	return picked
+}
+
+// articleProductLink sends a card through the pre-sale flow, tagged with the article slug.
+func articleProductLink(a *articles.Article, p models.Product) string {
+	q := url.Values{}
+	q.Set("product", p.ID)
+	q.Set("article", a.Slug)
+	if a.Campaign != "" {
+		q.Set("campaign", a.Campaign)
+	}
+	if a.CTA == "direct" {
+		q.Set("redirect", "direct")
+	}
+	return "/pre-sale?" + q.Encode()
 }
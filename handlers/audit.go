@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"go-redirect/audit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AuditHandler serves GET /admin/audit: the audit trail, newest first, filtered by
+// ?actor=, ?action=, ?since= and ?until= (RFC 3339 or YYYY-MM-DD) and ?limit=
+// (default 200).
+func AuditHandler(c *fiber.Ctx) error {
+	f := audit.Filter{Actor: c.Query("actor"), Action: c.Query("action"), Limit: 200}
+	for param, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		t, err := parseAuditTime(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": param + ": " + err.Error()})
+		}
+		*dst = t
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be a non-negative integer"})
+		}
+		f.Limit = n
+	}
+	entries, err := audit.Query(f)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"entries": entries, "count": len(entries)})
+}
+
+func parseAuditTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
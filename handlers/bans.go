@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"go-redirect/audit"
+	"go-redirect/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BansHandler serves GET /admin/bans: the bans in effect, soonest to expire first.
+func BansHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"bans": middleware.Bans()})
+}
+
+// ExtendBanHandler serves POST /admin/bans/:id/extend with {"duration": "6h"}.
+func ExtendBanHandler(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid ban id"})
+	}
+	var req struct {
+		Duration string `json:"duration"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "duration must be positive, e.g. 6h"})
+	}
+	name, _ := middleware.AdminPrincipal(c)
+	before, after, ok, err := middleware.ExtendBan(id, d, name)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no such ban"})
+	}
+
+	entry := audit.FromRequest(c)
+	entry.Action = "bot_filter.ban_extend"
+	entry.Target = "ban:" + after.Key
+	entry.Before, entry.After = before, after
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(entry)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "ban extended but not persisted: " + err.Error()})
+	}
+	return c.JSON(fiber.Map{"success": true, "ban": after})
+}
+
+// LiftBanHandler serves DELETE /admin/bans/:id, ending a ban early.
+func LiftBanHandler(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid ban id"})
+	}
+	ban, ok, err := middleware.LiftBan(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no such ban"})
+	}
+
+	entry := audit.FromRequest(c)
+	entry.Action = "bot_filter.ban_lift"
+	entry.Target = "ban:" + ban.Key
+	entry.Before = ban
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(entry)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "ban lifted but not persisted: " + err.Error()})
+	}
+	return c.JSON(fiber.Map{"success": true, "lifted": ban})
+}
@@ -1,15 +1,25 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
+	"time"
+
+	"go-redirect/audit"
 	"go-redirect/middleware"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// ToggleBotFilterHandler toggles bot filter on/off
+// ToggleBotFilterHandler toggles bot filter on/off. The optional scope limits the
+// toggle to a route or campaign (enable only), at delays it and duration makes it
+// temporary, e.g. {"enabled": false, "scope": "route:/pre-sale", "duration": "30m"}.
 func ToggleBotFilterHandler(c *fiber.Ctx) error {
 	type ToggleRequest struct {
-		Enabled bool `json:"enabled"`
+		Enabled  bool   `json:"enabled"`
+		Scope    string `json:"scope"`
+		At       string `json:"at"`
+		Duration string `json:"duration"`
 	}
 
 	var req ToggleRequest
@@ -18,15 +28,60 @@ func ToggleBotFilterHandler(c *fiber.Ctx) error {
 			"error": "Invalid request body",
 		})
 	}
+	scope, err := middleware.ParseBotFilterScope(req.Scope)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	name, _ := middleware.AdminPrincipal(c)
+	toggle := middleware.BotToggle{Scope: scope, Enabled: req.Enabled, SetBy: name, SetAt: time.Now()}
+	if req.At != "" {
+		if toggle.From, err = time.Parse(time.RFC3339, req.At); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "at must be an RFC 3339 time"})
+		}
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "duration must be positive, e.g. 30m"})
+		}
+		start := toggle.From
+		if start.IsZero() {
+			start = toggle.SetAt
+		}
+		toggle.Until = start.Add(d)
+	}
 
 	// Set bot filter state
-	middleware.SetBotFilterEnabled(req.Enabled)
+	before := middleware.BotFilterEnabledFor(scope)
+	toggle, err = middleware.ScheduleBotFilter(toggle)
+	if errors.Is(err, middleware.ErrCampaignScopeDisable) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
 
+	entry := audit.FromRequest(c)
+	entry.Action = "bot_filter.toggle"
+	entry.Target = "bot_filter:" + scope
+	entry.Before, entry.After = before, toggle
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(entry)
+	if err != nil {
+		// Applied in memory, but lost on restart.
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "toggle applied but not persisted: " + err.Error()})
+	}
+
+	enabled := middleware.BotFilterEnabledFor(scope)
 	return c.JSON(fiber.Map{
 		"success": true,
-		"enabled": req.Enabled,
+		"enabled": enabled,
+		"scope":   scope,
+		"toggle":  toggle,
 		"message": func() string {
-			if req.Enabled {
+			if toggle.From.After(time.Now()) {
+				return "Bot filter change scheduled"
+			}
+			if enabled {
 				return "Bot filter enabled"
 			}
 			return "Bot filter disabled"
@@ -34,9 +89,42 @@ func ToggleBotFilterHandler(c *fiber.Ctx) error {
 	})
 }
 
-// BotFilterStatusHandler returns current bot filter status
+// CancelBotFilterToggleHandler serves DELETE /bot-filter-toggles/:id: it ends a
+// temporary toggle early or drops a scheduled one.
+func CancelBotFilterToggleHandler(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid toggle id"})
+	}
+	toggle, ok, err := middleware.CancelBotFilterToggle(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no such toggle"})
+	}
+
+	entry := audit.FromRequest(c)
+	entry.Action = "bot_filter.cancel"
+	entry.Target = "bot_filter:" + toggle.Scope
+	entry.Before = toggle
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(entry)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "toggle cancelled but not persisted: " + err.Error()})
+	}
+	return c.JSON(fiber.Map{"success": true, "cancelled": toggle, "enabled": middleware.BotFilterEnabledFor(toggle.Scope)})
+}
+
+// BotFilterStatusHandler returns current bot filter status: the global state, the
+// effective state of every scope with a toggle, and the toggles in effect or
+// scheduled.
 func BotFilterStatusHandler(c *fiber.Ctx) error {
 	enabled := middleware.IsBotFilterEnabled()
+	active, scheduled := middleware.BotFilterToggles()
+	scopes := map[string]bool{}
+	for _, t := range active {
+		scopes[t.Scope] = middleware.BotFilterEnabledFor(t.Scope)
+	}
 
 	return c.JSON(fiber.Map{
 		"enabled": enabled,
@@ -46,5 +134,8 @@ func BotFilterStatusHandler(c *fiber.Ctx) error {
 			}
 			return "inactive"
 		}(),
+		"scopes":    scopes,
+		"toggles":   active,
+		"scheduled": scheduled,
 	})
 }
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"time"
+
+	"go-redirect/audit"
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultBypassLinkTTL = 24 * time.Hour
+
+// BypassLinkHandler serves POST /admin/bypass-links: it signs a bypass token for a
+// QA device, e.g. {"name": "qa-pixel-7", "ttl": "8h"}. The TTL defaults to, and
+// may not exceed, bot_filter.bypass.link_ttl_sec.
+func BypassLinkHandler(c *fiber.Ctx) error {
+	cfg := CurrentSettings().Bypass
+	if cfg.LinkSecret == "" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "signed bypass links need bot_filter.bypass.link_secret"})
+	}
+	var req struct {
+		Name string `json:"name"`
+		TTL  string `json:"ttl"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+	}
+	maxTTL := defaultBypassLinkTTL
+	if cfg.LinkTTLSec > 0 {
+		maxTTL = time.Duration(cfg.LinkTTLSec) * time.Second
+	}
+	ttl := maxTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > maxTTL {
+			return c.Status(400).JSON(fiber.Map{"error": "ttl must be positive and at most " + maxTTL.String()})
+		}
+		ttl = d
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	token := utils.SignBypass([]byte(cfg.LinkSecret), utils.BypassClaims{Name: req.Name, ExpiresAt: expires.Unix()})
+
+	entry := audit.FromRequest(c)
+	entry.Action = "bot_filter.bypass_link"
+	entry.Target = "bypass:" + req.Name
+	entry.After = fiber.Map{"name": req.Name, "expires": expires}
+	audit.Record(entry)
+	return c.JSON(fiber.Map{
+		"name":    req.Name,
+		"expires": expires,
+		"token":   token,
+		"query":   "bypass=" + token,
+	})
+}
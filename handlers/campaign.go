@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"go-redirect/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// campaignFor returns the campaign selected by the `campaign` query param, or nil.
+func campaignFor(c *fiber.Ctx) *models.Campaign {
+	id := c.Query("campaign")
+	if id == "" {
+		return nil
+	}
+	campaigns := CurrentSettings().Campaigns
+	for i := range campaigns {
+		if campaigns[i].ID == id {
+			return &campaigns[i]
+		}
+	}
+	return nil
+}
+
+// passthroughFor returns the campaign's passthrough policy, falling back to the global one.
+func passthroughFor(c *fiber.Ctx) models.PassthroughPolicy {
+	if cp := campaignFor(c); cp != nil && cp.Passthrough != nil {
+		return *cp.Passthrough
+	}
+	return CurrentSettings().Passthrough
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"go-redirect/models"
+	"go-redirect/utils"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const variantCookiePrefix = "presale_v_"
+
+// controlVariant is served when no experiment is configured.
+var controlVariant = models.PreSaleVariant{Name: "control", Template: "pre-sale", Weight: 1}
+
+// experimentKey names the experiment a request belongs to: its campaign, or "default".
+func experimentKey(c *fiber.Ctx) string {
+	if cp := campaignFor(c); cp != nil && cp.PreSale != nil {
+		return cp.ID
+	}
+	return "default"
+}
+
+// experimentFor returns the campaign's pre-sale experiment, falling back to the global one.
+func experimentFor(c *fiber.Ctx) models.PreSaleExperiment {
+	if cp := campaignFor(c); cp != nil && cp.PreSale != nil {
+		return *cp.PreSale
+	}
+	return CurrentSettings().PreSale.Experiment
+}
+
+// pickVariant assigns the visitor a variant. The assignment is sticky: an existing
+// cookie wins while its variant is still live, otherwise the visitor fingerprint is
+// hashed into the weight distribution so cookieless revisits land in the same bucket.
+func pickVariant(c *fiber.Ctx, exp models.PreSaleExperiment, visitor string) models.PreSaleVariant {
+	if len(exp.Variants) == 0 {
+		return controlVariant
+	}
+	if exp.Winner != "" {
+		if v, ok := findVariant(exp, exp.Winner); ok {
+			return v
+		}
+	}
+
+	key := experimentKey(c)
+	cookie := variantCookiePrefix + key
+	if name := c.Cookies(cookie); name != "" {
+		if v, ok := findVariant(exp, name); ok && v.Weight > 0 {
+			return v
+		}
+	}
+
+	total := 0.0
+	for _, v := range exp.Variants {
+		if v.Weight > 0 {
+			total += v.Weight
+		}
+	}
+	chosen := exp.Variants[0]
+	if total > 0 {
+		sum := sha256.Sum256([]byte(key + "|" + visitor))
+		r := float64(binary.BigEndian.Uint64(sum[:8])) / float64(math.MaxUint64) * total
+		acc := 0.0
+		for _, v := range exp.Variants {
+			if v.Weight <= 0 {
+				continue
+			}
+			acc += v.Weight
+			chosen = v
+			if r < acc {
+				break
+			}
+		}
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     cookie,
+		Value:    chosen.Name,
+		Path:     "/",
+		Expires:  time.Now().Add(30 * 24 * time.Hour),
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+	return chosen
+}
+
+func findVariant(exp models.PreSaleExperiment, name string) (models.PreSaleVariant, bool) {
+	for _, v := range exp.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return models.PreSaleVariant{}, false
+}
+
+// VariantStats is one arm of an experiment. CTR is verified clicks per impression,
+// CVR is conversions per verified click; intervals are 95% Wilson score intervals.
+type VariantStats struct {
+	Name        string     `json:"name"`
+	Template    string     `json:"template"`
+	Weight      float64    `json:"weight"`
+	Impressions int        `json:"impressions"`
+	Clicks      int        `json:"clicks"`
+	Conversions int        `json:"conversions"`
+	CTR         float64    `json:"ctr"`
+	CTRInterval [2]float64 `json:"ctr_ci"`
+	CVR         float64    `json:"cvr"`
+	CVRInterval [2]float64 `json:"cvr_ci"`
+}
+
+type ExperimentStats struct {
+	Key      string          `json:"key"`
+	Winner   string          `json:"winner,omitempty"`
+	Variants []*VariantStats `json:"variants"`
+}
+
+// ExperimentsHandler reports per-variant impressions, click-throughs and conversions
+// for every configured pre-sale experiment.
+func ExperimentsHandler(c *fiber.Ctx) error {
+	experiments := map[string]*ExperimentStats{}
+	var order []string
+	arm := func(key, name string) *VariantStats {
+		exp, ok := experiments[key]
+		if !ok {
+			exp = &ExperimentStats{Key: key}
+			experiments[key] = exp
+			order = append(order, key)
+		}
+		for _, v := range exp.Variants {
+			if v.Name == name {
+				return v
+			}
+		}
+		v := &VariantStats{Name: name}
+		exp.Variants = append(exp.Variants, v)
+		return v
+	}
+	register := func(key string, exp models.PreSaleExperiment) {
+		for _, v := range exp.Variants {
+			s := arm(key, v.Name)
+			s.Template, s.Weight = v.Template, v.Weight
+		}
+		if exp.Winner != "" {
+			experiments[key].Winner = exp.Winner
+		}
+	}
+	cfg := CurrentSettings()
+	if len(cfg.PreSale.Experiment.Variants) > 0 {
+		register("default", cfg.PreSale.Experiment)
+	}
+	for _, cp := range cfg.Campaigns {
+		if cp.PreSale != nil && len(cp.PreSale.Variants) > 0 {
+			register(cp.ID, *cp.PreSale)
+		}
+	}
+
+	// Conversions are joined to clicks through the sub_id forwarded to the merchant.
+	// An impression counts one click however often its hand-off is followed, and
+	// a sub_id one conversion however many postbacks report it.
+	clickBySubID := map[string]*VariantStats{}
+	clicked := map[string]bool{}
+	converted := map[string]bool{}
+	err := utils.ForEachLogEntry(func(entry utils.LogEntry) {
+		variant, _ := entry.Extra["variant"].(string)
+		key, _ := entry.Extra["experiment"].(string)
+		switch entry.Type {
+		case models.TypeRoutePreSale:
+			if variant != "" && key != "" {
+				arm(key, variant).Impressions++
+			}
+		case models.TypeRouteRedirect:
+			if variant == "" || key == "" || entry.Extra["presale_handoff"] != HandoffVerified {
+				return
+			}
+			if impID, _ := entry.Extra["impression_id"].(string); impID != "" {
+				if clicked[impID] {
+					return
+				}
+				clicked[impID] = true
+			}
+			s := arm(key, variant)
+			s.Clicks++
+			if subID := entry.QueryParams["sub_id"]; subID != "" {
+				clickBySubID[subID] = s
+			}
+		case "postback_received":
+			subID, _ := entry.Extra["sub_id"].(string)
+			if s, ok := clickBySubID[subID]; ok && subID != "" && !converted[subID] {
+				s.Conversions++
+				converted[subID] = true
+			}
+		}
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	resp := make([]*ExperimentStats, 0, len(order))
+	for _, key := range order {
+		exp := experiments[key]
+		for _, v := range exp.Variants {
+			v.CTR, v.CTRInterval = wilson(v.Clicks, v.Impressions)
+			v.CVR, v.CVRInterval = wilson(v.Conversions, v.Clicks)
+		}
+		resp = append(resp, exp)
+	}
+
+	return c.JSON(fiber.Map{
+		"generated_at": time.Now(),
+		"experiments":  resp,
+	})
+}
+
+// wilson returns the observed rate and its 95% Wilson score interval.
+func wilson(successes, trials int) (float64, [2]float64) {
+	if trials == 0 {
+		return 0, [2]float64{0, 0}
+	}
+	const z = 1.96
+	n := float64(trials)
+	// Successes can outnumber trials, e.g. clicks on impressions logged before
+	// the files read; a rate above 1 would make the interval NaN.
+	p := math.Max(0, math.Min(1, float64(successes)/n))
+	denom := 1 + z*z/n
+	center := (p + z*z/(2*n)) / denom
+	margin := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n)) / denom
+	return p, [2]float64{math.Max(0, center-margin), math.Min(1, center+margin)}
+}
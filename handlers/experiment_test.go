@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"go-redirect/models"
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPickVariant(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	exp := models.PreSaleExperiment{Variants: []models.PreSaleVariant{
+		{Name: "a", Template: "pre-sale", Weight: 50},
+		{Name: "b", Template: "pre-sale-minimal", Weight: 50},
+		{Name: "off", Template: "pre-sale", Weight: 0},
+	}}
+
+	run := func(exp models.PreSaleExperiment, visitor, cookie string) (string, string) {
+		var got models.PreSaleVariant
+		app := fiber.New()
+		app.Get("/pre-sale", func(c *fiber.Ctx) error {
+			got = pickVariant(c, exp, visitor)
+			return nil
+		})
+		req := httptest.NewRequest("GET", "/pre-sale", nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", variantCookiePrefix+"default="+cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("app.Test failed: %v", err)
+		}
+		return got.Name, resp.Header.Get("Set-Cookie")
+	}
+
+	first, setCookie := run(exp, "visitor-1", "")
+	if setCookie == "" {
+		t.Errorf("expected variant cookie to be set")
+	}
+	if again, _ := run(exp, "visitor-1", ""); again != first {
+		t.Errorf("expected sticky assignment %s, got %s", first, again)
+	}
+	if got, _ := run(exp, "visitor-2", "b"); got != "b" {
+		t.Errorf("expected cookie variant b, got %s", got)
+	}
+	if got, _ := run(exp, "visitor-2", "off"); got == "off" {
+		t.Errorf("zero-weight variant must not be kept from cookie")
+	}
+
+	exp.Winner = "b"
+	if got, _ := run(exp, "visitor-1", "a"); got != "b" {
+		t.Errorf("expected promoted winner b, got %s", got)
+	}
+	if got, _ := run(models.PreSaleExperiment{}, "visitor-1", ""); got != controlVariant.Name {
+		t.Errorf("expected control without experiment, got %s", got)
+	}
+}
+
+func TestWilson(t *testing.T) {
+	p, ci := wilson(50, 100)
+	if p != 0.5 || ci[0] > 0.41 || ci[0] < 0.39 || ci[1] < 0.59 || ci[1] > 0.61 {
+		t.Errorf("unexpected wilson(50,100): %v %v", p, ci)
+	}
+	if p, ci := wilson(0, 0); p != 0 || ci != [2]float64{0, 0} {
+		t.Errorf("expected zero interval without trials, got %v %v", p, ci)
+	}
+	if p, ci := wilson(5, 3); p != 1 || math.IsNaN(ci[0]) || math.IsNaN(ci[1]) || ci[1] != 1 {
+		t.Errorf("expected a clamped rate when successes exceed trials, got %v %v", p, ci)
+	}
+}
+
+func TestExperimentsHandlerCountsClicksOncePerImpression(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	ApplySettings(&Settings{PreSale: models.PreSale{Experiment: models.PreSaleExperiment{
+		Variants: []models.PreSaleVariant{{Name: "a", Template: "pre-sale", Weight: 1}},
+	}}})
+	extra := func(kv ...string) map[string]interface{} {
+		m := map[string]interface{}{}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i]] = kv[i+1]
+		}
+		return m
+	}
+	click := func(impression, subID string) utils.LogEntry {
+		return utils.LogEntry{
+			Type:        models.TypeRouteRedirect,
+			QueryParams: map[string]string{"sub_id": subID},
+			Extra:       extra("variant", "a", "experiment", "default", "presale_handoff", HandoffVerified, "impression_id", impression),
+		}
+	}
+	// One impression in the logs, clicks on two more whose impressions were
+	// logged before them, a second CTA on imp1 and a postback sent twice.
+	for _, e := range []utils.LogEntry{
+		{Type: models.TypeRoutePreSale, Extra: extra("variant", "a", "experiment", "default", "impression_id", "imp1")},
+		click("imp1", "s1"),
+		click("imp1", "s1"),
+		click("imp0", "s2"),
+		click("imp-1", "s3"),
+		{Type: "postback_received", Extra: extra("sub_id", "s1")},
+		{Type: "postback_received", Extra: extra("sub_id", "s1")},
+	} {
+		utils.LogInfo(e)
+	}
+
+	app := fiber.New()
+	app.Get("/", ExperimentsHandler)
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	var out struct {
+		Experiments []ExperimentStats `json:"experiments"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Experiments) != 1 || len(out.Experiments[0].Variants) != 1 {
+		t.Fatalf("unexpected experiments: %+v", out.Experiments)
+	}
+	v := out.Experiments[0].Variants[0]
+	if v.Impressions != 1 || v.Clicks != 3 || v.Conversions != 1 || v.CTR != 1 || v.CTRInterval[1] != 1 {
+		t.Errorf("unexpected stats: %+v", *v)
+	}
+}
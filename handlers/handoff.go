@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"go-redirect/models"
+	"go-redirect/utils"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Hand-off status values recorded as extra.presale_handoff on redirect logs.
+const (
+	HandoffVerified        = "verified"
+	HandoffMissing         = "missing"
+	HandoffMalformed       = "malformed"
+	HandoffForged          = "forged"
+	HandoffExpired         = "expired"
+	HandoffVisitorMismatch = "visitor_mismatch"
+	// HandoffReplayed is a genuine token whose impression already clicked through,
+	// e.g. a second CTA or the back button. It is redirected but not counted.
+	HandoffReplayed = "replayed"
+	// HandoffUnsigned means pre_sale.handoff_secret is not set, so no token was
+	// issued or could be checked.
+	HandoffUnsigned = "unsigned"
+)
+
+const defaultHandoffTTL = 30 * time.Minute
+
+// handoffResult is stored in c.Locals("presale_handoff") by RedirectHandler.
+type handoffResult struct {
+	Status string
+	Claims utils.HandoffClaims
+}
+
+// issueHandoff signs a token for a pre-sale impression of product. It returns ""
+// when no hand-off secret is configured.
+func issueHandoff(c *fiber.Ctx, product models.Product, ip, impressionID, variant string) string {
+	cfg := CurrentSettings()
+	if len(cfg.HandoffSecret) == 0 {
+		return ""
+	}
+	ttl := defaultHandoffTTL
+	if cfg.PreSale.HandoffTTLSec > 0 {
+		ttl = time.Duration(cfg.PreSale.HandoffTTLSec) * time.Second
+	}
+	now := time.Now()
+	claims := utils.HandoffClaims{
+		ProductID:    product.ID,
+		Campaign:     c.Query("campaign"),
+		Article:      c.Query("article"),
+		Visitor:      utils.VisitorFingerprint(ip, c.Get("User-Agent")),
+		ImpressionID: impressionID,
+		Variant:      variant,
+		IssuedAt:     now.Unix(),
+		ExpiresAt:    now.Add(ttl).Unix(),
+	}
+	return utils.SignHandoff(cfg.HandoffSecret, claims)
+}
+
+// checkHandoff classifies the hand-off of a request claiming to come from pre-sale.
+// A valid token must also match the product and campaign params it travels with.
+func checkHandoff(c *fiber.Ctx, ip string) handoffResult {
+	if len(CurrentSettings().HandoffSecret) == 0 {
+		return handoffResult{Status: HandoffUnsigned}
+	}
+	token := c.Query("handoff")
+	if token == "" {
+		return handoffResult{Status: HandoffMissing}
+	}
+	claims, err := utils.VerifyHandoff(CurrentSettings().HandoffSecret, token, time.Now())
+	switch {
+	case errors.Is(err, utils.ErrHandoffExpired):
+		return handoffResult{Status: HandoffExpired, Claims: claims}
+	case errors.Is(err, utils.ErrHandoffForged):
+		return handoffResult{Status: HandoffForged}
+	case err != nil:
+		return handoffResult{Status: HandoffMalformed}
+	}
+	if claims.ProductID != c.Query("product") || claims.Campaign != c.Query("campaign") || claims.Article != c.Query("article") {
+		return handoffResult{Status: HandoffForged}
+	}
+	if claims.Visitor != utils.VisitorFingerprint(ip, c.Get("User-Agent")) {
+		return handoffResult{Status: HandoffVisitorMismatch, Claims: claims}
+	}
+	if !spendImpression(claims.ImpressionID, time.Unix(claims.ExpiresAt, 0)) {
+		return handoffResult{Status: HandoffReplayed, Claims: claims}
+	}
+	return handoffResult{Status: HandoffVerified, Claims: claims}
+}
+
+// Impressions whose hand-off has been used, kept until the token expires.
+var (
+	spentMu       sync.Mutex
+	spent         = map[string]time.Time{}
+	spentPrunedAt time.Time
+)
+
+const spentPruneEvery = time.Minute
+
+// spendImpression marks the impression's hand-off as used and reports whether
+// this was its first use.
+func spendImpression(id string, expires time.Time) bool {
+	now := time.Now()
+	spentMu.Lock()
+	defer spentMu.Unlock()
+	if now.Sub(spentPrunedAt) > spentPruneEvery {
+		for k, until := range spent {
+			if now.After(until) {
+				delete(spent, k)
+			}
+		}
+		spentPrunedAt = now
+	}
+	if _, ok := spent[id]; ok {
+		return false
+	}
+	spent[id] = expires
+	return true
+}
+
+// handoffExtra returns the log fields describing the request's hand-off, if any.
+func handoffExtra(c *fiber.Ctx) map[string]interface{} {
+	res, ok := c.Locals("presale_handoff").(handoffResult)
+	if !ok {
+		return nil
+	}
+	extra := map[string]interface{}{"presale_handoff": res.Status}
+	if res.Claims.ImpressionID != "" {
+		extra["impression_id"] = res.Claims.ImpressionID
+		extra["impression_time"] = time.Unix(res.Claims.IssuedAt, 0)
+		extra["time_to_click_ms"] = time.Since(time.Unix(res.Claims.IssuedAt, 0)).Milliseconds()
+	}
+	if res.Claims.Variant != "" {
+		extra["variant"] = res.Claims.Variant
+		extra["experiment"] = experimentKey(c)
+	}
+	return extra
+}
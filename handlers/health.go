@@ -4,6 +4,8 @@ import (
 	"runtime"
 	"time"
 
+	"go-redirect/geo"
+
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -29,14 +31,28 @@ func HealthHandler(c *fiber.Ctx) error {
 	})
 }
 
-// ReadinessHandler checks if all dependencies are ready
+// ReadinessHandler reports whether the instance can serve traffic as configured.
+// It is not ready (503) while no GeoIP database answers countries; the geo block
+// lists every database with its build date and the lookup cache.
 func ReadinessHandler(c *fiber.Ctx) error {
-	// TODO: Add checks for database, geo db, etc.
+	geoStatus := geo.Current().Status()
+	geoCheck := "ok"
+	for _, db := range geoStatus.Databases {
+		if !db.Loaded {
+			geoCheck = "degraded"
+		}
+	}
+	if !geoStatus.Ready {
+		geoCheck = "unavailable"
+		c.Status(fiber.StatusServiceUnavailable)
+	}
+
 	return c.JSON(fiber.Map{
-		"ready": true,
+		"ready": geoStatus.Ready,
 		"checks": fiber.Map{
-			"geo_db": "ok", // Will be dynamic later
+			"geo_db": geoCheck,
 			"config": "ok",
 		},
+		"geo": geoStatus,
 	})
 }
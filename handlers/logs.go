@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"go-redirect/models"
 	"go-redirect/utils"
 	"os"
 	"path/filepath"
@@ -29,11 +30,21 @@ type LogsResponse struct {
 	CitySummary    map[string]int `json:"city_summary"`    // City analytics
 	RegionSummary  map[string]int `json:"region_summary"`  // Region analytics
 	ProductSummary map[string]int `json:"product_summary"` // Essential: business metrics
+	PresaleFunnel  PresaleFunnel  `json:"presale_funnel"`  // Pre-sale impressions vs verified clicks
 
 	// === RAW LOGS (shown last) ===
 	Logs []utils.LogEntry `json:"logs"`
 }
 
+// PresaleFunnel attributes verified hand-off clicks to their pre-sale impressions.
+type PresaleFunnel struct {
+	Impressions        int            `json:"impressions"`
+	VerifiedClicks     int            `json:"verified_clicks"`
+	ClickedImpressions int            `json:"clicked_impressions"`
+	CTR                float64        `json:"ctr"`
+	HandoffStatus      map[string]int `json:"handoff_status"`
+}
+
 func LogsHandler(c *fiber.Ctx) error {
 	// Use /logs for production (Fly.io volume), ./logs for development
 	folder := os.Getenv("LOG_PATH")
@@ -59,7 +70,10 @@ func LogsHandler(c *fiber.Ctx) error {
 		CitySummary:    make(map[string]int),
 		RegionSummary:  make(map[string]int),
 		Logs:           []utils.LogEntry{},
+		PresaleFunnel:  PresaleFunnel{HandoffStatus: make(map[string]int)},
 	}
+	impressions := map[string]struct{}{}
+	clicked := map[string]struct{}{}
 
 	for _, filename := range files {
 		file, err := os.Open(filename)
@@ -104,6 +118,18 @@ func LogsHandler(c *fiber.Ctx) error {
 					resp.TypeAdsSummary[typeAds]++
 				}
 
+				impID, _ := entry.Extra["impression_id"].(string)
+				if entry.Type == models.TypeRoutePreSale && impID != "" {
+					impressions[impID] = struct{}{}
+				}
+				if status, ok := entry.Extra["presale_handoff"].(string); ok && entry.Type == models.TypeRouteRedirect {
+					resp.PresaleFunnel.HandoffStatus[status]++
+					if status == HandoffVerified && impID != "" {
+						resp.PresaleFunnel.VerifiedClicks++
+						clicked[impID] = struct{}{}
+					}
+				}
+
 				// Enhanced geo data processing
 				if geo, ok := entry.Extra["geo"].(map[string]interface{}); ok {
 					if country, ok := geo["country"].(string); ok && country != "" {
@@ -130,6 +156,7 @@ func LogsHandler(c *fiber.Ctx) error {
 	}
 
 	resp.TotalLogs = len(resp.Logs)
+	resp.PresaleFunnel.summarize(impressions, clicked)
 
 	sortMapKeys(resp.TypeSummary)
 	sortMapKeys(resp.ProductSummary)
@@ -145,6 +172,21 @@ func LogsHandler(c *fiber.Ctx) error {
 	return c.JSON(resp)
 }
 
+// summarize counts impressions and those with at least one verified click.
+// A click whose impression is outside the scanned logs is not counted as clicked.
+func (f *PresaleFunnel) summarize(impressions, clicked map[string]struct{}) {
+	f.Impressions = len(impressions)
+	f.ClickedImpressions = 0
+	for id := range clicked {
+		if _, ok := impressions[id]; ok {
+			f.ClickedImpressions++
+		}
+	}
+	if f.Impressions > 0 {
+		f.CTR = float64(f.ClickedImpressions) / float64(f.Impressions)
+	}
+}
+
 // extractDomainFromReferer extracts domain from referer URL
 func extractDomainFromReferer(referer string) string {
 	if referer == "" {
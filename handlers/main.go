@@ -1,23 +1,27 @@
 package handlers
 
 import (
+	"go-redirect/catalog"
+	"go-redirect/models"
 	"go-redirect/utils"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func MainHandler(c *fiber.Ctx) error {
-	products, err := utils.LoadProductsCSV("config/config.csv")
-	if err != nil {
-		utils.LogInfo(utils.LogEntry{
-			Type: "load_csv_error",
-			Extra: map[string]interface{}{
-				"file":  "config/config.csv",
-				"error": err.Error(),
-			},
-		})
-	}
+// catalogSorts are the sort options offered on /main, in display order.
+var catalogSorts = []struct{ Value, Label string }{
+	{catalog.SortDefault, "Rekomendasi"},
+	{catalog.SortCommission, "Komisi tertinggi"},
+	{catalog.SortSales, "Terlaris"},
+	{catalog.SortPriceAsc, "Harga terendah"},
+	{catalog.SortPriceDesc, "Harga tertinggi"},
+}
 
+func MainHandler(c *fiber.Ctx) error {
+	products := browsableProducts()
 	if len(products) == 0 {
 		utils.LogInfo(utils.LogEntry{
 			Type: "no_products_configured",
@@ -28,13 +32,83 @@ func MainHandler(c *fiber.Ctx) error {
 		return c.Status(404).SendString("No products configured")
 	}
 
-	utils.LogInfo(utils.LogEntry{
-		Type: "products_loaded",
-		Extra: map[string]interface{}{
-			"count": len(products),
-			"file":  "config/config.csv",
-		},
+	res := catalog.Search(products, catalog.ParseQuery(func(k string) string { return c.Query(k) }))
+	q := res.Query
+
+	type link struct {
+		Name   string
+		Count  int
+		URL    string
+		Active bool
+	}
+	categories := []link{{Name: "Semua", Count: res.Matched, URL: catalogURL(q, "category", ""), Active: q.Category == ""}}
+	for _, f := range res.Categories {
+		categories = append(categories, link{f.Name, f.Count, catalogURL(q, "category", f.Name), strings.EqualFold(f.Name, q.Category)})
+	}
+	type option struct {
+		Value, Label string
+		Selected     bool
+	}
+	var sorts []option
+	for _, s := range catalogSorts {
+		sorts = append(sorts, option{s.Value, s.Label, s.Value == q.Sort})
+	}
+	var prevURL, nextURL string
+	if q.Page > 1 {
+		prevURL = catalogURL(q, "page", strconv.Itoa(q.Page-1))
+	}
+	if q.Page < res.Pages {
+		nextURL = catalogURL(q, "page", strconv.Itoa(q.Page+1))
+	}
+
+	return c.Render("main", fiber.Map{
+		"Result":     res,
+		"Categories": categories,
+		"Sorts":      sorts,
+		"PrevURL":    prevURL,
+		"NextURL":    nextURL,
 	})
+}
 
-	return c.Render("main", products)
+// ProductsAPIHandler serves the /main catalog query as JSON for other landing pages.
+func ProductsAPIHandler(c *fiber.Ctx) error {
+	return c.JSON(catalog.Search(browsableProducts(), catalog.ParseQuery(func(k string) string { return c.Query(k) })))
+}
+
+// browsableProducts are catalog products with an ID, i.e. those the pre-sale page can show.
+func browsableProducts() []models.Product {
+	var out []models.Product
+	for _, p := range Catalog.Snapshot().All() {
+		if p.ID != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// catalogURL returns the /main URL for q with one param replaced. Changing any filter
+// resets pagination.
+func catalogURL(q catalog.Query, key, value string) string {
+	v := url.Values{}
+	set := func(k, val string) {
+		if val != "" {
+			v.Set(k, val)
+		}
+	}
+	set("q", q.Text)
+	set("category", q.Category)
+	set("tag", q.Tag)
+	set("sort", q.Sort)
+	if key != "page" {
+		q.Page = 1
+	}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	v.Del(key)
+	set(key, value)
+	if len(v) == 0 {
+		return "/main"
+	}
+	return "/main?" + v.Encode()
 }
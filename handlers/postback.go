@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"go-redirect/models"
 	"go-redirect/utils"
 	"net/http"
@@ -11,10 +12,6 @@ import (
 )
 
 var PostbackLogs []map[string]string
-var PropellerConfig models.Propeller
-var GalaksionConfig models.Galaksion
-var PopcashConfig models.Popcash
-var ClickAdillaConfig models.ClickAdilla
 
 // --- Public Endpoints ---
 func GetPostbacks(c *fiber.Ctx) error {
@@ -38,35 +35,11 @@ func PostbackHandler(c *fiber.Ctx) error {
 	subID := data["sub_id"]
 	payout := data["payout"]
 	typeAds := data["type_ads"]
-
-	switch typeAds {
-	case models.AdTypePropeller:
-		go forwardPostbackWithBreaker("propeller", "PropellerAds", subID, payout, PropellerConfig.PostbackURL, map[string]string{
-			"aid":        PropellerConfig.Aid,
-			"tid":        PropellerConfig.Tid,
-			"visitor_id": subID,
-			"payout":     payout,
-		})
-	case models.AdTypeGalaksion:
-		go forwardPostbackWithBreaker("galaksion", "Galaksion", subID, "", GalaksionConfig.PostbackURL, map[string]string{
-			"cid":      GalaksionConfig.Cid,
-			"click_id": subID,
-		})
-	case models.AdTypePopcash:
-		go forwardPostbackWithBreaker("popcash", "Popcash", subID, payout, PopcashConfig.PostbackURL, map[string]string{
-			"aid":     PopcashConfig.Aid,
-			"type":    PopcashConfig.Type,
-			"clickid": subID,
-			"payout":  payout,
-		})
-	case models.AdTypeClickAdilla:
-		go forwardPostbackWithBreaker("clickadilla", "ClickAdilla", subID, payout, ClickAdillaConfig.PostbackURL, map[string]string{
-			"token":       ClickAdillaConfig.Token,
-			"campaign_id": data["campaign_id"],
-			"click_id":    subID,
-			"payout":      payout,
-		})
-	default:
+	cfg := CurrentSettings()
+	job := postbackJob{TypeAds: typeAds, SubID: subID, Payout: payout, CampaignID: data["campaign_id"]}
+	if _, ok := job.target(cfg); ok {
+		dispatchPostback(job)
+	} else {
 		utils.LogInfo(utils.LogEntry{
 			Type: "postback_unknown_type",
 			Extra: map[string]interface{}{
@@ -82,8 +55,65 @@ func PostbackHandler(c *fiber.Ctx) error {
 	})
 }
 
+// postbackTarget is a job resolved against the config: the network's postback
+// URL and params, credentials included.
+type postbackTarget struct {
+	Network string
+	Product string
+	BaseURL string
+	Params  map[string]string
+}
+
+// target builds the forward for the job's ad network from cfg. It is resolved
+// when the job is sent, so queued and persisted jobs never hold credentials.
+func (job postbackJob) target(cfg *Settings) (postbackTarget, bool) {
+	switch job.TypeAds {
+	case models.AdTypePropeller:
+		return postbackTarget{Network: "propeller", Product: "PropellerAds", BaseURL: cfg.Propeller.PostbackURL, Params: map[string]string{
+			"aid":        cfg.Propeller.Aid,
+			"tid":        cfg.Propeller.Tid,
+			"visitor_id": job.SubID,
+			"payout":     job.Payout,
+		}}, true
+	case models.AdTypeGalaksion:
+		return postbackTarget{Network: "galaksion", Product: "Galaksion", BaseURL: cfg.Galaksion.PostbackURL, Params: map[string]string{
+			"cid":      cfg.Galaksion.Cid,
+			"click_id": job.SubID,
+		}}, true
+	case models.AdTypePopcash:
+		return postbackTarget{Network: "popcash", Product: "Popcash", BaseURL: cfg.Popcash.PostbackURL, Params: map[string]string{
+			"aid":     cfg.Popcash.Aid,
+			"type":    cfg.Popcash.Type,
+			"clickid": job.SubID,
+			"payout":  job.Payout,
+		}}, true
+	case models.AdTypeClickAdilla:
+		return postbackTarget{Network: "clickadilla", Product: "ClickAdilla", BaseURL: cfg.ClickAdilla.PostbackURL, Params: map[string]string{
+			"token":       cfg.ClickAdilla.Token,
+			"campaign_id": job.CampaignID,
+			"click_id":    job.SubID,
+			"payout":      job.Payout,
+		}}, true
+	}
+	return postbackTarget{}, false
+}
+
+// postbackClient bounds each forward so shutdown is not held up by a slow network.
+var postbackClient = &http.Client{Timeout: 10 * time.Second}
+
 // --- Forward Helper with Circuit Breaker ---
-func forwardPostbackWithBreaker(networkKey, product, subID, payout, baseURL string, params map[string]string) {
+// forwardPostbackWithBreaker reports false only when ctx aborted the request, so the
+// caller keeps the job for a retry; every other outcome is final and logged.
+func forwardPostbackWithBreaker(ctx context.Context, job postbackJob) bool {
+	t, ok := job.target(CurrentSettings())
+	if !ok {
+		utils.LogInfo(utils.LogEntry{
+			Type:  "postback_unknown_type",
+			Extra: map[string]interface{}{"type_ads": job.TypeAds, "sub_id": job.SubID},
+		})
+		return true
+	}
+	networkKey, product, subID, payout, baseURL, params := t.Network, t.Product, job.SubID, job.Payout, t.BaseURL, t.Params
 	if subID == "" {
 		utils.LogInfo(utils.LogEntry{
 			Type: "postback_error",
@@ -93,7 +123,7 @@ func forwardPostbackWithBreaker(networkKey, product, subID, payout, baseURL stri
 				"payout":  payout,
 			},
 		})
-		return
+		return true
 	}
 
 	if baseURL == "" {
@@ -104,7 +134,7 @@ func forwardPostbackWithBreaker(networkKey, product, subID, payout, baseURL stri
 				"reason":  "missing_postback_url",
 			},
 		})
-		return
+		return true
 	}
 
 	q := url.Values{}
@@ -124,7 +154,24 @@ func forwardPostbackWithBreaker(networkKey, product, subID, payout, baseURL stri
 	}
 
 	// Simple HTTP request without circuit breaker
-	resp, err := http.Get(fullURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		utils.LogInfo(utils.LogEntry{
+			Type: "postback_forward_error",
+			Extra: map[string]interface{}{
+				"product": product,
+				"sub_id":  subID,
+				"fullURL": fullURL,
+				"error":   err.Error(),
+				"network": networkKey,
+			},
+		})
+		return true
+	}
+	resp, err := postbackClient.Do(req)
+	if err != nil && ctx.Err() != nil {
+		return false
+	}
 	if err != nil {
 		utils.LogInfo(utils.LogEntry{
 			Type: "postback_forward_error",
@@ -136,7 +183,7 @@ func forwardPostbackWithBreaker(networkKey, product, subID, payout, baseURL stri
 				"network": networkKey,
 			},
 		})
-		return
+		return true
 	}
 	defer resp.Body.Close()
 
@@ -152,7 +199,7 @@ func forwardPostbackWithBreaker(networkKey, product, subID, payout, baseURL stri
 				"network":     networkKey,
 			},
 		})
-		return
+		return true
 	}
 
 	utils.LogInfo(utils.LogEntry{
@@ -165,6 +212,7 @@ func forwardPostbackWithBreaker(networkKey, product, subID, payout, baseURL stri
 			"status_code": resp.StatusCode,
 		},
 	})
+	return true
 }
 
 // --- Helper Functions ---
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// postbackJob is one postback to forward to an ad network. It holds only what
+// the postback reported; the URL and credentials come from the config when it
+// is sent (see target).
+type postbackJob struct {
+	TypeAds    string `json:"type_ads"`
+	SubID      string `json:"sub_id"`
+	Payout     string `json:"payout,omitempty"`
+	CampaignID string `json:"campaign_id,omitempty"`
+}
+
+// postbackQueue tracks forwards still in flight so shutdown can wait for them and
+// persist whatever did not finish.
+var postbackQueue = struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	nextID  int
+	pending map[int]postbackJob
+	// ctx is cancelled when the drain deadline passes, aborting in-flight requests.
+	ctx    context.Context
+	cancel context.CancelFunc
+}{pending: map[int]postbackJob{}}
+
+func init() {
+	postbackQueue.ctx, postbackQueue.cancel = context.WithCancel(context.Background())
+}
+
+// dispatchPostback forwards job in the background.
+func dispatchPostback(job postbackJob) {
+	q := &postbackQueue
+	q.mu.Lock()
+	q.nextID++
+	id := q.nextID
+	q.pending[id] = job
+	q.wg.Add(1)
+	q.mu.Unlock()
+
+	go func() {
+		defer q.wg.Done()
+		if !forwardPostbackWithBreaker(q.ctx, job) {
+			// Aborted by shutdown: stays pending and is persisted.
+			return
+		}
+		q.mu.Lock()
+		delete(q.pending, id)
+		q.mu.Unlock()
+	}()
+}
+
+// DrainPostbacks waits for in-flight forwards until ctx is done, then aborts the rest
+// and appends them to path so ResumePostbacks can retry them after a restart. It
+// returns the number of persisted jobs.
+func DrainPostbacks(ctx context.Context, path string) (int, error) {
+	q := &postbackQueue
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return 0, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	n := 0
+	for id, job := range q.pending {
+		if err := enc.Encode(job); err != nil {
+			return n, err
+		}
+		delete(q.pending, id)
+		n++
+	}
+	return n, f.Sync()
+}
+
+// ResumePostbacks re-dispatches jobs persisted by DrainPostbacks and removes the
+// file. A missing file is not an error.
+func ResumePostbacks(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var jobs []postbackJob
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var job postbackJob
+		if err := json.Unmarshal(scanner.Bytes(), &job); err == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	// Remove before dispatching: a job that fails again is logged, and one caught by
+	// the next shutdown is written back.
+	if err := os.Remove(path); err != nil {
+		return 0, err
+	}
+	for _, job := range jobs {
+		dispatchPostback(job)
+	}
+	return len(jobs), nil
+}
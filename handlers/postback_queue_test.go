@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-redirect/models"
+)
+
+func TestDrainPostbacksPersistsUnfinishedAndResumes(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	release := make(chan struct{})
+	var hits atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	ApplySettings(&Settings{ClickAdilla: models.ClickAdilla{Token: "network-token", PostbackURL: slow.URL + "/cb?"}})
+	dispatchPostback(postbackJob{TypeAds: models.AdTypeClickAdilla, SubID: "s1"})
+	for hits.Load() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	path := filepath.Join(t.TempDir(), "pending.jsonl")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	n, err := DrainPostbacks(ctx, path)
+	if err != nil || n != 1 {
+		t.Fatalf("DrainPostbacks = %d, %v; want 1 persisted", n, err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"sub_id":"s1"`) || strings.Contains(string(data), "network-token") {
+		t.Fatalf("persisted queue = %s", data)
+	}
+	if st, err := os.Stat(path); err != nil || st.Mode().Perm() != 0600 {
+		t.Fatalf("persisted queue mode = %v, %v", st.Mode(), err)
+	}
+
+	// A new process resumes the job against a healthy endpoint.
+	postbackQueue.ctx, postbackQueue.cancel = context.WithCancel(context.Background())
+	var resumed atomic.Int32
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("click_id") == "s1" && r.URL.Query().Get("token") == "network-token" {
+			resumed.Add(1)
+		}
+	}))
+	defer ok.Close()
+	ApplySettings(&Settings{ClickAdilla: models.ClickAdilla{Token: "network-token", PostbackURL: ok.URL + "/cb?"}})
+	if n, err := ResumePostbacks(path); err != nil || n != 1 {
+		t.Fatalf("ResumePostbacks = %d, %v", n, err)
+	}
+	if n, err := DrainPostbacks(context.Background(), path); err != nil || n != 0 {
+		t.Fatalf("second drain = %d, %v; want 0", n, err)
+	}
+	if resumed.Load() != 1 {
+		t.Fatalf("resumed postback delivered %d times", resumed.Load())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("queue file not removed: %v", err)
+	}
+}
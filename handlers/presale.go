@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"go-redirect/middleware"
 	"go-redirect/models"
 	"go-redirect/utils"
 	"math/rand/v2"
-	"strings"
+	"net/url"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -12,17 +13,9 @@ import (
 )
 
 func PreSaleHandler(c *fiber.Ctx) error {
-	// --- Load CSV Products ---
-	products, err := utils.LoadProductsCSV("config/config.csv")
-	if err != nil {
-		utils.LogInfo(utils.LogEntry{
-			Type: "load_csv_error",
-			Extra: map[string]interface{}{
-				"file":  "config/config.csv",
-				"error": err.Error(),
-			},
-		})
-	}
+	// --- CSV Products from the catalog snapshot ---
+	snap := Catalog.Snapshot()
+	products := snap.CSVProducts
 	if len(products) == 0 {
 		utils.LogInfo(utils.LogEntry{
 			Type: "no_products_configured",
@@ -33,14 +26,16 @@ func PreSaleHandler(c *fiber.Ctx) error {
 		return c.Status(404).SendString("No products configured")
 	}
 
-	// --- Select Product by Percentage ---
+	// --- Select Product: explicit ?product= (e.g. article cards), else by Percentage ---
 	total := 0.0
 	for _, p := range products {
 		total += p.Percentage
 	}
 
 	var selected models.Product
-	if total <= 0 {
+	if p, ok := snap.Get(c.Query("product")); ok {
+		selected = p
+	} else if total <= 0 {
 		selected = products[0]
 	} else {
 		r := rand.Float64() * total
@@ -58,13 +53,7 @@ func PreSaleHandler(c *fiber.Ctx) error {
 	}
 
 	// --- Get IP & User Agent ---
-	ip := c.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = c.Get("X-Real-Ip")
-	}
-	if ip == "" {
-		ip = c.IP()
-	}
+	ip := middleware.ClientIP(c)
 
 	ua := user_agent.New(c.Get("User-Agent"))
 	browser, _ := ua.Browser()
@@ -85,29 +74,35 @@ func PreSaleHandler(c *fiber.Ctx) error {
 		headers[string(k)] = string(v)
 	})
 
+	// --- Signed hand-off to the redirect ---
+	impressionID := utils.RandomID(8)
+	direct := c.Query("redirect") == "direct"
+	// A direct redirect shows no layout, so its hand-off carries no variant.
+	var variant models.PreSaleVariant
+	if !direct {
+		variant = pickVariant(c, experimentFor(c), utils.VisitorFingerprint(ip, c.Get("User-Agent")))
+	}
+	handoff := issueHandoff(c, selected, ip, impressionID, variant.Name)
+
 	// --- Build Extra ---
 	extra := map[string]interface{}{
-		"query_raw": string(c.Request().URI().QueryString()),
+		"query_raw":     string(c.Request().URI().QueryString()),
+		"impression_id": impressionID,
 	}
 
 	// Check if direct redirect is requested (preserving headers)
-	if c.Query("redirect") == "direct" {
+	if direct {
 		// Build redirect URL with all current query params + product
+		// Only params the passthrough policy would forward survive the hop;
+		// renames and transforms are applied once, in doRedirect.
+		policy := passthroughFor(c)
 		redirectParams := make(map[string]string)
 		for k, v := range queryParams {
-			if k != "redirect" { // Remove redirect param
+			if utils.PassthroughAllows(policy, k) {
 				redirectParams[k] = v
 			}
 		}
-		redirectParams["product"] = selected.ID
-		redirectParams["from"] = "presale" // Mark that this came from presale
-
-		// Build query string
-		var params []string
-		for k, v := range redirectParams {
-			params = append(params, k+"="+v)
-		}
-		redirectURL := "/?" + strings.Join(params, "&")
+		redirectURL := handoffURL(c, selected, handoff, redirectParams)
 
 		// Log redirect
 		utils.LogInfo(utils.LogEntry{
@@ -124,14 +119,18 @@ func PreSaleHandler(c *fiber.Ctx) error {
 			QueryParams: queryParams,
 			Headers:     headers,
 			Extra: map[string]interface{}{
-				"redirect_url": redirectURL,
-				"query_raw":    string(c.Request().URI().QueryString()),
+				"redirect_url":  redirectURL,
+				"query_raw":     string(c.Request().URI().QueryString()),
+				"impression_id": impressionID,
 			},
 		})
 
 		return c.Redirect(redirectURL, fiber.StatusFound) // 302 redirect
 	}
 
+	extra["variant"] = variant.Name
+	extra["experiment"] = experimentKey(c)
+
 	// --- Log Impression ---
 	utils.LogInfo(utils.LogEntry{
 		Type:        models.TypeRoutePreSale,
@@ -150,12 +149,40 @@ func PreSaleHandler(c *fiber.Ctx) error {
 	})
 
 	// --- Render Page ---
-	return c.Render("pre-sale", fiber.Map{
-		"ID":           selected.ID,
-		"Name":         selected.Name,
-		"Description":  selected.Description,
-		"Image":        selected.Image,
-		"Komisi":       selected.Komisi,
-		"KomisiHingga": selected.KomisiHingga,
+	template := variant.Template
+	if template == "" {
+		template = controlVariant.Template
+	}
+	return c.Render(template, fiber.Map{
+		"ID":              selected.ID,
+		"Name":            selected.Name,
+		"Description":     selected.Description,
+		"Image":           selected.Image,
+		"Komisi":          selected.Komisi,
+		"KomisiHingga":    selected.KomisiHingga,
+		"RedirectURL":     handoffURL(c, selected, handoff, nil),
+		"Variant":         variant.Name,
+		"CountdownSec":    variant.CountdownSec,
+		"AutoRedirectSec": variant.AutoRedirectSec,
 	})
 }
+
+// handoffURL builds the URL-encoded "/?product=...&from=presale&handoff=..." link
+// carrying the signed token, the campaign/article it was issued for and any forwarded params.
+func handoffURL(c *fiber.Ctx, product models.Product, token string, params map[string]string) string {
+	q := url.Values{}
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	q.Set("product", product.ID)
+	for _, k := range []string{"campaign", "article"} {
+		if v := c.Query(k); v != "" {
+			q.Set(k, v)
+		}
+	}
+	q.Set("from", "presale")
+	if token != "" {
+		q.Set("handoff", token)
+	}
+	return "/?" + q.Encode()
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go-redirect/models"
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCheckHandoff(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	secret := []byte("test-secret")
+	ApplySettings(&Settings{HandoffSecret: secret})
+	product := models.Product{ID: "1"}
+	const ua = "Mozilla/5.0 (Linux; Android 13)"
+
+	var token string
+	impression := utils.RandomID(8)
+	issuer := fiber.New()
+	issuer.Get("/pre-sale", func(c *fiber.Ctx) error {
+		token = issueHandoff(c, product, "0.0.0.0", impression, "control")
+		return nil
+	})
+	req := httptest.NewRequest("GET", "/pre-sale?campaign=c1", nil)
+	req.Header.Set("User-Agent", ua)
+	if _, err := issuer.Test(req); err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+
+	expired := utils.SignHandoff(secret, utils.HandoffClaims{
+		ProductID: "1", Campaign: "c1", ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	})
+	body, sig, _ := strings.Cut(token, ".")
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac[len(mac)-1] ^= 0xff
+	forged := body + "." + base64.RawURLEncoding.EncodeToString(mac)
+
+	tests := []struct {
+		name   string
+		query  string
+		ua     string
+		status string
+	}{
+		{"verified", "product=1&campaign=c1&handoff=" + url.QueryEscape(token), ua, HandoffVerified},
+		{"missing", "product=1&from=presale", ua, HandoffMissing},
+		{"malformed", "product=1&handoff=garbage", ua, HandoffMalformed},
+		{"replayed", "product=1&campaign=c1&handoff=" + url.QueryEscape(token), ua, HandoffReplayed},
+		{"forged signature", "product=1&campaign=c1&handoff=" + url.QueryEscape(forged), ua, HandoffForged},
+		{"product swapped", "product=2&campaign=c1&handoff=" + url.QueryEscape(token), ua, HandoffForged},
+		{"campaign swapped", "product=1&campaign=c2&handoff=" + url.QueryEscape(token), ua, HandoffForged},
+		{"other visitor", "product=1&campaign=c1&handoff=" + url.QueryEscape(token), "curl/8", HandoffVisitorMismatch},
+		{"expired", "product=1&campaign=c1&handoff=" + url.QueryEscape(expired), ua, HandoffExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got handoffResult
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				got = checkHandoff(c, "0.0.0.0")
+				return nil
+			})
+			req := httptest.NewRequest("GET", "/?"+tt.query, nil)
+			req.Header.Set("User-Agent", tt.ua)
+			if _, err := app.Test(req); err != nil {
+				t.Fatalf("[%s] app.Test failed: %v", tt.name, err)
+			}
+			if got.Status != tt.status {
+				t.Errorf("[%s] expected status %s, got %s", tt.name, tt.status, got.Status)
+			}
+			if tt.status == HandoffVerified && got.Claims.ImpressionID != impression {
+				t.Errorf("[%s] expected impression %s, got %q", tt.name, impression, got.Claims.ImpressionID)
+			}
+		})
+	}
+}
+
+func TestHandoffWithoutSecretIsUnsigned(t *testing.T) {
+	ApplySettings(&Settings{})
+	var token string
+	var got handoffResult
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		token = issueHandoff(c, models.Product{ID: "1"}, "0.0.0.0", "imp", "")
+		got = checkHandoff(c, "0.0.0.0")
+		return nil
+	})
+	if _, err := app.Test(httptest.NewRequest("GET", "/?product=1&from=presale", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if token != "" || got.Status != HandoffUnsigned {
+		t.Errorf("expected no token and an unsigned hand-off, got %q and %s", token, got.Status)
+	}
+}
+
+func TestHandoffURLEncodesParams(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	var u string
+	app := fiber.New()
+	app.Get("/pre-sale", func(c *fiber.Ctx) error {
+		u = handoffURL(c, models.Product{ID: "1"}, "tok", map[string]string{"utm": "a&b=c"})
+		return nil
+	})
+	if _, err := app.Test(httptest.NewRequest("GET", "/pre-sale?campaign=c+1&article=tips", nil)); err != nil {
+		t.Fatalf("app.Test failed: %v", err)
+	}
+	for _, exp := range []string{"utm=a%26b%3Dc", "campaign=c+1", "article=tips", "handoff=tok", "from=presale"} {
+		if !strings.Contains(u, exp) {
+			t.Errorf("expected %s in %s", exp, u)
+		}
+	}
+}
@@ -1,67 +1,109 @@
 package handlers
 
 import (
+	"go-redirect/catalog"
 	"go-redirect/geo"
+	"go-redirect/middleware"
 	"go-redirect/models"
 	"go-redirect/utils"
 	"math/rand/v2"
+	"slices"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/mssola/user_agent"
 )
 
-var Products []models.Product
+// Catalog is the shared in-memory product catalog; every handler reads its current snapshot.
+var Catalog *catalog.Service
 
 func RedirectHandler(c *fiber.Ctx) error {
-	if productID := c.Query("product"); productID != "" {
-		for _, p := range Products {
-			if p.ID == productID {
-				return doRedirect(c, p)
-			}
-		}
-		// fallback CSV
-		if csvProducts, err := utils.LoadProductsCSV("config/config.csv"); err == nil {
-			for _, p := range csvProducts {
-				if p.ID == productID {
-					return doRedirect(c, p)
-				}
-			}
+	if c.Query("from") == "presale" || c.Query("handoff") != "" {
+		res := checkHandoff(c, middleware.ClientIP(c))
+		c.Locals("presale_handoff", res)
+		if res.Status != HandoffVerified && res.Status != HandoffReplayed && CurrentSettings().PreSale.RejectInvalid {
+			utils.LogInfo(utils.LogEntry{
+				Type:      "presale_handoff_rejected",
+				Timestamp: time.Now(),
+				URL:       c.OriginalURL(),
+				IP:        middleware.ClientIP(c),
+				UserAgent: c.Get("User-Agent"),
+				Referer:   c.Get("Referer"),
+				Extra: map[string]interface{}{
+					"presale_handoff": res.Status,
+					"product":         c.Query("product"),
+				},
+			})
+			return c.Status(fiber.StatusForbidden).SendString("Invalid or expired link")
 		}
 	}
 
+	snap := Catalog.Snapshot()
+	if p, ok := snap.Get(c.Query("product")); ok {
+		return doRedirect(c, p)
+	}
+
+	products := rotationFor(middleware.ClientIP(c), snap.YAMLProducts)
 	total := 0.0
-	for _, p := range Products {
+	for _, p := range products {
 		total += p.Percentage
 	}
-	if len(Products) == 0 {
+	if len(products) == 0 {
 		return c.Status(404).SendString("No products configured")
 	}
 	if total <= 0 {
-		return doRedirect(c, Products[0])
+		return doRedirect(c, products[0])
 	}
 
 	r := rand.Float64() * total
 	sum := 0.0
-	for _, p := range Products {
+	for _, p := range products {
 		sum += p.Percentage
 		if r <= sum {
 			return doRedirect(c, p)
 		}
 	}
 
-	return doRedirect(c, Products[len(Products)-1])
+	return doRedirect(c, products[len(products)-1])
 }
 
-func doRedirect(c *fiber.Ctx, product models.Product) error {
-	// --- IP & Geo ---
-	ip := c.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = c.Get("X-Real-Ip")
+// rotationFor narrows the rotation to the products targeting the visitor's network
+// (by ASN or asn_categories). Visitors no targeted product matches get the
+// untargeted products, or every product when all of them are targeted.
+func rotationFor(ip string, products []models.Product) []models.Product {
+	var targeted, general []models.Product
+	for _, p := range products {
+		if len(p.ASNs) == 0 && len(p.ASNCategories) == 0 {
+			general = append(general, p)
+		} else {
+			targeted = append(targeted, p)
+		}
+	}
+	if len(targeted) == 0 {
+		return products
 	}
-	if ip == "" {
-		ip = c.IP()
+
+	var matched []models.Product
+	if asn, _, _ := geo.LookupASN(ip); asn != 0 {
+		cats := geo.ASNCategories(CurrentSettings().ASNCategories, asn)
+		for _, p := range targeted {
+			if slices.Contains(p.ASNs, asn) || slices.ContainsFunc(p.ASNCategories, func(cat string) bool { return slices.Contains(cats, cat) }) {
+				matched = append(matched, p)
+			}
+		}
 	}
+	switch {
+	case len(matched) > 0:
+		return matched
+	case len(general) > 0:
+		return general
+	}
+	return products
+}
+
+func doRedirect(c *fiber.Ctx, product models.Product) error {
+	// --- IP & Geo ---
+	ip := middleware.ClientIP(c)
 	geoInfo := geo.GetGeoInfo(ip)
 
 	// --- User Agent ---
@@ -110,7 +152,7 @@ func doRedirect(c *fiber.Ctx, product models.Product) error {
 		queryParams["sub_id"] = subIDOut
 	}
 
-	finalURL := utils.BuildAffiliateURL(product.URL, queryParams)
+	finalURL := utils.BuildAffiliateURL(product.URL, queryParams, passthroughFor(c))
 
 	// --- Logging ---
 	extra := map[string]interface{}{
@@ -118,6 +160,12 @@ func doRedirect(c *fiber.Ctx, product models.Product) error {
 		"sub_id":   subIDOut,
 		"type_ads": queryParams["type_ads"],
 	}
+	if article := c.Query("article"); article != "" {
+		extra["article"] = article
+	}
+	for k, v := range handoffExtra(c) {
+		extra[k] = v
+	}
 
 	utils.LogInfo(utils.LogEntry{
 		Type:        models.TypeRouteRedirect,
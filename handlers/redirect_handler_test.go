@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 
+	"go-redirect/catalog"
+	"go-redirect/geo"
 	"go-redirect/models"
 
 	"github.com/gofiber/fiber/v2"
@@ -12,7 +14,7 @@ import (
 
 func setupFiber() *fiber.App {
 	app := fiber.New()
-	Products = []models.Product{
+	products := []models.Product{
 		{
 			ID:   "1",
 			Name: "Eiger",
@@ -24,11 +26,35 @@ func setupFiber() *fiber.App {
 			URL:  "https://blibli.com?sub_id_1={siteid}&sub_id_2={sub_id}&sub_id_3={type_ads}&sub_aff_id={sub_id}",
 		},
 	}
+	snap, err := catalog.NewSnapshot(products, nil)
+	if err != nil {
+		panic(err)
+	}
+	Catalog = catalog.NewStatic(snap)
+	ApplySettings(&Settings{
+		Passthrough: models.PassthroughPolicy{
+			Rename: map[string]string{"zoneid": "sub_id4"},
+		},
+		Campaigns: []models.Campaign{
+			{
+				ID: "strict",
+				Passthrough: &models.PassthroughPolicy{
+					Mode:   "allow",
+					Params: []string{"utm_source"},
+					Transform: []models.ParamTransform{
+						{Param: "utm_source", Op: "upper"},
+						{Param: "utm_content", Op: "Default", Value: "house"},
+					},
+				},
+			},
+		},
+	})
 	app.Get("/", RedirectHandler)
 	return app
 }
 
 func TestRedirectHandler(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
 	app := setupFiber()
 
 	tests := []struct {
@@ -102,6 +128,55 @@ func TestRedirectHandler(t *testing.T) {
 				"sub_id3=",
 			},
 		},
+		{
+			name: "Internal params are not forwarded",
+			url:  "/?product=2&sub_id=INT1&bypass=x&from=presale&redirect=direct&auto=3&expires=99",
+			expectSubs: []string{
+				"sub_id_2=INT1",
+			},
+			notExpect: []string{
+				"bypass=",
+				"from=",
+				"redirect=",
+				"auto=",
+				"expires=",
+				"product=",
+			},
+		},
+		{
+			name: "Default policy renames zoneid",
+			url:  "/?product=2&sub_id=Z1&zoneid=777",
+			expectSubs: []string{
+				"sub_id4=777",
+			},
+			notExpect: []string{
+				"zoneid=",
+			},
+		},
+		{
+			name: "Rename matches param names case-insensitively",
+			url:  "/?product=2&sub_id=Z1&ZoneID=778",
+			expectSubs: []string{
+				"sub_id4=778",
+			},
+			notExpect: []string{
+				"ZoneID=",
+				"zoneid=",
+			},
+		},
+		{
+			name: "Campaign allowlist with transform",
+			url:  "/?product=2&campaign=strict&sub_id=C1&utm_source=tiktok&utm_medium=cpc",
+			expectSubs: []string{
+				"sub_id_2=C1",
+				"utm_source=TIKTOK",
+				"utm_content=house",
+			},
+			notExpect: []string{
+				"utm_medium=",
+				"campaign=",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -133,3 +208,40 @@ func TestRedirectHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestRotationForTargetsASNs(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	geo.Use(geo.NewService(geo.Fake{
+		"36.68.1.1": {CountryCode: "ID", ASN: 23693},
+		"36.80.1.1": {CountryCode: "ID", ASN: 7713},
+		"8.8.8.8":   {CountryCode: "US", ASN: 15169},
+	}, 0))
+	defer geo.Close()
+	ApplySettings(&Settings{ASNCategories: map[string][]uint{"mobile_id": {23693, 4761}}})
+
+	products := []models.Product{
+		{Name: "general", Percentage: 50},
+		{Name: "carrier", Percentage: 10, ASNCategories: []string{"mobile_id"}},
+		{Name: "google", Percentage: 10, ASNs: []uint{15169}},
+	}
+	names := func(ps []models.Product) string {
+		var out []string
+		for _, p := range ps {
+			out = append(out, p.Name)
+		}
+		return strings.Join(out, ",")
+	}
+	for ip, want := range map[string]string{
+		"36.68.1.1": "carrier",
+		"8.8.8.8":   "google",
+		"36.80.1.1": "general",
+		"bogus":     "general",
+	} {
+		if got := names(rotationFor(ip, products)); got != want {
+			t.Errorf("%s: rotation %q, want %q", ip, got, want)
+		}
+	}
+	if got := names(rotationFor("36.80.1.1", products[1:])); got != "carrier,google" {
+		t.Errorf("all targeted, none matching: rotation %q", got)
+	}
+}
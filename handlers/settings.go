@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"go-redirect/models"
+	"sync/atomic"
+)
+
+const defaultArticlesDir = "content/articles"
+
+// Settings is the config-derived state read by handlers. A config reload builds a
+// new Settings and swaps it in whole, so a request never sees half of a reload.
+type Settings struct {
+	Propeller     models.Propeller
+	Galaksion     models.Galaksion
+	Popcash       models.Popcash
+	ClickAdilla   models.ClickAdilla
+	Campaigns     []models.Campaign
+	Passthrough   models.PassthroughPolicy
+	PreSale       models.PreSale
+	HandoffSecret []byte
+	ArticlesDir   string
+	ASNCategories map[string][]uint
+	Bypass        models.Bypass
+}
+
+var settings atomic.Pointer[Settings]
+
+// NewSettings derives handler settings from cfg.
+func NewSettings(cfg *models.Config) *Settings {
+	s := &Settings{
+		Propeller:     cfg.Propeller,
+		Galaksion:     cfg.Galaksion,
+		Popcash:       cfg.Popcash,
+		ClickAdilla:   cfg.ClickAdilla,
+		Campaigns:     cfg.Campaigns,
+		Passthrough:   cfg.Passthrough,
+		PreSale:       cfg.PreSale,
+		HandoffSecret: []byte(cfg.PreSale.HandoffSecret),
+		ArticlesDir:   cfg.ArticlesDir,
+		ASNCategories: cfg.ASNCategories,
+		Bypass:        cfg.BotFilter.Bypass,
+	}
+	if s.ArticlesDir == "" {
+		s.ArticlesDir = defaultArticlesDir
+	}
+	return s
+}
+
+// ApplySettings atomically replaces the handler settings.
+func ApplySettings(s *Settings) {
+	settings.Store(s)
+}
+
+// CurrentSettings returns the active settings (zero values before the first Apply).
+func CurrentSettings() *Settings {
+	if s := settings.Load(); s != nil {
+		return s
+	}
+	return &Settings{ArticlesDir: defaultArticlesDir}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-redirect/audit"
+	"go-redirect/catalog"
+	"go-redirect/utils"
+)
+
+// runImportProducts implements `go-redirect import-products <file.csv>`: the file
+// must parse and form a valid catalog together with the YAML products before it
+// replaces the product CSV. A running server picks the new file up on its next poll.
+func runImportProducts(args []string) int {
+	fs := newFlagSet("import-products", "<file.csv>")
+	configPath := configFlag(fs)
+	out := fs.String("out", defaultCSVPath, "product CSV to replace")
+	dryRun := fs.Bool("dry-run", false, "validate only")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	in := fs.Arg(0)
+
+	cfg, _, ok := loadConfig(*configPath)
+	if !ok {
+		return 1
+	}
+	products, err := utils.LoadProductsCSV(in)
+	if err != nil {
+		return fail("%s: %v", in, err)
+	}
+	if _, err := catalog.NewSnapshot(cfg.Products, products); err != nil {
+		return fail("%s: %v", in, err)
+	}
+	categories := map[string]bool{}
+	for _, p := range products {
+		if p.Category != "" {
+			categories[p.Category] = true
+		}
+	}
+	fmt.Printf("%s: %d products in %d categories\n", in, len(products), len(categories))
+	if *dryRun {
+		return 0
+	}
+
+	previous, _ := utils.LoadProductsCSV(*out)
+	entry := audit.Entry{
+		Actor:  cliActor(),
+		Source: "cli",
+		Action: "products.import",
+		Target: *out,
+		Before: map[string]int{"products": len(previous)},
+		After:  map[string]int{"products": len(products)},
+	}
+	err = replaceFile(in, *out)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(entry)
+	if err != nil {
+		return fail("%v", err)
+	}
+	fmt.Printf("installed as %s\n", *out)
+	return 0
+}
+
+// cliActor names the operator running a command, for the audit trail.
+func cliActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// replaceFile copies src over dst through a temp file and rename, so readers never
+// see a half-written file.
+func replaceFile(src, dst string) error {
+	srcAbs, _ := filepath.Abs(src)
+	dstAbs, _ := filepath.Abs(dst)
+	if srcAbs == dstAbs {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".import-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// conversionTimeLayouts are accepted in the timestamp column of a conversions CSV.
+var conversionTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// runImportConversions implements `go-redirect import-conversions <file.csv>`. Each
+// row (header row required, sub_id column mandatory; payout, type_ads, campaign_id,
+// status and timestamp optional) is logged as a postback_received entry, so offline
+// conversions from network reports count in the dashboard and experiment results.
+// Sub IDs already logged are skipped; nothing is forwarded to the ad networks.
+func runImportConversions(args []string) int {
+	fs := newFlagSet("import-conversions", "<file.csv>")
+	dryRun := fs.Bool("dry-run", false, "parse and report without writing logs")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	in := fs.Arg(0)
+
+	f, err := os.Open(in)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return fail("%s: %v", in, err)
+	}
+	if len(rows) < 2 {
+		return fail("%s: no conversion rows", in)
+	}
+	header := make([]string, len(rows[0]))
+	subIDCol := -1
+	for i, h := range rows[0] {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if header[i] == "sub_id" {
+			subIDCol = i
+		}
+	}
+	if subIDCol < 0 {
+		return fail("%s: missing sub_id column", in)
+	}
+
+	seen := map[string]bool{}
+	if err := utils.ForEachLogEntry(func(e utils.LogEntry) {
+		if e.Type == "postback_received" {
+			if id, _ := e.Extra["sub_id"].(string); id != "" {
+				seen[id] = true
+			}
+		}
+	}); err != nil {
+		return fail("reading logs: %v", err)
+	}
+
+	var imported, skipped int
+	var problems []string
+	for n, row := range rows[1:] {
+		line := n + 2
+		extra := map[string]interface{}{"source": "import", "import_file": filepath.Base(in)}
+		for i, v := range row {
+			if i < len(header) && header[i] != "" {
+				extra[header[i]] = strings.TrimSpace(v)
+			}
+		}
+		var ts time.Time
+		if v, _ := extra["timestamp"].(string); v != "" {
+			parsed, err := parseConversionTime(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+				continue
+			}
+			ts = parsed
+		}
+		subID, _ := extra["sub_id"].(string)
+		if subID == "" {
+			problems = append(problems, fmt.Sprintf("line %d: empty sub_id", line))
+			continue
+		}
+		if seen[subID] {
+			skipped++
+			continue
+		}
+		seen[subID] = true
+		imported++
+		if *dryRun {
+			continue
+		}
+		if err := utils.LogInfo(utils.LogEntry{Type: "postback_received", Timestamp: ts, Extra: extra}); err != nil {
+			return fail("writing log: %v", err)
+		}
+	}
+
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", in, p)
+	}
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d conversions, skipped %d already logged, %d invalid rows\n", verb, imported, skipped, len(problems))
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func parseConversionTime(v string) (time.Time, error) {
+	for _, layout := range conversionTimeLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", v)
+}
@@ -0,0 +1,209 @@
+// Package ipset matches IPv4 and IPv6 addresses against CIDR lists with a binary
+// radix trie, so lookups cost at most one step per prefix bit however long the
+// list is.
+package ipset
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Set is a set of prefixes. The zero value is empty and ready to use.
+type Set struct {
+	v4, v6 *node
+	n      int
+}
+
+type node struct {
+	child  [2]*node
+	prefix netip.Prefix
+	end    bool
+}
+
+// Add inserts p. IPv4-mapped IPv6 prefixes are stored as IPv4.
+func (s *Set) Add(p netip.Prefix) {
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	p = p.Masked()
+	root := &s.v6
+	if p.Addr().Is4() {
+		root = &s.v4
+	}
+	if *root == nil {
+		*root = &node{}
+	}
+	n := *root
+	for i := 0; i < p.Bits(); i++ {
+		b := bit(p.Addr(), i)
+		if n.child[b] == nil {
+			n.child[b] = &node{}
+		}
+		n = n.child[b]
+	}
+	if !n.end {
+		n.end, n.prefix = true, p
+		s.n++
+	}
+}
+
+// Lookup returns the widest prefix containing a.
+func (s *Set) Lookup(a netip.Addr) (netip.Prefix, bool) {
+	if s == nil || !a.IsValid() {
+		return netip.Prefix{}, false
+	}
+	a = a.Unmap()
+	n, bits := s.v6, 128
+	if a.Is4() {
+		n, bits = s.v4, 32
+	}
+	for i := 0; n != nil; i++ {
+		if n.end {
+			return n.prefix, true
+		}
+		if i == bits {
+			break
+		}
+		n = n.child[bit(a, i)]
+	}
+	return netip.Prefix{}, false
+}
+
+// Contains reports whether ip (in text form) is in the set; invalid IPs are not.
+func (s *Set) Contains(ip string) bool {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	_, ok := s.Lookup(a)
+	return ok
+}
+
+// Len is the number of distinct prefixes added.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.n
+}
+
+func bit(a netip.Addr, i int) int {
+	if a.Is4() {
+		b := a.As4()
+		return int(b[i/8]>>(7-i%8)) & 1
+	}
+	b := a.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// ParsePrefix accepts a CIDR ("104.28.0.0/20", "2001:db8::/32"), a single address,
+// or a legacy octet prefix ending in a dot ("66.249." means 66.249.0.0/16).
+func ParsePrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		return netip.ParsePrefix(entry)
+	}
+	if strings.HasSuffix(entry, ".") && !strings.Contains(entry, ":") {
+		octets := strings.Split(strings.TrimSuffix(entry, "."), ".")
+		if len(octets) < 1 || len(octets) > 3 {
+			return netip.Prefix{}, fmt.Errorf("legacy prefix %q needs 1 to 3 octets", entry)
+		}
+		full := append(append([]string(nil), octets...), "0", "0", "0")[:4]
+		a, err := netip.ParseAddr(strings.Join(full, "."))
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("legacy prefix %q: %w", entry, err)
+		}
+		return netip.PrefixFrom(a, 8*len(octets)), nil
+	}
+	a, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// AddEntries parses and adds config entries. Errors name the entry's index.
+func (s *Set) AddEntries(entries []string) error {
+	for i, e := range entries {
+		p, err := ParsePrefix(e)
+		if err != nil {
+			return fmt.Errorf("[%d]: %w", i, err)
+		}
+		s.Add(p)
+	}
+	return nil
+}
+
+// AddFile adds the prefixes in path. A .json file is taken to be a cloud provider's
+// range dump (AWS ip-ranges.json, Google cloud.json and similar): every string under
+// a key containing "prefix" is added. Any other file has one entry per line, with
+// blank lines and # comments ignored.
+func (s *Set) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		var doc interface{}
+		if err := json.NewDecoder(f).Decode(&doc); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		before := s.n
+		if err := s.addJSON(doc, false); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if s.n == before {
+			return fmt.Errorf("%s: no prefixes found", path)
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		p, err := ParsePrefix(text)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		s.Add(p)
+	}
+	return scanner.Err()
+}
+
+func (s *Set) addJSON(v interface{}, underPrefixKey bool) error {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if err := s.addJSON(child, strings.Contains(strings.ToLower(k), "prefix")); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range t {
+			if err := s.addJSON(child, underPrefixKey); err != nil {
+				return err
+			}
+		}
+	case string:
+		if underPrefixKey {
+			p, err := netip.ParsePrefix(t)
+			if err != nil {
+				return err
+			}
+			s.Add(p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package ipset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetLookup(t *testing.T) {
+	var s Set
+	if err := s.AddEntries([]string{"34.", "104.28.0.0/20", "10.1.2.3", "2001:db8::/32", "::ffff:192.0.2.0/120"}); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"34.0.0.1":        true,
+		"134.0.0.1":       false,
+		"104.28.15.255":   true,
+		"104.28.16.0":     false,
+		"10.1.2.3":        true,
+		"10.1.2.4":        false,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"::ffff:34.1.1.1": true,
+		"192.0.2.9":       true,
+		"not-an-ip":       false,
+	} {
+		if got := s.Contains(ip); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", ip, got, want)
+		}
+	}
+	if s.Len() != 5 {
+		t.Errorf("Len = %d, want 5", s.Len())
+	}
+	if err := s.AddEntries([]string{"10.0.0.0/8", "1.2.3.4.", "nope"}); err == nil || err.Error()[:3] != "[1]" {
+		t.Errorf("AddEntries error = %v, want one for entry [1]", err)
+	}
+}
+
+func TestSetAddFile(t *testing.T) {
+	dir := t.TempDir()
+	txt := filepath.Join(dir, "office.txt")
+	os.WriteFile(txt, []byte("# office\n203.0.113.0/24  # HQ\n\n2001:db8:1::/48\n"), 0o644)
+	aws := filepath.Join(dir, "ip-ranges.json")
+	os.WriteFile(aws, []byte(`{"syncToken":"1","prefixes":[{"ip_prefix":"3.5.140.0/22","region":"ap-northeast-2"}],
+		"ipv6_prefixes":[{"ipv6_prefix":"2600:1f00::/24","region":"us-east-1"}]}`), 0o644)
+
+	var s Set
+	for _, f := range []string{txt, aws} {
+		if err := s.AddFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ip := range []string{"203.0.113.9", "2001:db8:1::1", "3.5.141.1", "2600:1f00::1"} {
+		if !s.Contains(ip) {
+			t.Errorf("%s not in set", ip)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.txt")
+	os.WriteFile(bad, []byte("1.2.3.0/24\nfoo\n"), 0o644)
+	if err := s.AddFile(bad); err == nil || err.Error() != bad+`:2: ParseAddr("foo"): unable to parse IP` {
+		t.Errorf("AddFile(bad) = %v", err)
+	}
+}
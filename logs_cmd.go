@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go-redirect/utils"
+)
+
+// runLogs implements `go-redirect logs query|export`.
+func runLogs(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-redirect logs query|export [flags]")
+		return 2
+	}
+	switch args[0] {
+	case "query":
+		return runLogsQuery(args[1:])
+	case "export":
+		return runLogsExport(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown logs command %q (want query or export)\n", args[0])
+	return 2
+}
+
+// logFilter selects log entries; it is shared by logs query and logs export.
+type logFilter struct {
+	dir     string
+	types   string
+	since   string
+	until   string
+	product string
+	ip      string
+	limit   int
+
+	typeSet              map[string]bool
+	sinceTime, untilTime time.Time
+}
+
+func (f *logFilter) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dir, "dir", utils.LogFolder(), "log directory (default $LOG_PATH or ./logs)")
+	fs.StringVar(&f.types, "type", "", "comma-separated entry types, e.g. redirect,block_request")
+	fs.StringVar(&f.since, "since", "", "only entries at or after this date (2006-01-02 or RFC3339)")
+	fs.StringVar(&f.until, "until", "", "only entries before this date (2006-01-02 or RFC3339)")
+	fs.StringVar(&f.product, "product", "", "product name substring (case-insensitive)")
+	fs.StringVar(&f.ip, "ip", "", "exact visitor IP")
+	fs.IntVar(&f.limit, "limit", 0, "stop after this many entries (0 = no limit)")
+}
+
+func (f *logFilter) prepare() error {
+	if f.types != "" {
+		f.typeSet = map[string]bool{}
+		for _, t := range strings.Split(f.types, ",") {
+			f.typeSet[strings.TrimSpace(t)] = true
+		}
+	}
+	var err error
+	if f.sinceTime, err = parseLogTime(f.since); err != nil {
+		return fmt.Errorf("-since: %w", err)
+	}
+	if f.untilTime, err = parseLogTime(f.until); err != nil {
+		return fmt.Errorf("-until: %w", err)
+	}
+	return nil
+}
+
+func (f *logFilter) match(e utils.LogEntry) bool {
+	if f.typeSet != nil && !f.typeSet[e.Type] {
+		return false
+	}
+	if !f.sinceTime.IsZero() && e.Timestamp.Before(f.sinceTime) {
+		return false
+	}
+	if !f.untilTime.IsZero() && !e.Timestamp.Before(f.untilTime) {
+		return false
+	}
+	if f.product != "" && !strings.Contains(strings.ToLower(e.ProductName), strings.ToLower(f.product)) {
+		return false
+	}
+	if f.ip != "" && e.IP != f.ip {
+		return false
+	}
+	return true
+}
+
+// each streams matching entries until fn returns false or the limit is reached.
+func (f *logFilter) each(fn func(utils.LogEntry) bool) error {
+	n := 0
+	done := false
+	return utils.ForEachLogEntryIn(f.dir, func(e utils.LogEntry) {
+		if done || !f.match(e) {
+			return
+		}
+		n++
+		if !fn(e) || (f.limit > 0 && n >= f.limit) {
+			done = true
+		}
+	})
+}
+
+func parseLogTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
+
+// runLogsQuery prints matching entries as JSONL, or per-type counts with -count.
+func runLogsQuery(args []string) int {
+	fs := newFlagSet("logs query", "")
+	var filter logFilter
+	filter.register(fs)
+	count := fs.Bool("count", false, "print the number of matching entries per type instead")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := filter.prepare(); err != nil {
+		return fail("%v", err)
+	}
+
+	counts := map[string]int{}
+	enc := json.NewEncoder(os.Stdout)
+	var writeErr error
+	err := filter.each(func(e utils.LogEntry) bool {
+		if *count {
+			counts[e.Type]++
+			return true
+		}
+		writeErr = enc.Encode(e)
+		return writeErr == nil
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+	if *count {
+		types := make([]string, 0, len(counts))
+		for t := range counts {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			fmt.Printf("%-28s %d\n", t, counts[t])
+		}
+	}
+	return 0
+}
+
+// logCSVColumns are the columns of `logs export -format csv`.
+var logCSVColumns = []string{"timestamp", "type", "product_name", "ip", "device", "browser", "os", "country", "referer", "url", "sub_id", "type_ads", "reason"}
+
+// runLogsExport writes matching entries to a file as JSONL or flat CSV.
+func runLogsExport(args []string) int {
+	fs := newFlagSet("logs export", "")
+	var filter logFilter
+	filter.register(fs)
+	format := fs.String("format", "jsonl", "jsonl or csv")
+	out := fs.String("out", "", "output file (default stdout)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *format != "jsonl" && *format != "csv" {
+		return fail("-format must be jsonl or csv")
+	}
+	if err := filter.prepare(); err != nil {
+		return fail("%v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail("%v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n := 0
+	var writeErr error
+	var err error
+	if *format == "csv" {
+		cw := csv.NewWriter(w)
+		writeErr = cw.Write(logCSVColumns)
+		err = filter.each(func(e utils.LogEntry) bool {
+			if writeErr == nil {
+				writeErr = cw.Write(logCSVRow(e))
+				n++
+			}
+			return writeErr == nil
+		})
+		cw.Flush()
+		if writeErr == nil {
+			writeErr = cw.Error()
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		err = filter.each(func(e utils.LogEntry) bool {
+			writeErr = enc.Encode(e)
+			n++
+			return writeErr == nil
+		})
+	}
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "exported %d entries to %s\n", n, *out)
+	}
+	return 0
+}
+
+func logCSVRow(e utils.LogEntry) []string {
+	str := func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+	subID := str(e.Extra["sub_id"])
+	if subID == "" {
+		subID = e.QueryParams["sub_id"]
+	}
+	typeAds := str(e.Extra["type_ads"])
+	if typeAds == "" {
+		typeAds = e.QueryParams["type_ads"]
+	}
+	var country string
+	if g, ok := e.Extra["geo"].(map[string]interface{}); ok {
+		country = str(g["country"])
+	}
+	return []string{
+		e.Timestamp.Format(time.RFC3339), e.Type, e.ProductName, e.IP, e.Device, e.Browser, e.OS,
+		country, e.Referer, e.URL, subID, typeAds, str(e.Extra["reason"]),
+	}
+}
@@ -1,19 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"go-redirect/geo"
-	"go-redirect/middleware"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"go-redirect/handlers"
+	"go-redirect/middleware"
 	"go-redirect/utils"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/template/html/v2"
 )
 
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// runServe starts the HTTP server. It is the default command.
+func runServe(args []string) int {
 	//// Load .env file
 	//if err := godotenv.Load(); err != nil {
 	//	log.Println("No .env file found, using system environment")
@@ -28,89 +38,163 @@ func main() {
 	//	panic(err)
 	//}
 
-	// ========== 1. Load Campaign Config ==========
-	appCfg, err := utils.LoadConfig("config/config.yaml")
-	if err != nil {
-		utils.LogFatal(utils.LogEntry{
-			Type:  "fatal_error",
-			Extra: map[string]interface{}{"error": err.Error()},
-		}, 1)
+	fs := newFlagSet("serve", "")
+	configPath := configFlag(fs)
+	csvPath := fs.String("products", defaultCSVPath, "product CSV, hot reloaded")
+	port := fs.String("port", os.Getenv("PORT"), "listen port (default $PORT or 8080)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 5*time.Second, "how long to drain requests and postbacks on SIGINT/SIGTERM")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *port == "" {
+		*port = "8080"
 	}
 
-	handlers.Products = appCfg.Products
-	handlers.PropellerConfig = appCfg.Propeller
-	handlers.GalaksionConfig = appCfg.Galaksion
-	handlers.PopcashConfig = appCfg.Popcash
+	// ========== 1. Load Config, Product Catalog and Bot Filter ==========
+	rt, err := newRuntime(runtimeOptions{
+		ConfigPath: *configPath,
+		CSVPath:    *csvPath,
+		Geo: geo.Paths{
+			City:    "GeoLite2-City.mmdb",
+			Country: "GeoLite2-Country.mmdb",
+			ASN:     "GeoLite2-ASN.mmdb",
+		},
+		GeoRequired: true,
+	})
+	if err != nil {
+		extra := map[string]interface{}{"error": err.Error()}
+		var problems utils.ConfigErrors
+		if errors.As(err, &problems) {
+			list := make([]string, len(problems))
+			for i, p := range problems {
+				list[i] = p.Error()
+			}
+			extra["problems"] = list
+		}
+		utils.LogInfo(utils.LogEntry{Type: "startup_failed", Extra: extra})
+		printConfigError(*configPath, err)
+		return 1
+	}
+	rt.restoreState()
+	go rt.catalog.Watch(5 * time.Second)
+	// Config reloads on SIGHUP, file change or POST /admin/reload-config.
+	go rt.reloader.Watch(5 * time.Second)
+
+	// ========== 2. Geo Databases ==========
+	// Updated mmdb files are swapped in without a restart.
+	go rt.geo.Watch(time.Minute)
+
+	// ========== 3. Routes ==========
+	app := rt.newApp()
+	apps := []*fiber.App{app}
+
+	// ========== 4. Start Server with Graceful Shutdown ==========
+	fmt.Printf("🚀 Server starting on port %s\n", *port)
+
+	listenErr := make(chan error, 2)
+	go func() { listenErr <- app.Listen(":" + *port) }()
+
+	// The admin listener is bound once; changing admin.listen needs a restart.
+	if admin := rt.cfg.Admin; admin.Listen != "" {
+		adminApp := rt.newAdminApp()
+		apps = append(apps, adminApp)
+		if len(admin.Users) == 0 && len(admin.APIKeys) == 0 {
+			utils.LogInfo(utils.LogEntry{
+				Type:  "admin_warning",
+				Extra: map[string]interface{}{"message": "no admin users or api_keys configured, every admin request is refused"},
+			})
+		}
+		fmt.Printf("🔐 Admin listening on %s\n", admin.Listen)
+		go func() { listenErr <- adminApp.Listen(admin.Listen) }()
+	}
 
-	// ========== 2. Init Geo Database ==========
-	if err := geo.InitGeoDB("GeoLite2-City.mmdb"); err != nil {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-listenErr:
+		if err != nil {
+			utils.LogInfo(utils.LogEntry{
+				Type:  "server_error",
+				Extra: map[string]interface{}{"error": err.Error()},
+			})
+			rt.close()
+			return 1
+		}
+		return 0
+	case sig := <-stop:
 		utils.LogInfo(utils.LogEntry{
-			Type:  "geo_db_warning",
-			Extra: map[string]interface{}{"error": err.Error(), "message": "Geo data will show as Unknown"},
+			Type:  "shutdown_started",
+			Extra: map[string]interface{}{"signal": sig.String(), "timeout": shutdownTimeout.String()},
 		})
 	}
 
-	// ========== 3. Setup Bot Filter Middleware ==========
-	botCfg := middleware.BotFilterConfig{
-		AllowCountries:     appCfg.BotFilter.AllowCountries,
-		BlacklistUA:        appCfg.BotFilter.BlacklistUA,
-		BlacklistIPPrefix:  appCfg.BotFilter.BlacklistIPPrefix,
-		BlacklistReferrer:  appCfg.BotFilter.BlacklistReferrer,
-		BlacklistRefRegex:  appCfg.BotFilter.BlacklistRefRegex,
-		RateLimitMax:       appCfg.BotFilter.RateLimitMax,
-		RateLimitWindowSec: appCfg.BotFilter.RateLimitWindowSec,
-		LogAllowed:         appCfg.BotFilter.LogAllowed,
-		LogBlocked:         appCfg.BotFilter.LogBlocked,
-		AllowMobileOnly:    appCfg.BotFilter.AllowMobileOnly,
+	// Stop accepting connections and wait for in-flight requests, then give pending
+	// postbacks whatever is left of the deadline before persisting them.
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	extra := map[string]interface{}{}
+	for _, a := range apps {
+		if err := a.ShutdownWithContext(ctx); err != nil {
+			extra["http_error"] = err.Error()
+		}
 	}
-	bf, err := middleware.NewBotFilter(botCfg, "GeoLite2-Country.mmdb")
+	persisted, err := handlers.DrainPostbacks(ctx, statePath(postbackStateFile))
+	extra["postbacks_persisted"] = persisted
 	if err != nil {
-		utils.LogFatal(utils.LogEntry{
-			Type:  "geoip_error",
-			Extra: map[string]interface{}{"error": err.Error()},
-		}, 1)
+		extra["postback_error"] = err.Error()
 	}
-
-	// ========== 4. Init Fiber Engine ==========
-	engine := html.New("./views", ".html")
-	app := fiber.New(fiber.Config{Views: engine})
-
-	// ========== 5. Request ID middleware ==========
-	app.Use(middleware.RequestID())
-
-	// ========== 6. Health & Admin endpoints (no logging, no bot filter) ==========
-	app.Get("/health", handlers.HealthHandler)
-	app.Get("/ready", handlers.ReadinessHandler)
-	app.Get("/logs", handlers.LogsHandler)
-	app.Get("/dashboard", handlers.DashboardHandler)
-	app.Get("/sse", handlers.SSEHandler)
-	app.Get("/postbacks", handlers.GetPostbacks)
-	app.Get("/article", handlers.ArticleHandler)
-	app.Get("/main", handlers.MainHandler)
-
-	// ========== 6.5. Postback endpoint (logging only, no bot filter) ==========
-	app.Get("/postback", middleware.RequestLogger(), handlers.PostbackHandler)
-
-	// ========== 7. Bot filter toggle endpoint ==========
-	app.Post("/toggle-bot-filter", handlers.ToggleBotFilterHandler)
-	app.Get("/bot-filter-status", handlers.BotFilterStatusHandler)
-
-	// ========== 8. Protected routes with bot filter + logging ==========
-	// Main redirect endpoint
-	app.Get("/", middleware.RequestLogger(), middleware.ConditionalBotFilter(bf), handlers.RedirectHandler)
-	// Pre-sale endpoint
-	app.Get("/pre-sale", middleware.RequestLogger(), middleware.ConditionalBotFilter(bf), handlers.PreSaleHandler)
-
-	// ========== 10. Start Server dengan Graceful Shutdown ==========
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if err := rt.bots.SaveState(statePath(rateLimitStateFile)); err != nil {
+		extra["rate_limit_error"] = err.Error()
 	}
+	utils.LogInfo(utils.LogEntry{Type: "shutdown_complete", Extra: extra})
+	rt.close()
+	return 0
+}
 
-	// Simple server startup
+// State files live next to the logs, on the persistent volume in production.
+const (
+	postbackStateFile  = "state-pending-postbacks.jsonl"
+	rateLimitStateFile = "state-rate-limit.json"
+	botTogglesFile     = "state-bot-toggles.json"
+	bansFile           = "state-bans.json"
+)
 
-	fmt.Printf("🚀 Server starting on port %s\n", port)
+func statePath(name string) string {
+	return filepath.Join(utils.LogFolder(), name)
+}
 
-	if err := app.Listen(":" + port); err != nil {
+// restoreState resumes postbacks and rate-limit history saved by the last shutdown,
+// and the toggles and bans saved as they changed.
+func (rt *runtime) restoreState() {
+	extra := map[string]interface{}{}
+	resumed, err := handlers.ResumePostbacks(statePath(postbackStateFile))
+	extra["postbacks_resumed"] = resumed
+	if err != nil {
+		extra["postback_error"] = err.Error()
+	}
+	if err := rt.bots.LoadState(statePath(rateLimitStateFile)); err != nil {
+		extra["rate_limit_error"] = err.Error()
+	}
+	// Toggles are saved on every change, not just at shutdown.
+	if err := middleware.LoadBotFilterToggles(statePath(botTogglesFile)); err != nil {
+		extra["bot_toggles_error"] = err.Error()
+	}
+	if active, scheduled := middleware.BotFilterToggles(); len(active)+len(scheduled) > 0 {
+		extra["bot_toggles"] = len(active) + len(scheduled)
 	}
+	if err := middleware.LoadBans(statePath(bansFile)); err != nil {
+		extra["bans_error"] = err.Error()
+	}
+	if n := len(middleware.Bans()); n > 0 {
+		extra["bans"] = n
+	}
+	if resumed > 0 || len(extra) > 1 {
+		utils.LogInfo(utils.LogEntry{Type: "state_restored", Extra: extra})
+	}
+}
+
+// close flushes logs and releases the GeoIP readers.
+func (rt *runtime) close() {
+	utils.FlushLogs()
+	geo.Close()
 }
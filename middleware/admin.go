@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"go-redirect/models"
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// roleRank orders admin roles; a role is allowed everything a lower one is.
+var roleRank = map[string]int{models.RoleViewer: 1, models.RoleOperator: 2}
+
+// adminLoginTTL is how long a verified password is remembered, so the dashboard's
+// polling does not pay for a bcrypt comparison on every request.
+const adminLoginTTL = 5 * time.Minute
+
+// AdminAuth authenticates admin requests with HTTP Basic auth (users) or an API key.
+// get is called per request so a config reload adds and revokes credentials.
+type AdminAuth struct {
+	get func() models.Admin
+
+	mu       sync.Mutex
+	verified map[[32]byte]time.Time
+}
+
+func NewAdminAuth(get func() models.Admin) *AdminAuth {
+	return &AdminAuth{get: get, verified: map[[32]byte]time.Time{}}
+}
+
+// Require only lets through requests whose credentials carry at least role. It
+// answers 401 with a Basic challenge, so browsers prompt for a login, and 403 when
+// the role is too low. The caller's name and role are stored in c.Locals.
+func (a *AdminAuth) Require(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name, granted, presented := a.authenticate(c)
+		if granted == "" {
+			if presented {
+				utils.LogInfo(utils.LogEntry{
+					Type:  "admin_auth_failed",
+					Extra: map[string]interface{}{"ip": ClientIP(c), "user": name, "path": c.Path()},
+				})
+			}
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="go-redirect admin", charset="UTF-8"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "authentication required"})
+		}
+		if roleRank[granted] < roleRank[role] {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": role + " role required"})
+		}
+		c.Locals("admin_user", name)
+		c.Locals("admin_role", granted)
+		return c.Next()
+	}
+}
+
+// AdminPrincipal returns the name and role Require stored for the request.
+func AdminPrincipal(c *fiber.Ctx) (name, role string) {
+	name, _ = c.Locals("admin_user").(string)
+	role, _ = c.Locals("admin_role").(string)
+	return name, role
+}
+
+// authenticate returns the caller's name and role; role is empty when the
+// credentials are missing or wrong. presented reports whether any were sent.
+func (a *AdminAuth) authenticate(c *fiber.Ctx) (name, role string, presented bool) {
+	cfg := a.get()
+
+	key := c.Get("X-API-Key")
+	if auth := c.Get(fiber.HeaderAuthorization); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key != "" {
+		sum := sha256.Sum256([]byte(key))
+		given := []byte(hex.EncodeToString(sum[:]))
+		for _, k := range cfg.APIKeys {
+			if subtle.ConstantTimeCompare(given, []byte(k.KeySHA256)) == 1 {
+				return k.Name, k.Role, true
+			}
+		}
+		return "", "", true
+	}
+
+	user, pass, ok := basicAuth(c)
+	if !ok {
+		return "", "", false
+	}
+	for _, u := range cfg.Users {
+		if u.Name == user && a.checkPassword(u, pass) {
+			return u.Name, u.Role, true
+		}
+	}
+	return user, "", true
+}
+
+// checkPassword verifies pass against u's bcrypt hash, remembering successes for
+// adminLoginTTL. The cache key covers the hash, so rotating it forces a re-check.
+func (a *AdminAuth) checkPassword(u models.AdminUser, pass string) bool {
+	key := sha256.Sum256([]byte(u.Name + "\x00" + u.PasswordHash + "\x00" + pass))
+	now := time.Now()
+
+	a.mu.Lock()
+	expires, ok := a.verified[key]
+	a.mu.Unlock()
+	if ok && now.Before(expires) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(pass)) != nil {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, exp := range a.verified {
+		if now.After(exp) {
+			delete(a.verified, k)
+		}
+	}
+	a.verified[key] = now.Add(adminLoginTTL)
+	return true
+}
+
+// basicAuth decodes an `Authorization: Basic` header.
+func basicAuth(c *fiber.Ctx) (user, pass string, ok bool) {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(auth, "Basic ") {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return "", "", false
+	}
+	user, pass, ok = strings.Cut(string(raw), ":")
+	return user, pass, ok
+}
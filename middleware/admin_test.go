@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"go-redirect/models"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAdminAuthRoles(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("k3y"))
+	cfg := models.Admin{
+		Users:   []models.AdminUser{{Name: "ana", PasswordHash: string(hash), Role: models.RoleViewer}},
+		APIKeys: []models.AdminAPIKey{{Name: "ci", KeySHA256: hex.EncodeToString(sum[:]), Role: models.RoleOperator}},
+	}
+	auth := NewAdminAuth(func() models.Admin { return cfg })
+	ok := func(c *fiber.Ctx) error { return c.SendString(c.Locals("admin_user").(string)) }
+	app := fiber.New()
+	app.Get("/view", auth.Require(models.RoleViewer), ok)
+	app.Post("/operate", auth.Require(models.RoleOperator), ok)
+
+	for _, tc := range []struct {
+		name, method, path, header, value string
+		want                              int
+	}{
+		{"no credentials", "GET", "/view", "", "", 401},
+		{"viewer reads", "GET", "/view", "Authorization", "Basic YW5hOnB3", 200},
+		{"viewer cached", "GET", "/view", "Authorization", "Basic YW5hOnB3", 200},
+		{"viewer cannot operate", "POST", "/operate", "Authorization", "Basic YW5hOnB3", 403},
+		{"wrong password", "GET", "/view", "Authorization", "Basic YW5hOm5v", 401},
+		{"operator key", "POST", "/operate", "Authorization", "Bearer k3y", 200},
+		{"operator key header", "GET", "/view", "X-API-Key", "k3y", 200},
+		{"unknown key", "GET", "/view", "X-API-Key", "nope", 401},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+		if resp.StatusCode == 401 && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without a Basic challenge", tc.name)
+		}
+	}
+
+	// Revoking the user in the config takes effect despite the cached login.
+	cfg.Users = nil
+	req := httptest.NewRequest("GET", "/view", nil)
+	req.Header.Set("Authorization", "Basic YW5hOnB3")
+	if resp, _ := app.Test(req); resp.StatusCode != 401 {
+		t.Errorf("revoked user: status %d, want 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,332 @@
+package middleware
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-redirect/ratelimit"
+	"go-redirect/utils"
+)
+
+// BanConfig is bot_filter.bans: Threshold blocks by one of Rules from the same
+// Key ("ip" or "subnet") within Window ban it for the next of Durations.
+type BanConfig struct {
+	Enabled    bool
+	Rules      []string
+	Key        string
+	Threshold  int
+	Window     time.Duration
+	Durations  []time.Duration
+	ResetAfter time.Duration
+}
+
+// withDefaults fills in what the config leaves out.
+func (c BanConfig) withDefaults() BanConfig {
+	if len(c.Rules) == 0 {
+		c.Rules = []string{"rate_limit", "user_agent"}
+	}
+	if c.Key == "" {
+		c.Key = "ip"
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 5
+	}
+	if c.Window <= 0 {
+		c.Window = 10 * time.Minute
+	}
+	if len(c.Durations) == 0 {
+		c.Durations = []time.Duration{10 * time.Minute, time.Hour, 24 * time.Hour}
+	}
+	if c.ResetAfter <= 0 {
+		c.ResetAfter = 7 * 24 * time.Hour
+	}
+	return c
+}
+
+// Ban blocks every request from Key ("ip:<addr>" or "subnet:<prefix>") until
+// Until, before any rule runs. Offense is the key's ban count, which picks the
+// duration.
+type Ban struct {
+	ID      int       `json:"id"`
+	Key     string    `json:"key"`
+	Reason  string    `json:"reason"`
+	Offense int       `json:"offense"`
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+	// ExtendedBy is the operator who last extended the ban.
+	ExtendedBy string `json:"extended_by,omitempty"`
+}
+
+type banOffense struct {
+	Count int       `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// Ban state, persisted to bansPath on every change once LoadBans has been called.
+// Violations are only counted in memory. Lookups on every filtered request share
+// banMu; writes to the file happen after it is released, ordered by saveMu.
+var (
+	banMu        sync.RWMutex
+	bans         = map[string]*Ban{}
+	offenses     = map[string]banOffense{}
+	nextBanID    int
+	bansPath     string
+	banGen       int
+	banPrunedAt  atomic.Int64
+	violations   = ratelimit.New(0)
+	saveMu       sync.Mutex
+	savedBansGen int
+)
+
+// banPruneEvery is how often lapsed bans and stale offense counts are dropped.
+const banPruneEvery = time.Minute
+
+type banState struct {
+	NextID   int                   `json:"next_id"`
+	Bans     []*Ban                `json:"bans"`
+	Offenses map[string]banOffense `json:"offenses"`
+}
+
+// banSnapshot is a copy of the ban state taken under banMu, written after it is released.
+type banSnapshot struct {
+	gen   int
+	path  string
+	state banState
+}
+
+// banKeys are the keys a ban on ip may be stored under.
+func banKeys(ip string) []string {
+	return []string{"ip:" + ip, "subnet:" + subnetOf(ip)}
+}
+
+func banKey(key, ip string) string {
+	if key == "subnet" {
+		return "subnet:" + subnetOf(ip)
+	}
+	return "ip:" + ip
+}
+
+// activeBan returns the ban on ip or its subnet in effect at now.
+func activeBan(ip string, now time.Time) (Ban, bool) {
+	banMu.RLock()
+	defer banMu.RUnlock()
+	for _, key := range banKeys(ip) {
+		if b, ok := bans[key]; ok && now.Before(b.Until) {
+			return *b, true
+		}
+	}
+	return Ban{}, false
+}
+
+// pruneBans drops lapsed bans and offense counts older than the policy's
+// ResetAfter, at most once per banPruneEvery, and persists the result.
+func pruneBans(cfg BanConfig, now time.Time) error {
+	last := banPrunedAt.Load()
+	if now.UnixNano()-last < int64(banPruneEvery) || !banPrunedAt.CompareAndSwap(last, now.UnixNano()) {
+		return nil
+	}
+	banMu.Lock()
+	if !pruneBansLocked(cfg, now) {
+		banMu.Unlock()
+		return nil
+	}
+	snap := snapshotBansLocked()
+	banMu.Unlock()
+	return snap.save()
+}
+
+// pruneBansLocked reports whether anything was dropped. Callers hold banMu.
+func pruneBansLocked(cfg BanConfig, now time.Time) bool {
+	changed := false
+	for k, b := range bans {
+		if !now.Before(b.Until) {
+			delete(bans, k)
+			changed = true
+		}
+	}
+	for k, o := range offenses {
+		if now.Sub(o.Last) > cfg.ResetAfter {
+			delete(offenses, k)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// recordViolation counts a block of ip for reason and bans its key once the
+// policy's threshold is reached. It returns the new ban, if any.
+func recordViolation(cfg BanConfig, ip, reason string, now time.Time) (Ban, bool) {
+	key := banKey(cfg.Key, ip)
+	if violations.Hit(key, cfg.Window, now) < cfg.Threshold {
+		return Ban{}, false
+	}
+
+	banMu.Lock()
+	if b, ok := bans[key]; ok && now.Before(b.Until) {
+		banMu.Unlock()
+		return Ban{}, false
+	}
+	pruneBansLocked(cfg, now)
+	o := offenses[key]
+	o.Count++
+	o.Last = now
+	offenses[key] = o
+	step := o.Count - 1
+	if step >= len(cfg.Durations) {
+		step = len(cfg.Durations) - 1
+	}
+	nextBanID++
+	b := Ban{ID: nextBanID, Key: key, Reason: reason, Offense: o.Count, Since: now, Until: now.Add(cfg.Durations[step])}
+	bans[key] = &b
+	snap := snapshotBansLocked()
+	banMu.Unlock()
+
+	extra := map[string]interface{}{"ban_id": b.ID, "key": key, "reason": reason, "offense": b.Offense, "until": b.Until}
+	if err := snap.save(); err != nil {
+		extra["persist_error"] = err.Error()
+	}
+	utils.LogInfo(utils.LogEntry{Type: "ban_issued", IP: ip, Extra: extra})
+	return b, true
+}
+
+// Bans returns the bans in effect, soonest to expire first.
+func Bans() []Ban {
+	banMu.RLock()
+	defer banMu.RUnlock()
+	now := time.Now()
+	out := []Ban{}
+	for _, b := range bans {
+		if now.Before(b.Until) {
+			out = append(out, *b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Until.Before(out[j].Until) })
+	return out
+}
+
+// ExtendBan adds d to a ban, counting from now if it already lapsed.
+func ExtendBan(id int, d time.Duration, by string) (before, after Ban, ok bool, err error) {
+	banMu.Lock()
+	b := banByID(id)
+	if b == nil {
+		banMu.Unlock()
+		return Ban{}, Ban{}, false, nil
+	}
+	before = *b
+	from := b.Until
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+	b.Until, b.ExtendedBy = from.Add(d), by
+	after = *b
+	snap := snapshotBansLocked()
+	banMu.Unlock()
+	return before, after, true, snap.save()
+}
+
+// LiftBan ends a ban now. The key's offense count is kept, so a new ban still
+// escalates.
+func LiftBan(id int) (Ban, bool, error) {
+	banMu.Lock()
+	b := banByID(id)
+	if b == nil {
+		banMu.Unlock()
+		return Ban{}, false, nil
+	}
+	delete(bans, b.Key)
+	lifted := *b
+	snap := snapshotBansLocked()
+	banMu.Unlock()
+	return lifted, true, snap.save()
+}
+
+// banByID finds an active ban. Callers hold banMu.
+func banByID(id int) *Ban {
+	now := time.Now()
+	for _, b := range bans {
+		if b.ID == id && now.Before(b.Until) {
+			return b
+		}
+	}
+	return nil
+}
+
+// LoadBans replaces the ban state with the one saved at path and persists every
+// later change there. A missing file starts with no bans.
+func LoadBans(path string) error {
+	banMu.Lock()
+	defer banMu.Unlock()
+	bansPath = path
+	bans, offenses, nextBanID = map[string]*Ban{}, map[string]banOffense{}, 0
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved banState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	now := time.Now()
+	nextBanID = saved.NextID
+	for _, b := range saved.Bans {
+		if now.Before(b.Until) {
+			bans[b.Key] = b
+		}
+	}
+	for k, o := range saved.Offenses {
+		offenses[k] = o
+	}
+	return nil
+}
+
+// snapshotBansLocked copies the bans still in effect and the offense counts.
+// Callers hold banMu.
+func snapshotBansLocked() banSnapshot {
+	banGen++
+	now := time.Now()
+	st := banState{NextID: nextBanID, Bans: []*Ban{}, Offenses: make(map[string]banOffense, len(offenses))}
+	for _, b := range bans {
+		if now.Before(b.Until) {
+			c := *b
+			st.Bans = append(st.Bans, &c)
+		}
+	}
+	for k, o := range offenses {
+		st.Offenses[k] = o
+	}
+	return banSnapshot{gen: banGen, path: bansPath, state: st}
+}
+
+// save writes the snapshot through a temp file and rename, unless a newer one
+// has been written already.
+func (s banSnapshot) save() error {
+	if s.path == "" {
+		return nil
+	}
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	if s.gen <= savedBansGen {
+		return nil
+	}
+	sort.Slice(s.state.Bans, func(i, j int) bool { return s.state.Bans[i].ID < s.state.Bans[j].ID })
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	savedBansGen = s.gen
+	return nil
+}
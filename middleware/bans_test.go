@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBansEscalateAndPersist(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	trustTestPeer(t)
+	path := filepath.Join(t.TempDir(), "bans.json")
+	if err := LoadBans(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bans, offenses, bansPath = map[string]*Ban{}, map[string]banOffense{}, "" })
+
+	bf, err := NewBotFilter(BotFilterConfig{
+		BlacklistUA: []string{"curl"},
+		Bans: BanConfig{
+			Enabled:   true,
+			Threshold: 2,
+			Durations: []time.Duration{time.Minute, time.Hour},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	get := func(ip, ua string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		req.Header.Set("User-Agent", ua)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	const browser = "Mozilla/5.0 (Linux; Android 13)"
+
+	get("198.51.100.7", "curl/8.0")
+	if got := get("198.51.100.7", browser); got != 200 {
+		t.Fatalf("one violation: status %d, want 200", got)
+	}
+	get("198.51.100.7", "curl/8.0")
+	if got := get("198.51.100.7", browser); got != 403 {
+		t.Fatalf("banned IP with a clean request: status %d, want 403", got)
+	}
+	if got := get("198.51.100.8", browser); got != 200 {
+		t.Errorf("neighbour of a banned IP: status %d, want 200", got)
+	}
+
+	list := Bans()
+	if len(list) != 1 || list[0].Key != "ip:198.51.100.7" || list[0].Reason != "suspicious_ua" || list[0].Offense != 1 {
+		t.Fatalf("bans: %+v", list)
+	}
+	if d := time.Until(list[0].Until); d > time.Minute || d < 50*time.Second {
+		t.Errorf("first ban lasts %v, want 1m", d)
+	}
+
+	// Bans and offense counts survive a restart.
+	if err := LoadBans(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("198.51.100.7", browser); got != 403 {
+		t.Errorf("ban after reload: status %d, want 403", got)
+	}
+	if _, ok, err := LiftBan(list[0].ID); !ok || err != nil {
+		t.Fatalf("lift: ok=%v err=%v", ok, err)
+	}
+	if got := get("198.51.100.7", browser); got != 200 {
+		t.Errorf("lifted ban: status %d, want 200", got)
+	}
+
+	// Offending again escalates to the next duration.
+	get("198.51.100.7", "curl/8.0")
+	list = Bans()
+	if len(list) != 1 || list[0].Offense != 2 || time.Until(list[0].Until) < 50*time.Minute {
+		t.Fatalf("second ban: %+v", list)
+	}
+	_, after, ok, err := ExtendBan(list[0].ID, time.Hour, "ops")
+	if !ok || err != nil || after.Until.Sub(list[0].Until) != time.Hour || after.ExtendedBy != "ops" {
+		t.Errorf("extend: %+v ok=%v err=%v", after, ok, err)
+	}
+	if st := bf.Stats(); st.Banned != 2 || st.ActiveBans != 1 {
+		t.Errorf("stats: banned=%d active=%d", st.Banned, st.ActiveBans)
+	}
+}
+
+func TestSubnetBanSparesAllowlistedIPs(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	trustTestPeer(t)
+	t.Cleanup(func() { bans, offenses = map[string]*Ban{}, map[string]banOffense{} })
+
+	bf, err := NewBotFilter(BotFilterConfig{
+		BlacklistUA: []string{"curl"},
+		IPAllowlist: []string{"203.0.113.9"},
+		Bans:        BanConfig{Enabled: true, Key: "subnet", Threshold: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	get := func(ip, ua string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		req.Header.Set("User-Agent", ua)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	const browser = "Mozilla/5.0 (Linux; Android 13)"
+
+	get("203.0.113.7", "curl/8.0")
+	if got := get("203.0.113.8", browser); got != 403 {
+		t.Fatalf("banned subnet: status %d, want 403", got)
+	}
+	if got := get("203.0.113.9", browser); got != 200 {
+		t.Errorf("allowlisted IP in a banned subnet: status %d, want 200", got)
+	}
+}
+
+func TestPruneBansDropsLapsedState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	if err := LoadBans(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bans, offenses, bansPath = map[string]*Ban{}, map[string]banOffense{}, "" })
+
+	now := time.Now()
+	cfg := BanConfig{}.withDefaults()
+	bans["ip:192.0.2.1"] = &Ban{ID: 1, Key: "ip:192.0.2.1", Until: now.Add(-time.Second)}
+	bans["ip:192.0.2.2"] = &Ban{ID: 2, Key: "ip:192.0.2.2", Until: now.Add(time.Hour)}
+	offenses["ip:192.0.2.1"] = banOffense{Count: 1, Last: now.Add(-cfg.ResetAfter - time.Hour)}
+	offenses["ip:192.0.2.2"] = banOffense{Count: 1, Last: now}
+
+	banPrunedAt.Store(0)
+	if err := pruneBans(cfg, now); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved banState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Bans) != 1 || saved.Bans[0].ID != 2 || len(saved.Offenses) != 1 {
+		t.Errorf("saved state kept lapsed entries: %s", data)
+	}
+	if len(bans) != 1 || len(offenses) != 1 {
+		t.Errorf("memory kept lapsed entries: %v %v", bans, offenses)
+	}
+}
+
+func TestBannedRequestsDoNotFillRateLimits(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	trustTestPeer(t)
+	t.Cleanup(func() { bans, offenses = map[string]*Ban{}, map[string]banOffense{} })
+
+	bf, err := NewBotFilter(BotFilterConfig{
+		BlacklistUA:  []string{"curl"},
+		RateLimitMax: 100,
+		RateLimits:   []RateLimitConfig{{Key: "subnet", Max: 3, Window: time.Hour}},
+		IPAllowlist:  []string{"192.0.2.9"},
+		Bans:         BanConfig{Enabled: true, Rules: []string{"user_agent"}, Threshold: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	get := func(ip, ua string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		req.Header.Set("User-Agent", ua)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	const browser = "Mozilla/5.0 (Linux; Android 13)"
+
+	// The ban-earning request is the only one from the /24 counted so far.
+	get("192.0.2.7", "curl/8.0")
+	for i := 0; i < 10; i++ {
+		if got := get("192.0.2.7", browser); got != 403 {
+			t.Fatalf("banned IP: status %d, want 403", got)
+		}
+		get("192.0.2.9", browser)
+	}
+	if got := get("192.0.2.8", browser); got != 200 {
+		t.Errorf("neighbour after banned and allowlisted requests: status %d, want 200", got)
+	}
+	if got := get("192.0.2.10", browser); got != 200 {
+		t.Errorf("third counted request: status %d, want 200", got)
+	}
+	if got := get("192.0.2.11", browser); got != 403 {
+		t.Errorf("fourth counted request: status %d, want 403", got)
+	}
+}
@@ -1,17 +1,21 @@
 package middleware
 
 import (
+	"fmt"
+	"go-redirect/catalog"
+	"go-redirect/geo"
+	"go-redirect/ipset"
+	"go-redirect/ratelimit"
 	"go-redirect/utils"
 	"net"
+	"net/netip"
 	"net/url"
-	"regexp"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/mssola/user_agent"
-	"github.com/oschwald/geoip2-golang"
 )
 
 // ===================== CONFIG =====================
@@ -20,15 +24,47 @@ type BotFilterConfig struct {
 	AllowCountries     []string
 	BlacklistUA        []string
 	BlacklistIPPrefix  []string
+	IPBlocklistFiles   []string
+	IPAllowlist        []string
+	IPAllowlistFiles   []string
 	BlacklistReferrer  []string
 	BlacklistRefRegex  []string
 	RateLimitMax       int
 	RateLimitWindowSec int
-	LogAllowed         bool
-	LogBlocked         bool
-	AllowMobileOnly    bool
+	// RateLimits are limits on other keys, an "ip" entry replacing RateLimitMax;
+	// CampaignRateLimits override them by key for one campaign's traffic.
+	RateLimits         []RateLimitConfig
+	CampaignRateLimits map[string][]RateLimitConfig
+	// RateLimitMaxKeys caps the counters kept; 0 means ratelimit.DefaultMaxKeys.
+	RateLimitMaxKeys int
+	LogAllowed       bool
+	LogBlocked       bool
+	AllowMobileOnly  bool
+	// BlockThreshold is the total score that blocks a request; 0 means 1.
+	BlockThreshold float64
+	// Rules orders and weights the pipeline; see compilePipeline.
+	Rules []RuleConfig
+	// Shadow logs would-be blocks as would_block instead of blocking.
+	Shadow bool
+	// ASNCategories is the top-level asn_categories; the asn rule blocks
+	// BlockASNs and members of BlockASNCategories unless listed in AllowASNs.
+	ASNCategories      map[string][]uint
+	BlockASNs          []uint
+	BlockASNCategories []string
+	AllowASNs          []uint
+	// Bans temporarily blocks IPs or subnets that keep getting blocked.
+	Bans BanConfig
+	// Bypass lists the credentials that skip the filter.
+	Bypass BypassConfig
+	// Fingerprint configures the fingerprint rule, which scores nothing unless enabled.
+	Fingerprint FingerprintConfig
+	// Challenge serves the JavaScript interstitial below BlockThreshold.
+	Challenge ChallengeConfig
 }
 
+// Catalog resolves product names for block logs; set by main.
+var Catalog *catalog.Service
+
 type ClickLog struct {
 	IP        string
 	UserAgent string
@@ -37,276 +73,327 @@ type ClickLog struct {
 	Timestamp time.Time
 }
 
+// botRules is the compiled, immutable form of a BotFilterConfig. Reconfigure swaps
+// it atomically; rate-limit state lives on botFilter and survives the swap.
+type botRules struct {
+	cfg       BotFilterConfig
+	pipeline  []scoredRule
+	threshold float64
+	allow     *ipset.Set
+	// limits apply to requests without a campaign in campaignLimits.
+	limits         []RateLimitConfig
+	campaignLimits map[string][]RateLimitConfig
+	bans           BanConfig
+	bypass         BypassConfig
+	challenge      ChallengeConfig
+}
+
 type botFilter struct {
-	cfg          BotFilterConfig
-	geoDB        *geoip2.Reader
-	ipReqMu      sync.Mutex
-	ipReqMap     map[string][]time.Time
-	refRegexList []*regexp.Regexp
+	rules   atomic.Pointer[botRules]
+	limiter atomic.Pointer[ratelimit.Limiter]
+	stats   filterStats
 }
 
 // ===================== INIT =====================
 
-func NewBotFilter(cfg BotFilterConfig, geoDBPath string) (*botFilter, error) {
-	var db *geoip2.Reader
-	var err error
-	if geoDBPath != "" {
-		db, err = geoip2.Open(geoDBPath)
-		if err != nil {
-			utils.LogFatal(utils.LogEntry{
-				Type:  "geoip_db_error",
-				Extra: map[string]interface{}{"error": err.Error(), "geoDBPath": geoDBPath},
-			}, 1)
-
-			return nil, err
-		}
+func NewBotFilter(cfg BotFilterConfig) (*botFilter, error) {
+	bf := &botFilter{}
+	if err := bf.Reconfigure(cfg); err != nil {
+		return nil, err
 	}
-	bf := &botFilter{
-		cfg:      cfg,
-		geoDB:    db,
-		ipReqMap: make(map[string][]time.Time),
+	return bf, nil
+}
+
+// compileBotRules validates cfg and builds its rule pipeline.
+func compileBotRules(cfg BotFilterConfig) (*botRules, error) {
+	pipeline, err := compilePipeline(cfg)
+	if err != nil {
+		return nil, err
 	}
-	for _, pat := range cfg.BlacklistRefRegex {
-		if re, err := regexp.Compile(pat); err == nil {
-			bf.refRegexList = append(bf.refRegexList, re)
+	allow, err := loadIPSet("ip_allowlist", cfg.IPAllowlist, "ip_allowlist_files", cfg.IPAllowlistFiles)
+	if err != nil {
+		return nil, err
+	}
+	r := &botRules{
+		cfg:            cfg,
+		pipeline:       pipeline,
+		threshold:      cfg.BlockThreshold,
+		allow:          allow,
+		limits:         rateLimits(cfg),
+		campaignLimits: map[string][]RateLimitConfig{},
+		bans:           cfg.Bans.withDefaults(),
+		bypass:         cfg.Bypass,
+		challenge:      cfg.Challenge.withDefaults(),
+	}
+	if r.threshold <= 0 {
+		r.threshold = 1
+	}
+	for campaign, limits := range cfg.CampaignRateLimits {
+		r.campaignLimits[campaign] = mergeRateLimits(r.limits, limits)
+	}
+	return r, nil
+}
+
+// loadIPSet builds a set from config entries and list files; errors name the
+// offending config key.
+func loadIPSet(entriesKey string, entries []string, filesKey string, files []string) (*ipset.Set, error) {
+	s := &ipset.Set{}
+	if err := s.AddEntries(entries); err != nil {
+		return nil, fmt.Errorf("%s%w", entriesKey, err)
+	}
+	for i, f := range files {
+		if err := s.AddFile(f); err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", filesKey, i, err)
 		}
 	}
+	return s, nil
+}
 
-	go bf.gcRequests()
-	return bf, nil
+// ValidateBotFilterConfig reports whether cfg would be accepted by Reconfigure.
+func ValidateBotFilterConfig(cfg BotFilterConfig) error {
+	_, err := compileBotRules(cfg)
+	return err
+}
+
+// Reconfigure atomically replaces the filter rules. An invalid cfg leaves the
+// current rules in place.
+func (bf *botFilter) Reconfigure(cfg BotFilterConfig) error {
+	r, err := compileBotRules(cfg)
+	if err != nil {
+		return err
+	}
+	bf.attachStats(r)
+	if l := bf.limiter.Load(); l == nil || l.MaxKeys() != maxKeys(cfg) {
+		bf.limiter.Store(newLimiter(l, cfg.RateLimitMaxKeys))
+	}
+	bf.rules.Store(r)
+	return nil
 }
 
 // ===================== MIDDLEWARE =====================
 
 func (bf *botFilter) Handler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		const bypassKey = "a9f7x2kq"
-
-		if c.Query("bypass") == bypassKey {
-			utils.LogInfo(utils.LogEntry{
-				Type:  "bypass_request",
-				Extra: map[string]interface{}{"method": "query_param"},
-			})
-
+		rules := bf.rules.Load()
+		if bf.bypass(c, rules) {
 			return c.Next()
 		}
-		if c.Get("X-Bypass-Key") == bypassKey {
-			utils.LogInfo(utils.LogEntry{
-				Type:  "bypass_request",
-				Extra: map[string]interface{}{"method": "header"},
-			})
-
-			return c.Next()
-		}
-
-		ip := clientIP(c)
-		ua := strings.ToLower(c.Get("User-Agent"))
-		ref := strings.ToLower(c.Get("Referer"))
-
-		logData := ClickLog{
-			IP:        ip,
-			UserAgent: ua,
-			Referrer:  ref,
-			Timestamp: time.Now(),
-		}
-
-		// 1) Referrer blacklist
-		if host := refHost(ref); host != "" {
-			if bf.isBadReferrer(host) {
-				logEntry := buildBlockRequestLog(c, ip, "bad_referrer", map[string]interface{}{
-					"reason":       "bad_referrer",
-					"referrer":     host,
-					"full_referer": c.Get("Referer"),
-				})
-				utils.LogInfo(logEntry)
-
-				return c.Status(fiber.StatusForbidden).Send(nil)
-			}
-		}
-
-		// 2) User-Agent blacklist
-		for _, bad := range bf.cfg.BlacklistUA {
-			if bad != "" && strings.Contains(ua, bad) {
-				logEntry := buildBlockRequestLog(c, ip, "suspicious_ua", map[string]interface{}{
-					"reason":     "suspicious_ua",
-					"matched_ua": bad,
+		v := bf.evaluate(c, rules)
+		if v.allowlisted != "" {
+			if rules.cfg.LogAllowed {
+				utils.LogInfo(utils.LogEntry{
+					Type:      "allow_request",
+					IP:        v.ip,
+					UserAgent: c.Get("User-Agent"),
+					Referer:   c.Get("Referer"),
+					URL:       c.OriginalURL(),
+					Extra:     map[string]interface{}{"allowlisted": v.allowlisted},
 				})
-				utils.LogInfo(logEntry)
-
-				return c.Status(fiber.StatusForbidden).Send(nil)
 			}
+			return c.Next()
 		}
-
-		// 3) Rate limiting
-		if bf.tooMany(ip) {
-			logEntry := buildBlockRequestLog(c, ip, "rate_limit_exceeded", map[string]interface{}{
-				"reason": "rate_limit_exceeded",
-			})
-			utils.LogInfo(logEntry)
+		if v.blocked {
+			utils.LogInfo(buildBlockRequestLog(c, v.ip, v.reason, v.logExtra(rules)))
 
 			return c.Status(fiber.StatusForbidden).Send(nil)
 		}
-
-		// 4) IP prefix block
-		for _, p := range bf.cfg.BlacklistIPPrefix {
-			if strings.HasPrefix(ip, p) {
-				logEntry := buildBlockRequestLog(c, ip, "blacklisted_ip_prefix", map[string]interface{}{
-					"reason":    "blacklisted_ip_prefix",
-					"ip_prefix": p,
-				})
-				utils.LogInfo(logEntry)
-
-				return c.Status(fiber.StatusForbidden).Send(nil)
-			}
-		}
-
-		// 5) Geo filter (skip only for localhost, not all private IPs)
-		if len(bf.cfg.AllowCountries) > 0 && bf.geoDB != nil && !isLocalhostIP(ip) {
-			cc := bf.countryCode(ip, &logData)
-			if cc == "" {
-				logEntry := buildBlockRequestLog(c, ip, "geo_unknown", map[string]interface{}{
-					"reason": "geo_unknown",
-				})
-				utils.LogInfo(logEntry)
-
-				return c.Status(fiber.StatusForbidden).Send(nil)
-			}
-			if !containsStr(bf.cfg.AllowCountries, cc) {
-				logEntry := buildBlockRequestLog(c, ip, "geo_not_allowed", map[string]interface{}{
-					"reason":      "geo_not_allowed",
-					"countryCode": cc,
-				})
-				utils.LogInfo(logEntry)
-
-				return c.Status(fiber.StatusForbidden).Send(nil)
-			}
+		if rules.challenge.challenges(v) && !hasChallengePass(c, v.ip, rules.challenge) {
+			return bf.serveChallenge(c, v, rules)
 		}
-
-		// 6) Mobile only
-		if bf.cfg.AllowMobileOnly && !isMobileUA(ua) {
-			logEntry := buildBlockRequestLog(c, ip, "non_mobile_device", map[string]interface{}{
-				"reason": "non_mobile_device",
+		if v.wouldBlock {
+			// Shadow mode: record the decision, let the request through.
+			entry := buildBlockRequestLog(c, v.ip, v.shadowReason, v.logExtra(rules))
+			entry.Type = "would_block"
+			entry.Extra["reason"] = v.shadowReason
+			utils.LogInfo(entry)
+		} else if rules.cfg.LogAllowed {
+			utils.LogInfo(utils.LogEntry{
+				Type:      "allow_request",
+				IP:        v.ip,
+				UserAgent: c.Get("User-Agent"),
+				Referer:   c.Get("Referer"),
+				URL:       c.OriginalURL(),
+				Extra:     v.logExtra(rules),
 			})
-			utils.LogInfo(logEntry)
-
-			return c.Status(fiber.StatusForbidden).Send(nil)
 		}
 
 		return c.Next()
 	}
 }
 
-// ===================== HELPERS =====================
+// verdict is the outcome of running the rule pipeline on a request. Enforcing and
+// shadow rules are summed separately: only the first can block, both together
+// decide would_block.
+type verdict struct {
+	ip         string
+	score      float64
+	blocked    bool
+	wouldBlock bool
+	// allowlisted is the ip_allowlist range the IP fell in; no rule ran.
+	allowlisted string
+	// reason is the highest-scoring enforcing rule's reason; reasons lists every
+	// rule that scored.
+	reason  string
+	reasons []string
+	scores  map[string]float64
+	details map[string]interface{}
+
+	shadowScore  float64
+	shadowReason string
+	shadowScores map[string]float64
+}
 
-func clientIP(c *fiber.Ctx) string {
-	if xff := c.Get("X-Forwarded-For"); xff != "" {
-		if idx := strings.Index(xff, ","); idx > 0 {
-			return strings.TrimSpace(xff[:idx])
+// evaluate runs every enabled rule, so the logs show all signals and not just the
+// first, and sums their weighted scores. Allowlisted IPs skip the rules and any ban
+// on their subnet.
+func (bf *botFilter) evaluate(c *fiber.Ctx, rules *botRules) verdict {
+	in := &RuleInput{
+		Ctx:     c,
+		IP:      ClientIP(c),
+		UA:      strings.ToLower(c.Get("User-Agent")),
+		Referer: strings.ToLower(c.Get("Referer")),
+	}
+	bf.stats.evaluated.Add(1)
+	if addr, err := netip.ParseAddr(in.IP); err == nil {
+		if p, ok := rules.allow.Lookup(addr); ok {
+			bf.stats.allowlisted.Add(1)
+			return verdict{ip: in.IP, allowlisted: p.String()}
 		}
-
-		return strings.TrimSpace(xff)
-	}
-
-	if xr := c.Get("X-Real-Ip"); xr != "" {
-		return strings.TrimSpace(xr)
 	}
-
-	return c.IP()
-}
-
-func refHost(ref string) string {
-	if ref == "" {
-		return ""
+	if rules.bans.Enabled {
+		if err := pruneBans(rules.bans, time.Now()); err != nil {
+			utils.LogInfo(utils.LogEntry{Type: "ban_persist_error", Extra: map[string]interface{}{"error": err.Error()}})
+		}
+		if ban, ok := activeBan(in.IP, time.Now()); ok {
+			return bf.bannedVerdict(in.IP, ban, rules)
+		}
 	}
-
-	u, err := url.Parse(ref)
-	if err != nil {
-		return ""
+	// Counted only once the allowlist and bans have let the request through, so
+	// banned bots do not keep their own windows full for their neighbours.
+	in.RateLimit = bf.checkRateLimits(in, rules)
+	in.lookup = geo.Lookup
+
+	v := verdict{
+		ip:           in.IP,
+		scores:       map[string]float64{},
+		shadowScores: map[string]float64{},
+		details:      map[string]interface{}{},
+	}
+	top, topShadow := 0.0, 0.0
+	banReason := ""
+	for _, r := range rules.pipeline {
+		res := r.rule.Score(in)
+		if r.stats != nil {
+			r.stats.evaluated.Add(1)
+		}
+		if res.Score <= 0 {
+			continue
+		}
+		if r.stats != nil {
+			r.stats.hits.Add(1)
+		}
+		weighted := res.Score * r.weight
+		v.reasons = append(v.reasons, res.Reason)
+		for k, val := range res.Details {
+			v.details[k] = val
+		}
+		if r.shadow {
+			v.shadowScores[r.id] = weighted
+			v.shadowScore += weighted
+			if weighted > topShadow || v.shadowReason == "" {
+				topShadow, v.shadowReason = weighted, res.Reason
+			}
+			continue
+		}
+		v.scores[r.id] = weighted
+		v.score += weighted
+		if banReason == "" && containsStr(rules.bans.Rules, r.name) {
+			banReason = res.Reason
+		}
+		if weighted > top || v.reason == "" {
+			top, v.reason = weighted, res.Reason
+		}
 	}
+	v.blocked = v.score >= rules.threshold
+	v.wouldBlock = !v.blocked && v.score+v.shadowScore >= rules.threshold
 
-	return strings.ToLower(u.Host)
-}
-
-func (bf *botFilter) isBadReferrer(host string) bool {
-	host = strings.TrimSpace(host)
-	for _, h := range bf.cfg.BlacklistReferrer {
-		h = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(h)), ".")
-		if host == h || strings.HasSuffix(host, "."+h) {
-			return true
-		}
+	switch {
+	case v.blocked:
+		bf.stats.blocked.Add(1)
+	case v.wouldBlock:
+		bf.stats.wouldBlock.Add(1)
 	}
-	for _, re := range bf.refRegexList {
-		if re.MatchString(host) {
-			return true
+	if v.blocked && banReason != "" && rules.bans.Enabled {
+		if ban, ok := recordViolation(rules.bans, in.IP, banReason, time.Now()); ok {
+			v.details["ban_id"] = ban.ID
+			v.details["banned_until"] = ban.Until
 		}
 	}
+	return v
+}
 
-	return false
+// bannedVerdict blocks a banned request without running the rules; with the
+// filter in shadow it is only logged as would_block.
+func (bf *botFilter) bannedVerdict(ip string, ban Ban, rules *botRules) verdict {
+	bf.stats.banned.Add(1)
+	v := verdict{
+		ip:           ip,
+		scores:       map[string]float64{},
+		shadowScores: map[string]float64{},
+		details: map[string]interface{}{
+			"ban_id":       ban.ID,
+			"ban_key":      ban.Key,
+			"ban_reason":   ban.Reason,
+			"banned_until": ban.Until,
+		},
+	}
+	if rules.cfg.Shadow {
+		v.wouldBlock, v.shadowReason = true, "banned"
+		bf.stats.wouldBlock.Add(1)
+		return v
+	}
+	v.blocked, v.reason = true, "banned"
+	bf.stats.blocked.Add(1)
+	return v
 }
 
-func (bf *botFilter) countryCode(ipStr string, logData *ClickLog) string {
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		return ""
+// logExtra is the Extra of block_request, would_block and allow_request entries.
+func (v verdict) logExtra(rules *botRules) map[string]interface{} {
+	extra := map[string]interface{}{
+		"score":       v.score,
+		"threshold":   rules.threshold,
+		"rule_scores": v.scores,
 	}
-
-	rec, err := bf.geoDB.Country(ip)
-	if err != nil || rec == nil {
-		return ""
+	if v.reason != "" {
+		extra["reason"] = v.reason
 	}
-
-	cc := rec.Country.IsoCode
-	logData.Country = cc
-
-	return cc
+	if len(v.reasons) > 0 {
+		extra["reasons"] = v.reasons
+	}
+	if len(v.shadowScores) > 0 {
+		extra["shadow_score"] = v.shadowScore
+		extra["shadow_scores"] = v.shadowScores
+		extra["shadow_reason"] = v.shadowReason
+	}
+	for k, val := range v.details {
+		extra[k] = val
+	}
+	return extra
 }
 
-func (bf *botFilter) tooMany(ip string) bool {
-	now := time.Now()
-	win := time.Duration(bf.cfg.RateLimitWindowSec) * time.Second
-	limitMax := bf.cfg.RateLimitMax
-	bf.ipReqMu.Lock()
-
-	defer bf.ipReqMu.Unlock()
+// ===================== HELPERS =====================
 
-	h := bf.ipReqMap[ip]
-	j := 0
-	for _, t := range h {
-		if now.Sub(t) <= win {
-			h[j] = t
-			j++
-		}
+func refHost(ref string) string {
+	if ref == "" {
+		return ""
 	}
-	h = h[:j]
-	h = append(h, now)
-	bf.ipReqMap[ip] = h
-
-	return len(h) > limitMax
-}
 
-func (bf *botFilter) gcRequests() {
-	t := time.NewTicker(5 * time.Minute)
-	defer t.Stop()
-	for range t.C {
-		bf.ipReqMu.Lock()
-		now := time.Now()
-		win := time.Duration(bf.cfg.RateLimitWindowSec) * time.Second
-		for ip, arr := range bf.ipReqMap {
-			j := 0
-			for _, tm := range arr {
-				if now.Sub(tm) <= win {
-					arr[j] = tm
-					j++
-				}
-			}
-			if j == 0 {
-				delete(bf.ipReqMap, ip)
-			} else {
-				bf.ipReqMap[ip] = arr[:j]
-			}
-		}
-		bf.ipReqMu.Unlock()
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
 	}
+
+	return strings.ToLower(u.Host)
 }
 
 func containsStr(arr []string, s string) bool {
@@ -408,13 +495,10 @@ func buildBlockRequestLog(c *fiber.Ctx, ip, reason string, extra map[string]inte
 	// Extract meaningful information from query parameters
 	productName := ""
 	if productID := queryParams["product"]; productID != "" {
-		// Try to find product name from CSV
-		if csvProducts, err := utils.LoadProductsCSV("config/config.csv"); err == nil {
-			for _, p := range csvProducts {
-				if p.ID == productID {
-					productName = p.Name
-					break
-				}
+		// Try to find product name in the catalog snapshot
+		if Catalog != nil {
+			if p, ok := Catalog.Snapshot().Get(productID); ok {
+				productName = p.Name
 			}
 		}
 		// If not found, use product ID as name
@@ -435,7 +519,8 @@ func buildBlockRequestLog(c *fiber.Ctx, ip, reason string, extra map[string]inte
 
 	// Build headers map (selective - avoid sensitive headers)
 	headers := make(map[string]string)
-	importantHeaders := []string{"User-Agent", "Referer", "Accept", "Accept-Language", "Accept-Encoding"}
+	importantHeaders := []string{"User-Agent", "Referer", "Accept", "Accept-Language", "Accept-Encoding",
+		"Sec-CH-UA", "Sec-CH-UA-Mobile", "Sec-CH-UA-Platform", "Sec-Fetch-Site", "Sec-Fetch-Mode", "Sec-Fetch-Dest"}
 	for _, header := range importantHeaders {
 		if value := c.Get(header); value != "" {
 			headers[header] = value
@@ -459,6 +544,11 @@ func buildBlockRequestLog(c *fiber.Ctx, ip, reason string, extra map[string]inte
 		extra[k] = v
 	}
 
+	// The arrival order of the headers, for tuning the fingerprint rule.
+	if order := headerOrder(c); len(order) > 0 {
+		extra["header_order"] = order
+	}
+
 	// Add block reason and type_ads to extra
 	extra["block_reason"] = reason
 	if typeAds := queryParams["type_ads"]; typeAds != "" {
@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"go-redirect/geo"
+	"go-redirect/ipset"
+	"go-redirect/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Rule scores one signal of a request from 0 (clean) to 1 (bot). The filter adds
+// up score × weight over its rules and blocks once the total reaches the threshold.
+type Rule interface {
+	Score(in *RuleInput) RuleResult
+}
+
+// RuleResult is a rule's verdict. Reason and Details are logged when Score > 0.
+type RuleResult struct {
+	Score   float64
+	Reason  string
+	Details map[string]interface{}
+}
+
+// RuleInput is what rules see of a request.
+type RuleInput struct {
+	Ctx     *fiber.Ctx
+	IP      string
+	UA      string // lower-cased User-Agent
+	Referer string // lower-cased Referer
+	// RateLimit is the first rate limit the request exceeded, if any.
+	RateLimit *RateLimitHit
+
+	lookup     func(ip string) geo.Record
+	lookupDone bool
+	geoRec     geo.Record
+}
+
+// geo runs the request's single combined geo lookup.
+func (in *RuleInput) geo() geo.Record {
+	if !in.lookupDone {
+		in.lookupDone = true
+		if in.lookup != nil {
+			in.geoRec = in.lookup(in.IP)
+		}
+	}
+	return in.geoRec
+}
+
+// Country returns the IP's ISO country. ok is false when no GeoIP database is
+// loaded; cc is empty when the IP is not in it.
+func (in *RuleInput) Country() (cc string, ok bool) {
+	r := in.geo()
+	return r.Info.CountryCode, r.HasCountry
+}
+
+// ASN returns the IP's autonomous system. ok is false when no ASN database is
+// loaded; num is 0 when the IP is not in it.
+func (in *RuleInput) ASN() (num uint, org string, ok bool) {
+	r := in.geo()
+	return r.Info.ASN, r.Info.ASOrg, r.HasASN
+}
+
+// RuleConfig places a rule in the pipeline. ID defaults to Name; Match, when set,
+// replaces the rule's blacklist. Shadow rules only count toward would_block.
+type RuleConfig struct {
+	Name    string
+	ID      string
+	Weight  float64
+	Enabled bool
+	Shadow  bool
+	Match   []string
+}
+
+// ruleBuilders compiles each rule named in models.BotRuleNames from the config.
+var ruleBuilders = map[string]func(BotFilterConfig, RuleConfig) (Rule, error){
+	"referrer": newReferrerRule,
+	"user_agent": func(cfg BotFilterConfig, rc RuleConfig) (Rule, error) {
+		return userAgentRule(matchOr(rc, cfg.BlacklistUA)), nil
+	},
+	"fingerprint": newFingerprintRule,
+	"rate_limit":  func(cfg BotFilterConfig, rc RuleConfig) (Rule, error) { return rateLimitRule{}, nil },
+	"ip_prefix":   newIPPrefixRule,
+	"asn":         newASNRule,
+	"geo":         func(cfg BotFilterConfig, rc RuleConfig) (Rule, error) { return geoRule(cfg.AllowCountries), nil },
+	"mobile_only": func(cfg BotFilterConfig, rc RuleConfig) (Rule, error) {
+		return mobileOnlyRule(cfg.AllowMobileOnly), nil
+	},
+}
+
+func matchOr(rc RuleConfig, list []string) []string {
+	if rc.Match != nil {
+		return rc.Match
+	}
+	return list
+}
+
+// scoredRule is a compiled pipeline step. stats is attached by Reconfigure.
+type scoredRule struct {
+	id     string
+	name   string
+	weight float64
+	shadow bool
+	rule   Rule
+	stats  *ruleStats
+}
+
+// compilePipeline orders the configured rules first, then the unlisted ones in
+// default order with weight 1, leaving out disabled rules.
+func compilePipeline(cfg BotFilterConfig) ([]scoredRule, error) {
+	listed := map[string]bool{}
+	var order []RuleConfig
+	for _, rc := range cfg.Rules {
+		if rc.ID == "" {
+			rc.ID = rc.Name
+		}
+		if listed[rc.ID] {
+			return nil, fmt.Errorf("rule %q listed twice", rc.ID)
+		}
+		listed[rc.ID] = true
+		order = append(order, rc)
+	}
+	for _, name := range models.BotRuleNames {
+		if !listed[name] {
+			order = append(order, RuleConfig{Name: name, ID: name, Weight: 1, Enabled: true})
+		}
+	}
+
+	var pipeline []scoredRule
+	for _, rc := range order {
+		build, ok := ruleBuilders[rc.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rule %q", rc.Name)
+		}
+		if !rc.Enabled {
+			continue
+		}
+		rule, err := build(cfg, rc)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, scoredRule{
+			id:     rc.ID,
+			name:   rc.Name,
+			weight: rc.Weight,
+			shadow: rc.Shadow || cfg.Shadow,
+			rule:   rule,
+		})
+	}
+	return pipeline, nil
+}
+
+// ===================== RULES =====================
+
+type referrerRule struct {
+	domains []string
+	regexes []*regexp.Regexp
+}
+
+// newReferrerRule matches blacklist_referrer domains and blacklist_ref_regex; a
+// Match list replaces both.
+func newReferrerRule(cfg BotFilterConfig, rc RuleConfig) (Rule, error) {
+	r := referrerRule{}
+	patterns := cfg.BlacklistRefRegex
+	if rc.Match != nil {
+		patterns = nil
+	}
+	for _, h := range matchOr(rc, cfg.BlacklistReferrer) {
+		r.domains = append(r.domains, strings.TrimPrefix(strings.ToLower(strings.TrimSpace(h)), "."))
+	}
+	for i, pat := range patterns {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, fmt.Errorf("blacklist_ref_regex[%d] %q: %w", i, pat, err)
+		}
+		r.regexes = append(r.regexes, re)
+	}
+	return r, nil
+}
+
+func (r referrerRule) Score(in *RuleInput) RuleResult {
+	host := strings.TrimSpace(refHost(in.Referer))
+	if host == "" {
+		return RuleResult{}
+	}
+	bad := false
+	for _, h := range r.domains {
+		bad = bad || host == h || strings.HasSuffix(host, "."+h)
+	}
+	for _, re := range r.regexes {
+		bad = bad || re.MatchString(host)
+	}
+	if !bad {
+		return RuleResult{}
+	}
+	return RuleResult{Score: 1, Reason: "bad_referrer", Details: map[string]interface{}{
+		"referrer":     host,
+		"full_referer": in.Ctx.Get("Referer"),
+	}}
+}
+
+type userAgentRule []string
+
+func (r userAgentRule) Score(in *RuleInput) RuleResult {
+	for _, bad := range r {
+		if bad != "" && strings.Contains(in.UA, bad) {
+			return RuleResult{Score: 1, Reason: "suspicious_ua", Details: map[string]interface{}{"matched_ua": bad}}
+		}
+	}
+	return RuleResult{}
+}
+
+// rateLimitRule flags requests over any rate limit; the counting is done by the
+// filter, which applies every limit of the request's campaign.
+type rateLimitRule struct{}
+
+func (rateLimitRule) Score(in *RuleInput) RuleResult {
+	hit := in.RateLimit
+	if hit == nil {
+		return RuleResult{}
+	}
+	return RuleResult{Score: 1, Reason: "rate_limit_exceeded", Details: map[string]interface{}{
+		"hits":                  hit.Hits,
+		"rate_limit_key":        hit.Key,
+		"rate_limit":            hit.Max,
+		"rate_limit_window_sec": hit.Window.Seconds(),
+	}}
+}
+
+type ipPrefixRule struct{ set *ipset.Set }
+
+// newIPPrefixRule loads blacklist_ip_prefix and ip_blocklist_files; a Match list
+// replaces both.
+func newIPPrefixRule(cfg BotFilterConfig, rc RuleConfig) (Rule, error) {
+	if rc.Match != nil {
+		set, err := loadIPSet("rules["+rc.ID+"].match", rc.Match, "", nil)
+		return ipPrefixRule{set}, err
+	}
+	set, err := loadIPSet("blacklist_ip_prefix", cfg.BlacklistIPPrefix, "ip_blocklist_files", cfg.IPBlocklistFiles)
+	return ipPrefixRule{set}, err
+}
+
+func (r ipPrefixRule) Score(in *RuleInput) RuleResult {
+	addr, err := netip.ParseAddr(in.IP)
+	if err != nil {
+		return RuleResult{}
+	}
+	if p, ok := r.set.Lookup(addr); ok {
+		return RuleResult{Score: 1, Reason: "blacklisted_ip_prefix", Details: map[string]interface{}{"ip_prefix": p.String()}}
+	}
+	return RuleResult{}
+}
+
+// asnRule blocks networks by number or by asn_categories membership.
+type asnRule struct {
+	block, allow map[uint]bool
+	categories   map[string][]uint
+	blockCats    []string
+}
+
+func newASNRule(cfg BotFilterConfig, rc RuleConfig) (Rule, error) {
+	r := asnRule{block: map[uint]bool{}, allow: map[uint]bool{}, categories: cfg.ASNCategories, blockCats: cfg.BlockASNCategories}
+	for _, n := range cfg.BlockASNs {
+		r.block[n] = true
+	}
+	for _, n := range cfg.AllowASNs {
+		r.allow[n] = true
+	}
+	for _, cat := range cfg.BlockASNCategories {
+		if _, ok := cfg.ASNCategories[cat]; !ok {
+			return nil, fmt.Errorf("block_asn_categories: unknown category %q", cat)
+		}
+	}
+	return r, nil
+}
+
+func (r asnRule) Score(in *RuleInput) RuleResult {
+	if len(r.block) == 0 && len(r.blockCats) == 0 {
+		return RuleResult{}
+	}
+	num, org, ok := in.ASN()
+	if !ok || num == 0 || r.allow[num] {
+		return RuleResult{}
+	}
+	details := map[string]interface{}{"asn": num, "as_org": org}
+	if r.block[num] {
+		return RuleResult{Score: 1, Reason: "blocked_asn", Details: details}
+	}
+	for _, cat := range geo.ASNCategories(r.categories, num) {
+		if containsStr(r.blockCats, cat) {
+			details["asn_category"] = cat
+			return RuleResult{Score: 1, Reason: "blocked_asn_category", Details: details}
+		}
+	}
+	return RuleResult{}
+}
+
+// geoRule only applies with an allow list and a GeoIP database, and skips
+// localhost (not all private IPs).
+type geoRule []string
+
+func (allow geoRule) Score(in *RuleInput) RuleResult {
+	if len(allow) == 0 || isLocalhostIP(in.IP) {
+		return RuleResult{}
+	}
+	cc, ok := in.Country()
+	switch {
+	case !ok:
+		return RuleResult{}
+	case cc == "":
+		return RuleResult{Score: 1, Reason: "geo_unknown"}
+	case !containsStr(allow, cc):
+		return RuleResult{Score: 1, Reason: "geo_not_allowed", Details: map[string]interface{}{"countryCode": cc}}
+	}
+	return RuleResult{}
+}
+
+type mobileOnlyRule bool
+
+func (on mobileOnlyRule) Score(in *RuleInput) RuleResult {
+	if bool(on) && !isMobileUA(in.UA) {
+		return RuleResult{Score: 1, Reason: "non_mobile_device"}
+	}
+	return RuleResult{}
+}
@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-redirect/geo"
+	"go-redirect/models"
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBotFilterScoresCombineWeakSignals(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	trustTestPeer(t)
+	bf, err := NewBotFilter(BotFilterConfig{
+		BlacklistUA:       []string{"curl"},
+		BlacklistIPPrefix: []string{"34."},
+		AllowMobileOnly:   true,
+		RateLimitMax:      100,
+		LogAllowed:        true,
+		BlockThreshold:    1,
+		Rules: []RuleConfig{
+			{Name: "mobile_only", Weight: 0.5, Enabled: true},
+			{Name: "ip_prefix", Weight: 0.5, Enabled: true},
+			{Name: "user_agent", Weight: 1, Enabled: false},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	for _, tc := range []struct {
+		name, ip, ua string
+		want         int
+	}{
+		{"desktop alone is a weak signal", "36.1.1.1", "Mozilla/5.0 (Windows NT 10.0)", 200},
+		{"desktop from a datacenter prefix", "34.1.1.1", "Mozilla/5.0 (Windows NT 10.0)", 403},
+		{"disabled rule does not score", "36.1.1.2", "curl/8.0 android", 200},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", tc.ip)
+		req.Header.Set("User-Agent", tc.ua)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+	}
+
+	var blocked, allowed []utils.LogEntry
+	utils.ForEachLogEntry(func(e utils.LogEntry) {
+		switch e.Type {
+		case "block_request":
+			blocked = append(blocked, e)
+		case "allow_request":
+			allowed = append(allowed, e)
+		}
+	})
+	if len(blocked) != 1 || len(allowed) != 2 {
+		t.Fatalf("got %d block and %d allow entries", len(blocked), len(allowed))
+	}
+	scores, _ := blocked[0].Extra["rule_scores"].(map[string]interface{})
+	if scores["mobile_only"] != 0.5 || scores["ip_prefix"] != 0.5 || blocked[0].Extra["score"] != 1.0 {
+		t.Errorf("block entry scores: %v", blocked[0].Extra)
+	}
+	if allowed[0].Extra["score"] != 0.5 {
+		t.Errorf("allow entry score: %v", allowed[0].Extra)
+	}
+}
+
+func TestBotFilterShadowRulesLogWouldBlock(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	cfg := BotFilterConfig{
+		BlacklistUA:  []string{"curl"},
+		RateLimitMax: 100,
+		Rules: []RuleConfig{
+			{Name: "user_agent", ID: "ua_candidates", Weight: 1, Enabled: true, Shadow: true, Match: []string{"okhttp"}},
+		},
+	}
+	bf, err := NewBotFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	get := func(ua string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("User-Agent", ua)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := get("okhttp/4.9"); got != 200 {
+		t.Errorf("shadow rule hit: status %d, want 200", got)
+	}
+	if got := get("curl/8.0"); got != 403 {
+		t.Errorf("enforcing rule next to its shadow instance: status %d, want 403", got)
+	}
+	get("Mozilla/5.0 (Linux; Android 13)")
+
+	// The whole filter in shadow lets everything through.
+	cfg.Shadow = true
+	if err := bf.Reconfigure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("curl/8.0"); got != 200 {
+		t.Errorf("filter in shadow: status %d, want 200", got)
+	}
+
+	var would []string
+	utils.ForEachLogEntry(func(e utils.LogEntry) {
+		if e.Type == "would_block" {
+			would = append(would, e.Extra["reason"].(string))
+		}
+	})
+	if len(would) != 2 || would[0] != "suspicious_ua" {
+		t.Errorf("would_block entries: %v", would)
+	}
+
+	st := bf.Stats()
+	if st.Blocked != 1 || st.WouldBlock != 2 || st.Evaluated != 4 {
+		t.Errorf("filter stats: %+v", st)
+	}
+	for _, r := range st.Rules {
+		if r.ID == "ua_candidates" && (r.Hits != 1 || r.Evaluated != 4 || r.HitRate != 0.25) {
+			t.Errorf("shadow rule stats: %+v", r)
+		}
+	}
+}
+
+func TestBotFilterIPRangesAndAllowlist(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	trustTestPeer(t)
+	bf, err := NewBotFilter(BotFilterConfig{
+		BlacklistIPPrefix: []string{"34.", "104.28.0.0/20", "2001:db8::/32"},
+		IPAllowlist:       []string{"104.28.3.7", "2001:db8:1::/48"},
+		RateLimitMax:      100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	for ip, want := range map[string]int{
+		"34.1.1.1":        403,
+		"134.1.1.1":       200, // "34." used to match this too
+		"104.28.15.1":     403,
+		"104.28.16.1":     200,
+		"104.28.3.7":      200, // allowlisted inside a blocked range
+		"2001:db8:5::1":   403,
+		"2001:db8:1::abc": 200,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: status %d, want %d", ip, resp.StatusCode, want)
+		}
+	}
+	if st := bf.Stats(); st.Allowlisted != 2 || st.AllowlistSize != 2 {
+		t.Errorf("allowlist stats: %+v", st)
+	}
+
+	if _, err := NewBotFilter(BotFilterConfig{IPAllowlist: []string{"10.0.0.300"}}); err == nil {
+		t.Error("invalid allowlist entry accepted")
+	}
+}
+
+func TestASNRuleBlocksNetworksAndCategories(t *testing.T) {
+	rule, err := newASNRule(BotFilterConfig{
+		ASNCategories:      map[string][]uint{"hosting": {16509, 15169}, "mobile_id": {23693}},
+		BlockASNs:          []uint{64500},
+		BlockASNCategories: []string{"hosting"},
+		AllowASNs:          []uint{15169},
+	}, RuleConfig{Name: "asn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for asn, want := range map[uint]string{
+		64500: "blocked_asn",
+		16509: "blocked_asn_category",
+		15169: "", // allowed despite its category
+		23693: "",
+		0:     "",
+	} {
+		in := &RuleInput{IP: "203.0.113.1", lookup: func(string) geo.Record {
+			return geo.Record{Info: models.GeoInfo{ASN: asn, ASOrg: "Example"}, HasASN: true}
+		}}
+		if got := rule.Score(in).Reason; got != want {
+			t.Errorf("AS%d: reason %q, want %q", asn, got, want)
+		}
+	}
+
+	if _, err := newASNRule(BotFilterConfig{BlockASNCategories: []string{"nope"}}, RuleConfig{Name: "asn"}); err == nil {
+		t.Error("unknown category accepted")
+	}
+}
+
+func TestGeoRuleUsesGeoService(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	trustTestPeer(t)
+	geo.Use(geo.NewService(geo.Fake{
+		"36.68.1.1": {CountryCode: "ID"},
+		"8.8.8.8":   {CountryCode: "US"},
+	}, 0))
+	defer geo.Close()
+
+	bf, err := NewBotFilter(BotFilterConfig{AllowCountries: []string{"ID"}, RateLimitMax: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	for ip, want := range map[string]int{"36.68.1.1": 200, "8.8.8.8": 403, "203.0.113.1": 403} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: status %d, want %d", ip, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestBotFilterRateLimitKeysAndCampaigns(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	trustTestPeer(t)
+	bf, err := NewBotFilter(BotFilterConfig{
+		RateLimitMax: 100,
+		RateLimits:   []RateLimitConfig{{Key: "subnet", Max: 3, Window: time.Minute}},
+		CampaignRateLimits: map[string][]RateLimitConfig{
+			"promo": {{Key: "spot_id", Max: 1, Window: time.Minute}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	get := func(ip, query string) int {
+		req := httptest.NewRequest("GET", "/?"+query, nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	for i := 1; i <= 3; i++ {
+		if got := get(fmt.Sprintf("10.1.1.%d", i), ""); got != 200 {
+			t.Fatalf("request %d within the subnet limit: status %d", i, got)
+		}
+	}
+	if got := get("10.1.1.9", ""); got != 403 {
+		t.Errorf("fourth request from the /24: status %d, want 403", got)
+	}
+	if got := get("10.1.2.1", ""); got != 200 {
+		t.Errorf("another /24: status %d, want 200", got)
+	}
+	// The campaign counts separately and adds its spot_id limit.
+	if got := get("10.1.1.9", "campaign=promo&spot_id=s1"); got != 200 {
+		t.Errorf("campaign request: status %d, want 200", got)
+	}
+	if got := get("10.9.9.9", "campaign=promo&spot_id=s1"); got != 403 {
+		t.Errorf("second request for the spot: status %d, want 403", got)
+	}
+
+	var keys []interface{}
+	utils.ForEachLogEntry(func(e utils.LogEntry) {
+		if e.Type == "block_request" {
+			keys = append(keys, e.Extra["rate_limit_key"])
+		}
+	})
+	if len(keys) != 2 || keys[0] != "subnet" || keys[1] != "spot_id" {
+		t.Errorf("blocked rate_limit_key values: %v", keys)
+	}
+}
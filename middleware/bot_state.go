@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"go-redirect/ratelimit"
+)
+
+// SaveState writes the rate-limit counters to path so a restart does not hand
+// every visitor a fresh quota. Counters whose windows ran out are dropped.
+func (bf *botFilter) SaveState(path string) error {
+	data, err := json.Marshal(bf.limiter.Load().Snapshot(time.Now()))
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadState restores counters written by SaveState. A missing file is not an
+// error; the per-IP timestamp lists of older versions are converted.
+func (bf *botFilter) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state map[string]ratelimit.State
+	if err := json.Unmarshal(data, &state); err != nil {
+		var legacy map[string][]time.Time
+		if json.Unmarshal(data, &legacy) != nil {
+			return err
+		}
+		state = bf.legacyState(legacy)
+	}
+	bf.limiter.Load().Restore(state)
+	return nil
+}
+
+// legacyState turns per-IP request times into counters of the global ip limit.
+func (bf *botFilter) legacyState(legacy map[string][]time.Time) map[string]ratelimit.State {
+	window := defaultRateLimitWindow
+	for _, l := range bf.rules.Load().limits {
+		if l.Key == "ip" {
+			window = l.Window
+		}
+	}
+	now := time.Now()
+	state := map[string]ratelimit.State{}
+	for ip, hits := range legacy {
+		st := ratelimit.State{WindowSec: window.Seconds()}
+		for _, t := range hits {
+			if now.Sub(t) > window {
+				continue
+			}
+			if st.Cur == 0 || t.Before(st.Start) {
+				st.Start = t
+			}
+			st.Cur++
+		}
+		if st.Cur > 0 {
+			state["|ip|"+ip] = st
+		}
+	}
+	return state
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ruleStats counts how often a rule scored since it first appeared in the pipeline.
+// Counters are kept by rule id across reloads.
+type ruleStats struct {
+	since     time.Time
+	evaluated atomic.Int64
+	hits      atomic.Int64
+}
+
+// filterStats counts the filter's decisions since the process started.
+type filterStats struct {
+	mu    sync.Mutex
+	since time.Time
+	rules map[string]*ruleStats
+
+	evaluated   atomic.Int64
+	blocked     atomic.Int64
+	wouldBlock  atomic.Int64
+	allowlisted atomic.Int64
+	banned      atomic.Int64
+	// challenges is keyed by traffic source (type_ads); guarded by mu.
+	challenges map[string]*ChallengeCounts
+}
+
+// Challenge outcomes counted per traffic source.
+const (
+	challengeIssued = iota
+	challengePassed
+	challengeFailed
+)
+
+// ChallengeCounts are the challenges one traffic source was served, passed and
+// failed.
+type ChallengeCounts struct {
+	Issued int64 `json:"issued"`
+	Passed int64 `json:"passed"`
+	Failed int64 `json:"failed"`
+}
+
+// countChallenge records a challenge outcome for source; an empty source is
+// counted as "none".
+func (s *filterStats) countChallenge(source string, outcome int) {
+	if source == "" {
+		source = "none"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.challenges == nil {
+		s.challenges = map[string]*ChallengeCounts{}
+	}
+	n, ok := s.challenges[source]
+	if !ok {
+		n = &ChallengeCounts{}
+		s.challenges[source] = n
+	}
+	switch outcome {
+	case challengeIssued:
+		n.Issued++
+	case challengePassed:
+		n.Passed++
+	case challengeFailed:
+		n.Failed++
+	}
+}
+
+// attachStats points every pipeline step at its rule's counters.
+func (bf *botFilter) attachStats(r *botRules) {
+	bf.stats.mu.Lock()
+	defer bf.stats.mu.Unlock()
+	if bf.stats.rules == nil {
+		bf.stats.since = time.Now()
+		bf.stats.rules = map[string]*ruleStats{}
+	}
+	for i := range r.pipeline {
+		id := r.pipeline[i].id
+		st, ok := bf.stats.rules[id]
+		if !ok {
+			st = &ruleStats{since: time.Now()}
+			bf.stats.rules[id] = st
+		}
+		r.pipeline[i].stats = st
+	}
+}
+
+// RuleStat is one pipeline rule's hit rate against live traffic.
+type RuleStat struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Weight    float64   `json:"weight"`
+	Shadow    bool      `json:"shadow"`
+	Since     time.Time `json:"since"`
+	Evaluated int64     `json:"evaluated"`
+	Hits      int64     `json:"hits"`
+	HitRate   float64   `json:"hit_rate"`
+}
+
+// BotFilterStats is what GET /bot-filter-rules reports.
+type BotFilterStats struct {
+	Since      time.Time `json:"since"`
+	Shadow     bool      `json:"shadow"`
+	Threshold  float64   `json:"threshold"`
+	Evaluated  int64     `json:"evaluated"`
+	Blocked    int64     `json:"blocked"`
+	WouldBlock int64     `json:"would_block"`
+	// Allowlisted requests are counted in Evaluated but skip every rule.
+	Allowlisted   int64 `json:"allowlisted"`
+	AllowlistSize int   `json:"allowlist_size"`
+	// Banned requests were blocked by a ban before any rule ran.
+	Banned     int64 `json:"banned"`
+	ActiveBans int   `json:"active_bans"`
+	// Challenges counts JavaScript challenges by traffic source (type_ads).
+	Challenges map[string]ChallengeCounts `json:"challenges"`
+	Rules      []RuleStat                 `json:"rules"`
+}
+
+// Stats reports the current pipeline, in order, with each rule's hit rate.
+func (bf *botFilter) Stats() BotFilterStats {
+	rules := bf.rules.Load()
+	out := BotFilterStats{
+		Since:         bf.stats.since,
+		Shadow:        rules.cfg.Shadow,
+		Threshold:     rules.threshold,
+		Evaluated:     bf.stats.evaluated.Load(),
+		Blocked:       bf.stats.blocked.Load(),
+		WouldBlock:    bf.stats.wouldBlock.Load(),
+		Allowlisted:   bf.stats.allowlisted.Load(),
+		AllowlistSize: rules.allow.Len(),
+		Banned:        bf.stats.banned.Load(),
+		ActiveBans:    len(Bans()),
+		Challenges:    map[string]ChallengeCounts{},
+		Rules:         []RuleStat{},
+	}
+	bf.stats.mu.Lock()
+	for source, n := range bf.stats.challenges {
+		out.Challenges[source] = *n
+	}
+	bf.stats.mu.Unlock()
+	for _, r := range rules.pipeline {
+		st := RuleStat{ID: r.id, Name: r.name, Weight: r.weight, Shadow: r.shadow}
+		if r.stats != nil {
+			st.Since = r.stats.since
+			st.Evaluated = r.stats.evaluated.Load()
+			st.Hits = r.stats.hits.Load()
+			if st.Evaluated > 0 {
+				st.HitRate = float64(st.Hits) / float64(st.Evaluated)
+			}
+		}
+		out.Rules = append(out.Rules, st)
+	}
+	return out
+}
+
+// StatsHandler serves GET /bot-filter-rules.
+func (bf *botFilter) StatsHandler(c *fiber.Ctx) error {
+	return c.JSON(bf.Stats())
+}
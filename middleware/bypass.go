@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	bypassParam  = "bypass"
+	bypassHeader = "X-Bypass-Key"
+)
+
+// BypassKey is a named static bypass token, stored as its hex SHA-256.
+type BypassKey struct {
+	Name      string
+	KeySHA256 string
+	Expires   time.Time
+}
+
+// BypassConfig holds the credentials that skip the filter. LinkSecret verifies
+// signed links; empty disables them.
+type BypassConfig struct {
+	Keys       []BypassKey
+	LinkSecret []byte
+}
+
+// checkBypass returns the name of the key or link that token is. err is
+// utils.ErrBypassExpired for a genuine but lapsed credential, whose name is
+// still returned, and utils.ErrBypassInvalid for anything else.
+func (b BypassConfig) checkBypass(token string, now time.Time) (name string, err error) {
+	sum := sha256.Sum256([]byte(token))
+	given := []byte(hex.EncodeToString(sum[:]))
+	for _, k := range b.Keys {
+		if subtle.ConstantTimeCompare(given, []byte(k.KeySHA256)) != 1 {
+			continue
+		}
+		if !k.Expires.IsZero() && !now.Before(k.Expires) {
+			return k.Name, utils.ErrBypassExpired
+		}
+		return k.Name, nil
+	}
+	if len(b.LinkSecret) > 0 {
+		claims, err := utils.VerifyBypass(b.LinkSecret, token, now)
+		if err == nil || errors.Is(err, utils.ErrBypassExpired) {
+			return claims.Name, err
+		}
+	}
+	return "", utils.ErrBypassInvalid
+}
+
+// takeBypass reads the request's bypass token and strips it, so it reaches
+// neither the logs nor the merchant URL.
+func takeBypass(c *fiber.Ctx) (token, method string) {
+	if token = c.Get(bypassHeader); token != "" {
+		method = "header"
+	}
+	c.Request().Header.Del(bypassHeader)
+
+	args := c.Request().URI().QueryArgs()
+	if !args.Has(bypassParam) {
+		return token, method
+	}
+	if token == "" {
+		token, method = string(args.Peek(bypassParam)), "query_param"
+	}
+	args.Del(bypassParam)
+	c.Request().Header.SetRequestURIBytes(c.Request().URI().RequestURI())
+	return token, method
+}
+
+// bypass reports whether the request carries a valid bypass credential and logs
+// every attempt with the key's name.
+func (bf *botFilter) bypass(c *fiber.Ctx, rules *botRules) bool {
+	token, method := takeBypass(c)
+	if token == "" {
+		return false
+	}
+	name, err := rules.bypass.checkBypass(token, time.Now())
+	extra := map[string]interface{}{"method": method}
+	if name != "" {
+		extra["key"] = name
+	}
+	if err != nil {
+		extra["error"] = err.Error()
+		utils.LogInfo(utils.LogEntry{Type: "bypass_rejected", IP: ClientIP(c), URL: c.OriginalURL(), Extra: extra})
+		return false
+	}
+	utils.LogInfo(utils.LogEntry{Type: "bypass_request", IP: ClientIP(c), URL: c.OriginalURL(), Extra: extra})
+	return true
+}
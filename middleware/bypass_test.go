@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBypassKeysAndSignedLinks(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	hash := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	secret := []byte("0123456789abcdef")
+	bf, err := NewBotFilter(BotFilterConfig{
+		BlacklistUA: []string{"curl"},
+		Bypass: BypassConfig{
+			Keys: []BypassKey{
+				{Name: "qa-team", KeySHA256: hash("live-key")},
+				{Name: "old-vendor", KeySHA256: hash("old-key"), Expires: time.Now().Add(-time.Hour)},
+			},
+			LinkSecret: secret,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error {
+		return c.SendString(c.OriginalURL() + " " + c.Query("bypass") + c.Get("X-Bypass-Key"))
+	})
+	get := func(query, header string) (int, string) {
+		url := "/?product=1"
+		if query != "" {
+			url += "&" + query
+		}
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("User-Agent", "curl/8.0")
+		if header != "" {
+			req.Header.Set("X-Bypass-Key", header)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, 256)
+		n, _ := resp.Body.Read(body)
+		return resp.StatusCode, string(body[:n])
+	}
+
+	link := utils.SignBypass(secret, utils.BypassClaims{Name: "qa-pixel", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expired := utils.SignBypass(secret, utils.BypassClaims{Name: "qa-old", ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+	for _, tc := range []struct {
+		name, query, header string
+		want                int
+	}{
+		{"named key", "bypass=live-key", "", 200},
+		{"named key in header", "", "live-key", 200},
+		{"expired key", "bypass=old-key", "", 403},
+		{"signed link", "bypass=" + link, "", 200},
+		{"expired link", "bypass=" + expired, "", 403},
+		{"link signed with another secret", "bypass=" + utils.SignBypass([]byte("another-secret-1"), utils.BypassClaims{Name: "x", ExpiresAt: time.Now().Add(time.Hour).Unix()}), "", 403},
+	} {
+		status, body := get(tc.query, tc.header)
+		if status != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, status, tc.want)
+		}
+		if status == 200 && body != "/?product=1 " {
+			t.Errorf("%s: bypass credential reached the handler: %q", tc.name, body)
+		}
+	}
+
+	var used, rejected []interface{}
+	utils.ForEachLogEntry(func(e utils.LogEntry) {
+		switch e.Type {
+		case "bypass_request":
+			used = append(used, e.Extra["key"])
+		case "bypass_rejected":
+			rejected = append(rejected, e.Extra["key"])
+		}
+	})
+	if len(used) != 3 || used[0] != "qa-team" || used[2] != "qa-pixel" {
+		t.Errorf("bypass_request keys: %v", used)
+	}
+	if len(rejected) != 3 || rejected[0] != "old-vendor" || rejected[1] != "qa-old" || rejected[2] != nil {
+		t.Errorf("bypass_rejected keys: %v", rejected)
+	}
+}
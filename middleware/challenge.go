@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// ChallengePath is where the interstitial posts its answer.
+	ChallengePath   = "/challenge"
+	challengeCookie = "gr_challenge"
+	challengeView   = "challenge"
+
+	defaultChallengeDifficulty = 14
+	defaultChallengeTTL        = 2 * time.Minute
+	defaultChallengeCookieTTL  = 30 * time.Minute
+)
+
+// ChallengeConfig is bot_filter.challenge: requests whose enforcing score reaches
+// Threshold, but not the block threshold, must solve the interstitial first.
+type ChallengeConfig struct {
+	Enabled      bool
+	Threshold    float64
+	Difficulty   int
+	Secret       []byte
+	CookieTTL    time.Duration
+	ChallengeTTL time.Duration
+}
+
+func (c ChallengeConfig) withDefaults() ChallengeConfig {
+	if c.Difficulty <= 0 {
+		c.Difficulty = defaultChallengeDifficulty
+	}
+	if c.ChallengeTTL <= 0 {
+		c.ChallengeTTL = defaultChallengeTTL
+	}
+	if c.CookieTTL <= 0 {
+		c.CookieTTL = defaultChallengeCookieTTL
+	}
+	return c
+}
+
+// challenges reports whether a request that was not blocked must be challenged.
+func (c ChallengeConfig) challenges(v verdict) bool {
+	return c.Enabled && len(c.Secret) > 0 && c.Threshold > 0 && v.score >= c.Threshold
+}
+
+// hasChallengePass reports whether the request carries a pass cookie issued to
+// this visitor.
+func hasChallengePass(c *fiber.Ctx, ip string, cfg ChallengeConfig) bool {
+	token := c.Cookies(challengeCookie)
+	if token == "" {
+		return false
+	}
+	_, err := utils.VerifyChallengePass(cfg.Secret, token, utils.VisitorFingerprint(ip, c.Get("User-Agent")), time.Now())
+	return err == nil
+}
+
+// serveChallenge renders the interstitial in place of the requested page. It is
+// sent with 200 and no-store so ad networks and caches see an ordinary page.
+func (bf *botFilter) serveChallenge(c *fiber.Ctx, v verdict, rules *botRules) error {
+	cfg := rules.challenge
+	source := c.Query("type_ads")
+	claims := utils.ChallengeClaims{
+		Visitor:    utils.VisitorFingerprint(v.ip, c.Get("User-Agent")),
+		Nonce:      utils.RandomID(16),
+		Difficulty: cfg.Difficulty,
+		Target:     c.OriginalURL(),
+		Source:     source,
+		ExpiresAt:  time.Now().Add(cfg.ChallengeTTL).Unix(),
+	}
+	bf.stats.countChallenge(source, challengeIssued)
+	extra := v.logExtra(rules)
+	extra["type_ads"] = source
+	extra["difficulty"] = cfg.Difficulty
+	utils.LogInfo(utils.LogEntry{
+		Type:      "challenge_issued",
+		IP:        v.ip,
+		UserAgent: c.Get("User-Agent"),
+		Referer:   c.Get("Referer"),
+		URL:       c.OriginalURL(),
+		Extra:     extra,
+	})
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Render(challengeView, fiber.Map{
+		"Action":     ChallengePath,
+		"Token":      utils.SignChallenge(cfg.Secret, claims),
+		"Nonce":      claims.Nonce,
+		"Difficulty": claims.Difficulty,
+	})
+}
+
+// ChallengeHandler serves POST /challenge, the interstitial's answer. A solved
+// challenge sets the pass cookie and redirects to the page first requested; a
+// failed one is logged and refused with 403.
+func (bf *botFilter) ChallengeHandler(c *fiber.Ctx) error {
+	cfg := bf.rules.Load().challenge
+	if !cfg.Enabled || len(cfg.Secret) == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	ip := ClientIP(c)
+	claims, err := utils.VerifyChallenge(cfg.Secret, c.FormValue("token"), time.Now())
+	reason := ""
+	switch {
+	case errors.Is(err, utils.ErrChallengeExpired):
+		reason = "expired"
+	case err != nil:
+		reason = "invalid_token"
+	case claims.Visitor != utils.VisitorFingerprint(ip, c.Get("User-Agent")):
+		reason = "visitor_mismatch"
+	case !challengeSolved(claims.Nonce, c.FormValue("counter"), claims.Difficulty):
+		reason = "proof_of_work"
+	default:
+		reason = browserEnvFailure(c)
+	}
+
+	entry := utils.LogEntry{
+		IP:        ip,
+		UserAgent: c.Get("User-Agent"),
+		URL:       claims.Target,
+		Extra:     map[string]interface{}{"type_ads": claims.Source},
+	}
+	if reason != "" {
+		bf.stats.countChallenge(claims.Source, challengeFailed)
+		entry.Type = "challenge_failed"
+		entry.Extra["reason"] = reason
+		utils.LogInfo(entry)
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+
+	bf.stats.countChallenge(claims.Source, challengePassed)
+	entry.Type = "challenge_passed"
+	utils.LogInfo(entry)
+	c.Cookie(&fiber.Cookie{
+		Name: challengeCookie,
+		Value: utils.SignChallengePass(cfg.Secret, utils.ChallengeClaims{
+			Visitor:   claims.Visitor,
+			ExpiresAt: time.Now().Add(cfg.CookieTTL).Unix(),
+		}),
+		Path:     "/",
+		MaxAge:   int(cfg.CookieTTL / time.Second),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	target := claims.Target
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		target = "/"
+	}
+	return c.Redirect(target, fiber.StatusSeeOther)
+}
+
+// challengeSolved reports whether SHA-256(nonce ":" counter) starts with at
+// least difficulty zero bits, as the interstitial's script searches for.
+func challengeSolved(nonce, counter string, difficulty int) bool {
+	if len(counter) == 0 || len(counter) > 20 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(nonce + ":" + counter))
+	return leadingZeroBits(sum[:]) >= difficulty
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+// browserEnvFailure names the first browser-environment signal the script sent
+// that no real visitor's browser would: an automation flag, no screen or no
+// time zone. It is empty when the environment looks like a browser.
+func browserEnvFailure(c *fiber.Ctx) string {
+	width, _ := strconv.Atoi(c.FormValue("sw"))
+	height, _ := strconv.Atoi(c.FormValue("sh"))
+	switch {
+	case c.FormValue("wd") != "0":
+		return "webdriver"
+	case width <= 0 || height <= 0:
+		return "no_screen"
+	case c.FormValue("tz") == "":
+		return "no_timezone"
+	}
+	return ""
+}
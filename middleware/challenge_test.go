@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+func TestChallengeInterstitial(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	bf, err := NewBotFilter(BotFilterConfig{
+		AllowMobileOnly: true,
+		BlockThreshold:  1,
+		Rules:           []RuleConfig{{Name: "mobile_only", Weight: 0.5, Enabled: true}},
+		Challenge: ChallengeConfig{
+			Enabled:    true,
+			Threshold:  0.5,
+			Difficulty: 8,
+			Secret:     []byte("0123456789abcdef"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{Views: html.New("../views", ".html")})
+	app.Get("/", bf.Handler(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Post(ChallengePath, bf.ChallengeHandler)
+
+	const desktop = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36"
+	get := func(cookie, ua string) (int, string) {
+		req := httptest.NewRequest("GET", "/?type_ads=1", nil)
+		req.Header.Set("User-Agent", ua)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	status, page := get("", desktop)
+	m := regexp.MustCompile(`name="token" value="([^"]+)"[\s\S]*var nonce = "([0-9a-f]+)"`).FindStringSubmatch(page)
+	if status != 200 || m == nil {
+		t.Fatalf("challenge page: status %d\n%s", status, page)
+	}
+	token, nonce := m[1], m[2]
+	counter := 0
+	for !challengeSolved(nonce, strconv.Itoa(counter), 8) {
+		counter++
+	}
+
+	answer := func(form url.Values) *http.Response {
+		req := httptest.NewRequest("POST", ChallengePath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", desktop)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	browser := url.Values{"token": {token}, "counter": {strconv.Itoa(counter)}, "wd": {"0"}, "sw": {"390"}, "sh": {"844"}, "tz": {"Asia/Jakarta"}}
+	headless := url.Values{"token": {token}, "counter": {strconv.Itoa(counter)}, "wd": {"1"}, "sw": {"390"}, "sh": {"844"}, "tz": {"UTC"}}
+	if resp := answer(headless); resp.StatusCode != 403 {
+		t.Errorf("webdriver answer: status %d, want 403", resp.StatusCode)
+	}
+	if resp := answer(url.Values{"token": {token}, "counter": {""}, "wd": {"0"}, "sw": {"1"}, "sh": {"1"}, "tz": {"UTC"}}); resp.StatusCode != 403 {
+		t.Errorf("unsolved answer: status %d, want 403", resp.StatusCode)
+	}
+	resp := answer(browser)
+	if resp.StatusCode != fiber.StatusSeeOther || resp.Header.Get("Location") != "/?type_ads=1" {
+		t.Fatalf("solved answer: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	cookie, _, _ := strings.Cut(resp.Header.Get("Set-Cookie"), ";")
+	if !strings.HasPrefix(cookie, challengeCookie+"=") {
+		t.Fatalf("no pass cookie: %q", resp.Header.Get("Set-Cookie"))
+	}
+	if status, body := get(cookie, desktop); status != 200 || body != "ok" {
+		t.Errorf("with pass cookie: %d %q", status, body)
+	}
+	// The pass is bound to the visitor it was issued to: replayed from another
+	// browser it is ignored and the challenge served again.
+	if status, body := get(cookie, desktop+" Edg/140.0.0.0"); status != 200 || !strings.Contains(body, `name="token"`) {
+		t.Errorf("replayed pass cookie: %d %q", status, body)
+	}
+
+	want := ChallengeCounts{Issued: 2, Passed: 1, Failed: 2}
+	if got := bf.Stats().Challenges["1"]; got != want {
+		t.Errorf("challenge stats %+v, want %+v", got, want)
+	}
+	var failures []interface{}
+	utils.ForEachLogEntry(func(e utils.LogEntry) {
+		if e.Type == "challenge_failed" {
+			failures = append(failures, e.Extra["reason"])
+		}
+	})
+	if len(failures) != 2 || failures[0] != "webdriver" || failures[1] != "proof_of_work" {
+		t.Errorf("challenge_failed reasons: %v", failures)
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"go-redirect/ipset"
+	"go-redirect/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const clientIPKey = "client_ip"
+
+// IPResolver finds the visitor's address behind the proxies in proxy.trusted_cidrs.
+// Forwarding headers are only believed when the connecting peer is trusted.
+type IPResolver struct {
+	trusted *ipset.Set
+	headers []string
+}
+
+var ipResolver atomic.Pointer[IPResolver]
+
+// NewIPResolver compiles the proxy config.
+func NewIPResolver(p models.Proxy) (*IPResolver, error) {
+	r := &IPResolver{trusted: &ipset.Set{}, headers: p.ClientIPHeaders}
+	if err := r.trusted.AddEntries(p.TrustedCIDRs); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetIPResolver replaces the resolver used by ResolveClientIP and ClientIP; set on
+// every config load. Until then no proxy is trusted.
+func SetIPResolver(r *IPResolver) {
+	ipResolver.Store(r)
+}
+
+// Resolve returns the client address of c: the peer itself unless it is a trusted
+// proxy; otherwise the first configured client IP header, then the rightmost
+// X-Forwarded-For entry that is not a trusted proxy.
+func (r *IPResolver) Resolve(c *fiber.Ctx) string {
+	peer, ok := parseHop(c.IP())
+	if !ok {
+		return c.IP()
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+	for _, h := range r.headers {
+		if ip, ok := parseHop(c.Get(h)); ok {
+			return ip.String()
+		}
+	}
+
+	// Every proxy appends the address it received the request from, so only the
+	// entries right of the last untrusted hop were written by our proxies.
+	var chain []string
+	for _, v := range c.Request().Header.PeekAll(fiber.HeaderXForwardedFor) {
+		chain = append(chain, strings.Split(string(v), ",")...)
+	}
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip, ok := parseHop(chain[i])
+		if !ok {
+			break
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func (r *IPResolver) isTrusted(ip netip.Addr) bool {
+	_, ok := r.trusted.Lookup(ip)
+	return ok
+}
+
+// parseHop reads one forwarding entry, which may carry a port.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return ip.Unmap(), true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// ResolveClientIP resolves the client address once per request and stores it for
+// ClientIP; it runs first on both apps.
+func ResolveClientIP() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ClientIP(c)
+		return c.Next()
+	}
+}
+
+// ClientIP is the request's client address, resolved on first use.
+func ClientIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals(clientIPKey).(string); ok {
+		return ip
+	}
+	r := ipResolver.Load()
+	if r == nil {
+		r = &IPResolver{}
+	}
+	ip := r.Resolve(c)
+	c.Locals(clientIPKey, ip)
+	return ip
+}
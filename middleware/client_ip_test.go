@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"go-redirect/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// trustTestPeer makes app.Test's in-process peer (0.0.0.0) a trusted proxy, so
+// tests can set the visitor IP with X-Forwarded-For.
+func trustTestPeer(t *testing.T) {
+	t.Helper()
+	r, err := NewIPResolver(models.Proxy{TrustedCIDRs: []string{"0.0.0.0/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetIPResolver(r)
+	t.Cleanup(func() { SetIPResolver(nil) })
+}
+
+func TestClientIPTrustsOnlyConfiguredProxies(t *testing.T) {
+	app := fiber.New()
+	app.Use(ResolveClientIP())
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString(ClientIP(c)) })
+	get := func(headers map[string]string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		for k, v := range headers {
+			req.Header.Add(k, v)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		return string(buf[:n])
+	}
+
+	SetIPResolver(nil)
+	if got := get(map[string]string{"X-Forwarded-For": "36.68.1.1"}); got != "0.0.0.0" {
+		t.Errorf("untrusted peer: client %q, want the peer", got)
+	}
+
+	r, err := NewIPResolver(models.Proxy{
+		TrustedCIDRs:    []string{"0.0.0.0/32", "10.0.0.0/8"},
+		ClientIPHeaders: []string{"Fly-Client-IP"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetIPResolver(r)
+	defer SetIPResolver(nil)
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"spoofed leftmost entry is skipped", map[string]string{"X-Forwarded-For": "1.2.3.4, 36.68.1.1, 10.1.1.1"}, "36.68.1.1"},
+		{"provider header wins", map[string]string{"Fly-Client-IP": "36.70.2.2", "X-Forwarded-For": "1.2.3.4"}, "36.70.2.2"},
+		{"only trusted hops", map[string]string{"X-Forwarded-For": "10.0.0.5, 10.0.0.6"}, "10.0.0.5"},
+		{"garbage stops the walk", map[string]string{"X-Forwarded-For": "36.68.1.1, junk, 10.0.0.6"}, "10.0.0.6"},
+		{"port and mapped address", map[string]string{"X-Forwarded-For": "[::ffff:36.68.1.1]:443"}, "36.68.1.1"},
+		{"no headers", nil, "0.0.0.0"},
+	} {
+		if got := get(tc.headers); got != tc.want {
+			t.Errorf("%s: client %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
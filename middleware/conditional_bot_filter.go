@@ -1,37 +1,242 @@
 package middleware
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// Global bot filter state
+// Bot filter toggle scopes. A request uses the most specific scope with an active
+// toggle: its campaign, then its route, then global; with none it is filtered.
+// Campaign scopes can only turn the filter on: the campaign is read from the
+// visitor's query string, so switching it off there would let anyone skip it.
+const (
+	ScopeGlobal         = "global"
+	scopeRoutePrefix    = "route:"
+	scopeCampaignPrefix = "campaign:"
+)
+
+// BotToggle turns the bot filter on or off for a scope, optionally only between
+// From and Until. Of the toggles active at a moment the one that took effect last
+// wins, the newer ID breaking ties, so a temporary toggle lapses back to whatever
+// was in effect before it and a scheduled toggle applies once its From arrives.
+type BotToggle struct {
+	ID      int       `json:"id"`
+	Scope   string    `json:"scope"`
+	Enabled bool      `json:"enabled"`
+	From    time.Time `json:"from,omitempty"`
+	Until   time.Time `json:"until,omitempty"`
+	SetBy   string    `json:"set_by,omitempty"`
+	SetAt   time.Time `json:"set_at"`
+}
+
+func (t BotToggle) activeAt(now time.Time) bool {
+	return !now.Before(t.From) && (t.Until.IsZero() || now.Before(t.Until))
+}
+
+func (t BotToggle) expiredAt(now time.Time) bool {
+	return !t.Until.IsZero() && !now.Before(t.Until)
+}
+
+// ErrCampaignScopeDisable rejects a toggle that would turn the filter off for a campaign.
+var ErrCampaignScopeDisable = errors.New("campaign scopes can only enable the bot filter")
+
+// Bot filter toggle state, persisted to togglesPath on every change once
+// LoadBotFilterToggles has been called.
 var (
-	botFilterEnabled = true
-	botFilterMutex   sync.RWMutex
+	botFilterMutex sync.RWMutex
+	toggles        []BotToggle
+	nextToggleID   int
+	togglesPath    string
 )
 
-// SetBotFilterEnabled sets the global bot filter state
+// ParseBotFilterScope normalises "", "global", "route:/pre-sale" or "campaign:<id>".
+func ParseBotFilterScope(s string) (string, error) {
+	switch {
+	case s == "" || s == ScopeGlobal:
+		return ScopeGlobal, nil
+	case strings.HasPrefix(s, scopeRoutePrefix) && strings.HasPrefix(s[len(scopeRoutePrefix):], "/"):
+		return s, nil
+	case strings.HasPrefix(s, scopeCampaignPrefix) && len(s) > len(scopeCampaignPrefix):
+		return s, nil
+	}
+	return "", fmt.Errorf("scope %q must be global, route:/<path> or campaign:<id>", s)
+}
+
+// SetBotFilterEnabled sets the global bot filter state until changed again,
+// replacing any global toggles already in effect.
 func SetBotFilterEnabled(enabled bool) {
+	ScheduleBotFilter(BotToggle{Scope: ScopeGlobal, Enabled: enabled})
+}
+
+// ScheduleBotFilter adds a toggle and persists the state. A toggle without From or
+// Until replaces the scope's toggles in effect now; scheduled ones are kept.
+func ScheduleBotFilter(t BotToggle) (BotToggle, error) {
+	if strings.HasPrefix(t.Scope, scopeCampaignPrefix) && !t.Enabled {
+		return BotToggle{}, ErrCampaignScopeDisable
+	}
 	botFilterMutex.Lock()
 	defer botFilterMutex.Unlock()
-	botFilterEnabled = enabled
+	now := time.Now()
+	if t.SetAt.IsZero() {
+		t.SetAt = now
+	}
+	if t.From.IsZero() {
+		t.From = t.SetAt
+	}
+	nextToggleID++
+	t.ID = nextToggleID
+
+	kept := toggles[:0]
+	for _, old := range toggles {
+		if old.expiredAt(now) {
+			continue
+		}
+		if old.Scope == t.Scope && t.Until.IsZero() && !t.From.After(now) && !old.From.After(now) {
+			continue
+		}
+		kept = append(kept, old)
+	}
+	toggles = append(kept, t)
+	return t, saveToggles()
 }
 
-// IsBotFilterEnabled returns the current bot filter state
+// CancelBotFilterToggle removes a toggle, ending it early or unscheduling it.
+func CancelBotFilterToggle(id int) (BotToggle, bool, error) {
+	botFilterMutex.Lock()
+	defer botFilterMutex.Unlock()
+	for i, t := range toggles {
+		if t.ID == id {
+			toggles = append(toggles[:i:i], toggles[i+1:]...)
+			return t, true, saveToggles()
+		}
+	}
+	return BotToggle{}, false, nil
+}
+
+// IsBotFilterEnabled returns the current global bot filter state
 func IsBotFilterEnabled() bool {
+	return BotFilterEnabledFor(ScopeGlobal)
+}
+
+// BotFilterEnabledFor returns whether the bot filter is on for the first of
+// scopes, most specific first, that has an active toggle. A campaign toggle that
+// turns the filter off, saved before they were refused, is ignored.
+func BotFilterEnabledFor(scopes ...string) bool {
 	botFilterMutex.RLock()
 	defer botFilterMutex.RUnlock()
-	return botFilterEnabled
+	now := time.Now()
+	for _, scope := range scopes {
+		if t, ok := activeToggle(scope, now); ok && (t.Enabled || !strings.HasPrefix(scope, scopeCampaignPrefix)) {
+			return t.Enabled
+		}
+	}
+	if t, ok := activeToggle(ScopeGlobal, now); ok {
+		return t.Enabled
+	}
+	return true
+}
+
+func activeToggle(scope string, now time.Time) (BotToggle, bool) {
+	var best BotToggle
+	found := false
+	for _, t := range toggles {
+		if t.Scope != scope || !t.activeAt(now) {
+			continue
+		}
+		if !found || t.From.After(best.From) || (t.From.Equal(best.From) && t.ID > best.ID) {
+			best, found = t, true
+		}
+	}
+	return best, found
 }
 
-// ConditionalBotFilter applies bot filter only when enabled
+// BotFilterToggles returns the toggles in effect now and those scheduled for later,
+// ordered by ID. Expired toggles are left out.
+func BotFilterToggles() (active, scheduled []BotToggle) {
+	botFilterMutex.RLock()
+	defer botFilterMutex.RUnlock()
+	now := time.Now()
+	active, scheduled = []BotToggle{}, []BotToggle{}
+	for _, t := range toggles {
+		switch {
+		case t.expiredAt(now):
+		case t.From.After(now):
+			scheduled = append(scheduled, t)
+		default:
+			active = append(active, t)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].ID < active[j].ID })
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].ID < scheduled[j].ID })
+	return active, scheduled
+}
+
+// LoadBotFilterToggles restores toggles from path and persists every later change
+// there. A missing file leaves the filter on everywhere.
+func LoadBotFilterToggles(path string) error {
+	botFilterMutex.Lock()
+	defer botFilterMutex.Unlock()
+	togglesPath = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []BotToggle
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	now := time.Now()
+	toggles = toggles[:0]
+	for _, t := range saved {
+		if t.ID > nextToggleID {
+			nextToggleID = t.ID
+		}
+		if !t.expiredAt(now) {
+			toggles = append(toggles, t)
+		}
+	}
+	return nil
+}
+
+// saveToggles writes the toggles through a temp file and rename. Callers hold
+// botFilterMutex.
+func saveToggles() error {
+	if togglesPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(toggles, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := togglesPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, togglesPath)
+}
+
+// ConditionalBotFilter applies the bot filter unless it is toggled off for the
+// request's campaign, route or globally.
 func ConditionalBotFilter(botFilter *botFilter) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Check if bot filter is enabled
-		if !IsBotFilterEnabled() {
-			// Bot filter disabled, skip to next handler
+		scopes := []string{scopeRoutePrefix + c.Path()}
+		if id := c.Query("campaign"); id != "" {
+			scopes = append([]string{scopeCampaignPrefix + id}, scopes...)
+		}
+		if !BotFilterEnabledFor(scopes...) {
+			// Bot filter disabled, skip to next handler; a bypass token is dropped unread.
+			takeBypass(c)
 			return c.Next()
 		}
 
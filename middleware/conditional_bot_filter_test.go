@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBotFilterTogglesScopeScheduleAndPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "toggles.json")
+	toggles, togglesPath = nil, ""
+	if err := LoadBotFilterToggles(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { toggles, togglesPath = nil, "" })
+
+	if !BotFilterEnabledFor("campaign:a", "route:/") {
+		t.Fatal("filter should default to on")
+	}
+
+	// Campaign beats route beats global.
+	SetBotFilterEnabled(false)
+	ScheduleBotFilter(BotToggle{Scope: "route:/pre-sale", Enabled: true})
+	ScheduleBotFilter(BotToggle{Scope: "campaign:a", Enabled: true})
+	if BotFilterEnabledFor("route:/") || !BotFilterEnabledFor("route:/pre-sale") || !BotFilterEnabledFor("campaign:a", "route:/") {
+		t.Fatal("scope precedence wrong")
+	}
+
+	// The campaign comes from the query string, so it cannot switch the filter off.
+	if _, err := ScheduleBotFilter(BotToggle{Scope: "campaign:b", Enabled: false}); !errors.Is(err, ErrCampaignScopeDisable) {
+		t.Fatalf("campaign disable accepted: %v", err)
+	}
+	toggles = append(toggles, BotToggle{ID: 99, Scope: "campaign:legacy", Enabled: false})
+	if !BotFilterEnabledFor("campaign:legacy", "route:/pre-sale") {
+		t.Fatal("saved campaign disable still applies")
+	}
+	toggles = toggles[:len(toggles)-1]
+
+	// A temporary toggle lapses back to the permanent one underneath it.
+	now := time.Now()
+	tmp, _ := ScheduleBotFilter(BotToggle{Scope: "route:/pre-sale", Enabled: false, SetAt: now.Add(-time.Hour), Until: now.Add(-time.Minute)})
+	if !BotFilterEnabledFor("route:/pre-sale") {
+		t.Fatal("expired temporary toggle still applies")
+	}
+	ScheduleBotFilter(BotToggle{Scope: "route:/pre-sale", Enabled: false, Until: now.Add(30 * time.Minute)})
+	if BotFilterEnabledFor("route:/pre-sale") {
+		t.Fatal("temporary disable not applied")
+	}
+	later, _ := ScheduleBotFilter(BotToggle{Scope: ScopeGlobal, Enabled: true, From: now.Add(time.Hour)})
+	if IsBotFilterEnabled() {
+		t.Fatal("scheduled toggle applied early")
+	}
+
+	// Everything but the expired toggle survives a restart.
+	toggles, nextToggleID = nil, 0
+	if err := LoadBotFilterToggles(path); err != nil {
+		t.Fatal(err)
+	}
+	active, scheduled := BotFilterToggles()
+	if len(active) != 4 || len(scheduled) != 1 || scheduled[0].ID != later.ID {
+		t.Fatalf("after reload: active %+v scheduled %+v", active, scheduled)
+	}
+	for _, a := range active {
+		if a.ID == tmp.ID {
+			t.Fatal("expired toggle persisted")
+		}
+	}
+	if BotFilterEnabledFor("route:/pre-sale") || IsBotFilterEnabled() {
+		t.Fatal("state not restored")
+	}
+	if _, ok, _ := CancelBotFilterToggle(later.ID); !ok {
+		t.Fatal("cancel scheduled toggle")
+	}
+	if next, _ := ScheduleBotFilter(BotToggle{Scope: ScopeGlobal, Enabled: true}); next.ID <= later.ID {
+		t.Fatalf("toggle ids reused after restart: %d", next.ID)
+	}
+}
+
+func TestBotFilterScheduledToggleBeatsEarlierOverride(t *testing.T) {
+	toggles, togglesPath = nil, ""
+	t.Cleanup(func() { toggles, togglesPath = nil, "" })
+
+	// At 10:00 the filter is scheduled back on for 11:00; at 10:30 it is turned
+	// off until further notice. From 11:00 the scheduled toggle applies.
+	now := time.Now()
+	enable, _ := ScheduleBotFilter(BotToggle{Scope: ScopeGlobal, Enabled: true, SetAt: now.Add(-time.Hour), From: now.Add(30 * time.Minute)})
+	ScheduleBotFilter(BotToggle{Scope: ScopeGlobal, Enabled: false})
+	if IsBotFilterEnabled() {
+		t.Fatal("override not applied")
+	}
+	if got, ok := activeToggle(ScopeGlobal, now.Add(time.Hour)); !ok || got.ID != enable.ID {
+		t.Fatalf("expected scheduled toggle %d after its start, got %+v", enable.ID, got)
+	}
+}
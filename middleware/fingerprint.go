@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-redirect/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FingerprintConfig is bot_filter.fingerprint. Anomalies maps each detected
+// anomaly to its score; missing ones use models.FingerprintAnomalies.
+type FingerprintConfig struct {
+	Enabled   bool
+	Anomalies map[string]float64
+	MaxUAAge  time.Duration
+}
+
+const defaultMaxUAAge = 730 * 24 * time.Hour
+
+// fingerprintRule scores how far the request's headers are from what the browser
+// its User-Agent names would send.
+type fingerprintRule struct {
+	cfg FingerprintConfig
+	now func() time.Time
+}
+
+func newFingerprintRule(cfg BotFilterConfig, rc RuleConfig) (Rule, error) {
+	f := cfg.Fingerprint
+	if f.MaxUAAge <= 0 {
+		f.MaxUAAge = defaultMaxUAAge
+	}
+	return fingerprintRule{cfg: f, now: time.Now}, nil
+}
+
+func (r fingerprintRule) Score(in *RuleInput) RuleResult {
+	if !r.cfg.Enabled {
+		return RuleResult{}
+	}
+	found := map[string]string{}
+	h := &in.Ctx.Request().Header
+	mobile := isMobileUA(in.UA)
+
+	if len(h.Peek("Accept-Language")) == 0 {
+		found["missing_accept_language"] = ""
+	}
+	if v := string(h.Peek("Sec-CH-UA-Mobile")); (v == "?1" && !mobile) || (v == "?0" && mobile) {
+		found["ch_mobile_mismatch"] = v
+	}
+	if p := strings.Trim(strings.ToLower(string(h.Peek("Sec-CH-UA-Platform"))), `"`); mobile && desktopPlatforms[p] {
+		found["desktop_platform_on_mobile"] = p
+	}
+	if pair := impossibleHeaderOrder(headerOrder(in.Ctx), in.UA); pair != "" {
+		found["header_order"] = pair
+	}
+	if browser, age, ok := uaAge(in.UA, r.now()); ok && age > r.cfg.MaxUAAge {
+		found["outdated_ua"] = browser
+	}
+
+	score := 0.0
+	var anomalies []string
+	for name := range found {
+		s := anomalyScore(r.cfg.Anomalies, name)
+		if s <= 0 {
+			continue
+		}
+		score += s
+		anomalies = append(anomalies, name)
+	}
+	if len(anomalies) == 0 {
+		return RuleResult{}
+	}
+	sort.Strings(anomalies)
+	details := map[string]interface{}{"fingerprint_anomalies": anomalies}
+	for _, name := range anomalies {
+		if v := found[name]; v != "" {
+			details["fingerprint_"+name] = v
+		}
+	}
+	if score > 1 {
+		score = 1
+	}
+	return RuleResult{Score: score, Reason: "fingerprint_mismatch", Details: details}
+}
+
+func anomalyScore(scores map[string]float64, name string) float64 {
+	if s, ok := scores[name]; ok {
+		return s
+	}
+	return models.FingerprintAnomalies[name]
+}
+
+var desktopPlatforms = map[string]bool{"windows": true, "macos": true, "linux": true, "chrome os": true}
+
+// headerOrder lists the request's header names, lower-cased, in the order they
+// arrived. It is empty when the raw headers are not available.
+func headerOrder(c *fiber.Ctx) []string {
+	var names []string
+	for _, line := range strings.Split(string(c.Request().Header.RawHeaders()), "\n") {
+		if name, _, ok := strings.Cut(line, ":"); ok {
+			names = append(names, strings.ToLower(strings.TrimSpace(name)))
+		}
+	}
+	return names
+}
+
+// impossibleHeaderOrder returns the first pair of headers sent in an order the
+// browser in ua does not use, as "<later>_before_<earlier>". Every engine sends
+// Accept before Accept-Language. Chromium and Firefox send User-Agent before
+// Accept, and Chromium its client hints before User-Agent; WebKit, so Safari and
+// every iOS browser, sends Accept first and User-Agent late.
+func impossibleHeaderOrder(order []string, ua string) string {
+	pos := map[string]int{}
+	for i, name := range order {
+		if _, seen := pos[name]; !seen {
+			pos[name] = i
+		}
+	}
+	pairs := [][2]string{{"accept", "accept-language"}}
+	if strings.Contains(ua, "chrome/") || strings.Contains(ua, "firefox/") {
+		pairs = append(pairs, [2]string{"user-agent", "accept"})
+	}
+	if strings.Contains(ua, "chrome/") {
+		pairs = append(pairs, [2]string{"sec-ch-ua", "user-agent"})
+	}
+	for _, p := range pairs {
+		first, ok1 := pos[p[0]]
+		second, ok2 := pos[p[1]]
+		if ok1 && ok2 && second < first {
+			return p[1] + "_before_" + p[0]
+		}
+	}
+	return ""
+}
+
+// Release cadences used to date a browser version: Chrome and Firefox ship a
+// major version every four weeks, Safari once a year.
+var uaReleases = []struct {
+	name    string
+	re      *regexp.Regexp
+	version int
+	date    time.Time
+	every   time.Duration
+}{
+	{"chrome", regexp.MustCompile(`chrome/(\d+)`), 120, time.Date(2023, 12, 5, 0, 0, 0, 0, time.UTC), 28 * 24 * time.Hour},
+	{"firefox", regexp.MustCompile(`firefox/(\d+)`), 121, time.Date(2023, 12, 19, 0, 0, 0, 0, time.UTC), 28 * 24 * time.Hour},
+	{"safari", regexp.MustCompile(`version/(\d+)[\d.]* (?:mobile/\S+ )?safari/`), 17, time.Date(2023, 9, 18, 0, 0, 0, 0, time.UTC), 365 * 24 * time.Hour},
+}
+
+// uaAge estimates how long ago the browser version in ua was released.
+func uaAge(ua string, now time.Time) (browser string, age time.Duration, ok bool) {
+	for _, r := range uaReleases {
+		m := r.re.FindStringSubmatch(ua)
+		if m == nil {
+			continue
+		}
+		v, err := strconv.Atoi(m[1])
+		if err != nil {
+			return "", 0, false
+		}
+		released := r.date.Add(time.Duration(v-r.version) * r.every)
+		return r.name + "/" + m[1], now.Sub(released), true
+	}
+	return "", 0, false
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFingerprintRuleAnomalies(t *testing.T) {
+	rule, _ := newFingerprintRule(BotFilterConfig{Fingerprint: FingerprintConfig{
+		Enabled:   true,
+		Anomalies: map[string]float64{"header_order": 0},
+	}}, RuleConfig{})
+	fr := rule.(fingerprintRule)
+	fr.now = func() time.Time { return time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC) }
+
+	var res RuleResult
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		res = fr.Score(&RuleInput{Ctx: c, UA: strings.ToLower(c.Get("User-Agent"))})
+		return nil
+	})
+	score := func(headers map[string]string) RuleResult {
+		req := httptest.NewRequest("GET", "/", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	const pixel = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Mobile Safari/537.36"
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		want    []string
+	}{
+		{"consistent mobile Chrome", map[string]string{"User-Agent": pixel, "Sec-CH-UA-Mobile": "?1", "Sec-CH-UA-Platform": `"Android"`, "Accept-Language": "id-ID"}, nil},
+		{"no Accept-Language", map[string]string{"User-Agent": pixel}, []string{"missing_accept_language"}},
+		{"client hints say desktop", map[string]string{"User-Agent": pixel, "Sec-CH-UA-Mobile": "?0", "Sec-CH-UA-Platform": `"Windows"`, "Accept-Language": "en"}, []string{"ch_mobile_mismatch", "desktop_platform_on_mobile"}},
+		{"years-old Chrome", map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 10) Chrome/96.0.4664.45 Mobile Safari/537.36", "Accept-Language": "id"}, []string{"outdated_ua"}},
+		// app.Test sends headers sorted by name, Accept before User-Agent, which
+		// Chrome does not do; header_order is scored 0 above.
+		{"score 0 turns an anomaly off", map[string]string{"User-Agent": pixel, "Accept": "*/*", "Accept-Language": "id"}, nil},
+	} {
+		res := score(tc.headers)
+		got, _ := res.Details["fingerprint_anomalies"].([]string)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: anomalies %v, want %v", tc.name, got, tc.want)
+		}
+		if len(tc.want) > 0 && (res.Reason != "fingerprint_mismatch" || res.Score <= 0 || res.Score > 1) {
+			t.Errorf("%s: result %+v", tc.name, res)
+		}
+	}
+
+	if got := impossibleHeaderOrder([]string{"host", "accept", "user-agent"}, "firefox/140"); got != "accept_before_user-agent" {
+		t.Errorf("impossibleHeaderOrder = %q", got)
+	}
+	if got := impossibleHeaderOrder([]string{"host", "user-agent", "sec-ch-ua", "accept"}, strings.ToLower(pixel)); got != "user-agent_before_sec-ch-ua" {
+		t.Errorf("impossibleHeaderOrder for Chrome = %q", got)
+	}
+
+	// Header order of a page load by Safari on iOS 17.
+	iphone := "mozilla/5.0 (iphone; cpu iphone os 17_5 like mac os x) applewebkit/605.1.15 (khtml, like gecko) version/17.5 mobile/15e148 safari/604.1"
+	safari := []string{"host", "accept", "sec-fetch-site", "cookie", "sec-fetch-dest", "accept-language", "sec-fetch-mode", "user-agent", "accept-encoding", "connection"}
+	if got := impossibleHeaderOrder(safari, iphone); got != "" {
+		t.Errorf("impossibleHeaderOrder for Safari = %q", got)
+	}
+	if got := impossibleHeaderOrder(safari, strings.Replace(iphone, "version/17.5", "crios/140.0.0.0", 1)); got != "" {
+		t.Errorf("impossibleHeaderOrder for Chrome on iOS = %q", got)
+	}
+}
@@ -2,27 +2,32 @@ package middleware
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// ConsoleOutput receives RequestLogger's console lines; offline commands discard them.
+var ConsoleOutput io.Writer = os.Stdout
+
 // RequestLogger creates a simple console logger middleware for monitoring requests
 func RequestLogger() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
 		method := c.Method()
 		path := c.Path()
-		ip := c.IP()
+		ip := ClientIP(c)
 		userAgent := c.Get("User-Agent")
 		referer := c.Get("Referer")
 
 		// Log request start with referer
 		if referer != "" {
-			fmt.Printf("[%s] %s %s from %s | Ref: %.30s | UA: %.40s\n",
+			fmt.Fprintf(ConsoleOutput, "[%s] %s %s from %s | Ref: %.30s | UA: %.40s\n",
 				start.Format("15:04:05"), method, path, ip, referer, userAgent)
 		} else {
-			fmt.Printf("[%s] %s %s from %s | Direct | UA: %.50s\n",
+			fmt.Fprintf(ConsoleOutput, "[%s] %s %s from %s | Direct | UA: %.50s\n",
 				start.Format("15:04:05"), method, path, ip, userAgent)
 		}
 
@@ -33,7 +38,7 @@ func RequestLogger() fiber.Handler {
 		duration := time.Since(start)
 		status := c.Response().StatusCode()
 
-		fmt.Printf("[%s] %s %s -> %d (%v)\n",
+		fmt.Fprintf(ConsoleOutput, "[%s] %s %s -> %d (%v)\n",
 			time.Now().Format("15:04:05"), method, path, status, duration)
 
 		return err
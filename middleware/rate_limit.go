@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"go-redirect/ratelimit"
+)
+
+// defaultRateLimitWindow applies when rate_limit_window_sec is unset.
+const defaultRateLimitWindow = 10 * time.Second
+
+// RateLimitConfig caps requests per Key ("ip", "subnet", "ip_ua" or "spot_id")
+// in a sliding Window. Max 0 disables it.
+type RateLimitConfig struct {
+	Key    string
+	Max    int
+	Window time.Duration
+}
+
+// RateLimitHit is the limit a request exceeded, as logged with rate_limit_exceeded.
+type RateLimitHit struct {
+	Key    string
+	Hits   int
+	Max    int
+	Window time.Duration
+}
+
+// rateLimits resolves the limits in effect: the per-IP rate_limit_max first, then
+// rate_limits, where an entry for "ip" replaces rate_limit_max.
+func rateLimits(cfg BotFilterConfig) []RateLimitConfig {
+	window := time.Duration(cfg.RateLimitWindowSec) * time.Second
+	if window <= 0 {
+		window = defaultRateLimitWindow
+	}
+	limits := []RateLimitConfig{{Key: "ip", Max: cfg.RateLimitMax, Window: window}}
+	return mergeRateLimits(limits, cfg.RateLimits)
+}
+
+// mergeRateLimits replaces the limits in base with the same key as an override
+// and appends the rest, leaving out disabled ones.
+func mergeRateLimits(base, overrides []RateLimitConfig) []RateLimitConfig {
+	var out []RateLimitConfig
+	for _, l := range base {
+		for _, o := range overrides {
+			if o.Key == l.Key {
+				l = o
+			}
+		}
+		out = append(out, l)
+	}
+	for _, o := range overrides {
+		found := false
+		for _, l := range base {
+			found = found || l.Key == o.Key
+		}
+		if !found {
+			out = append(out, o)
+		}
+	}
+	enabled := out[:0]
+	for _, l := range out {
+		if l.Max > 0 && l.Window > 0 {
+			enabled = append(enabled, l)
+		}
+	}
+	return enabled
+}
+
+// checkRateLimits counts the request against every limit of its campaign (the
+// global ones when the campaign sets none) and returns the first one exceeded.
+func (bf *botFilter) checkRateLimits(in *RuleInput, rules *botRules) *RateLimitHit {
+	campaign := in.Ctx.Query("campaign")
+	limits, ok := rules.campaignLimits[campaign]
+	if !ok {
+		limits, campaign = rules.limits, ""
+	}
+	limiter := bf.limiter.Load()
+	now := time.Now()
+	var exceeded *RateLimitHit
+	for _, l := range limits {
+		value := rateLimitValue(l.Key, in)
+		if value == "" {
+			continue
+		}
+		hits := limiter.Hit(campaign+"|"+l.Key+"|"+value, l.Window, now)
+		if hits > l.Max && exceeded == nil {
+			exceeded = &RateLimitHit{Key: l.Key, Hits: hits, Max: l.Max, Window: l.Window}
+		}
+	}
+	return exceeded
+}
+
+// rateLimitValue is what the request is counted by under key; "" skips the limit.
+func rateLimitValue(key string, in *RuleInput) string {
+	switch key {
+	case "ip":
+		return in.IP
+	case "subnet":
+		return subnetOf(in.IP)
+	case "ip_ua":
+		// Hash the UA so long headers do not inflate the key.
+		h := fnv.New64a()
+		h.Write([]byte(in.UA))
+		return in.IP + "|" + strconv.FormatUint(h.Sum64(), 36)
+	case "spot_id":
+		return in.Ctx.Query("spot_id")
+	}
+	return ""
+}
+
+// subnetOf is the /24 (IPv4) or /64 (IPv6) around ip, or ip if it does not parse.
+func subnetOf(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := 64
+	if addr.Is4() {
+		bits = 24
+	}
+	p, _ := addr.Prefix(bits)
+	return p.String()
+}
+
+func maxKeys(cfg BotFilterConfig) int {
+	if cfg.RateLimitMaxKeys <= 0 {
+		return ratelimit.DefaultMaxKeys
+	}
+	return cfg.RateLimitMaxKeys
+}
+
+// newLimiter carries the counters over when rate_limit_max_keys changes.
+func newLimiter(prev *ratelimit.Limiter, maxKeys int) *ratelimit.Limiter {
+	l := ratelimit.New(maxKeys)
+	if prev != nil {
+		l.Restore(prev.Snapshot(time.Now()))
+	}
+	return l
+}
@@ -1,11 +1,106 @@
 package models
 
+import "time"
+
 type Config struct {
-	Propeller Propeller `yaml:"propeller"`
-	Galaksion Galaksion `yaml:"galaksion"`
-	Popcash   Popcash   `yaml:"popcash"`
-	BotFilter BotFilter `yaml:"bot_filter"`
-	Products  []Product `yaml:"products"`
+	Propeller   Propeller         `yaml:"propeller"`
+	Galaksion   Galaksion         `yaml:"galaksion"`
+	Popcash     Popcash           `yaml:"popcash"`
+	ClickAdilla ClickAdilla       `yaml:"clickadilla"`
+	BotFilter   BotFilter         `yaml:"bot_filter"`
+	PreSale     PreSale           `yaml:"pre_sale"`
+	Passthrough PassthroughPolicy `yaml:"passthrough"`
+	Campaigns   []Campaign        `yaml:"campaigns"`
+	ArticlesDir string            `yaml:"articles_dir"`
+	Admin       Admin             `yaml:"admin"`
+	Proxy       Proxy             `yaml:"proxy"`
+	// ASNCategories names groups of autonomous systems, e.g. hosting or
+	// mobile_id, for the bot filter's asn rule and product targeting.
+	ASNCategories map[string][]uint `yaml:"asn_categories"`
+	Products      []Product         `yaml:"products"`
+}
+
+// Proxy tells the client IP resolver which peers may report the visitor's address.
+// Requests from any other peer are attributed to the peer itself.
+type Proxy struct {
+	TrustedCIDRs []string `yaml:"trusted_cidrs"`
+	// ClientIPHeaders hold a single address set by a trusted proxy, e.g.
+	// Fly-Client-IP or CF-Connecting-IP; they are tried in order before
+	// X-Forwarded-For.
+	ClientIPHeaders []string `yaml:"client_ip_headers"`
+}
+
+// Admin configures the separate listener for the dashboard, logs and other
+// operational endpoints. An empty Listen disables it; with no Users or APIKeys
+// every admin request is refused.
+type Admin struct {
+	Listen  string        `yaml:"listen"`
+	Users   []AdminUser   `yaml:"users"`
+	APIKeys []AdminAPIKey `yaml:"api_keys"`
+}
+
+// AdminUser logs in with HTTP Basic auth. PasswordHash is a bcrypt hash
+// (`go-redirect hash-password`).
+type AdminUser struct {
+	Name         string `yaml:"name"`
+	PasswordHash string `yaml:"password_hash"`
+	Role         string `yaml:"role"`
+}
+
+// AdminAPIKey authenticates scripts with `Authorization: Bearer <key>` or
+// `X-API-Key`. Only the hex SHA-256 of the key is stored (`go-redirect new-api-key`).
+type AdminAPIKey struct {
+	Name      string `yaml:"name"`
+	KeySHA256 string `yaml:"key_sha256"`
+	Role      string `yaml:"role"`
+}
+
+// Admin roles. An operator can do everything a viewer can.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+)
+
+// Campaign groups per-campaign overrides. A request selects its campaign
+// with the `campaign` query param; unknown or missing ids use the globals.
+type Campaign struct {
+	ID          string             `yaml:"id"`
+	Passthrough *PassthroughPolicy `yaml:"passthrough"`
+	PreSale     *PreSaleExperiment `yaml:"pre_sale"`
+	// RateLimits replace the bot filter's limits on the same keys for this
+	// campaign's traffic, with counters of their own.
+	RateLimits []RateLimit `yaml:"rate_limits"`
+}
+
+// RateLimit caps requests per key in a sliding window of WindowSec seconds. Max
+// 0 disables the limit.
+type RateLimit struct {
+	Key       string `yaml:"key"`
+	Max       int    `yaml:"max"`
+	WindowSec int    `yaml:"window_sec"`
+}
+
+// RateLimitKeys are what a RateLimit can count by: the client IP, its /24 (IPv4)
+// or /64 (IPv6) subnet, the IP and User-Agent together, or the spot_id param.
+var RateLimitKeys = []string{"ip", "subnet", "ip_ua", "spot_id"}
+
+// PassthroughPolicy controls which incoming query params are forwarded to
+// the merchant URL. Mode "allow" forwards only Params, mode "deny" (the
+// default) forwards everything except Params. Internal keys (bypass, from,
+// redirect, auto, expires, product, campaign, handoff, article) are never forwarded.
+type PassthroughPolicy struct {
+	Mode      string            `yaml:"mode"`
+	Params    []string          `yaml:"params"`
+	Rename    map[string]string `yaml:"rename"`
+	Transform []ParamTransform  `yaml:"transform"`
+}
+
+// ParamTransform rewrites the value of an outgoing param (after renaming).
+// Supported ops: lower, upper, trim, prefix, suffix, truncate, default, sha256.
+type ParamTransform struct {
+	Param string `yaml:"param"`
+	Op    string `yaml:"op"`
+	Value string `yaml:"value"`
 }
 
 type Propeller struct {
@@ -30,15 +125,175 @@ type ClickAdilla struct {
 	PostbackURL string `yaml:"postback_url"`
 }
 
+// PreSale configures the signed hand-off from the pre-sale page to the redirect.
+// An empty HandoffSecret turns signing off: hand-offs are logged as unsigned and
+// RejectInvalid, which needs the secret, cannot be set.
+type PreSale struct {
+	HandoffSecret string            `yaml:"handoff_secret"`
+	HandoffTTLSec int               `yaml:"handoff_ttl_sec"`
+	RejectInvalid bool              `yaml:"reject_invalid"`
+	Experiment    PreSaleExperiment `yaml:"experiment"`
+}
+
+// PreSaleExperiment splits pre-sale traffic between template variants by weight.
+// Setting Winner promotes that variant to 100% of traffic.
+type PreSaleExperiment struct {
+	Variants []PreSaleVariant `yaml:"variants"`
+	Winner   string           `yaml:"winner"`
+}
+
+// PreSaleVariant is a named pre-sale layout. Template is a file in views/ without
+// extension; zero CountdownSec/AutoRedirectSec use the page defaults and a
+// negative AutoRedirectSec disables the auto-redirect.
+type PreSaleVariant struct {
+	Name            string  `yaml:"name"`
+	Template        string  `yaml:"template"`
+	Weight          float64 `yaml:"weight"`
+	CountdownSec    int     `yaml:"countdown_sec"`
+	AutoRedirectSec int     `yaml:"auto_redirect_sec"`
+}
+
 type BotFilter struct {
-	AllowCountries     []string `yaml:"allow_countries"`
-	AllowMobileOnly    bool     `yaml:"allow_mobile_only"`
-	RateLimitMax       int      `yaml:"rate_limit_max"`
-	RateLimitWindowSec int      `yaml:"rate_limit_window_sec"`
-	LogAllowed         bool     `yaml:"log_allowed"`
-	LogBlocked         bool     `yaml:"log_blocked"`
-	BlacklistUA        []string `yaml:"blacklist_ua"`
-	BlacklistIPPrefix  []string `yaml:"blacklist_ip_prefix"`
-	BlacklistReferrer  []string `yaml:"blacklist_referrer"`
-	BlacklistRefRegex  []string `yaml:"blacklist_ref_regex"`
+	AllowCountries  []string `yaml:"allow_countries"`
+	AllowMobileOnly bool     `yaml:"allow_mobile_only"`
+	// RateLimitMax requests per IP in RateLimitWindowSec (default 10); 0 disables it.
+	RateLimitMax       int `yaml:"rate_limit_max"`
+	RateLimitWindowSec int `yaml:"rate_limit_window_sec"`
+	// RateLimits adds limits on other keys; RateLimitMaxKeys caps the counters
+	// kept in memory (default 100000), evicting the least recently seen.
+	RateLimits       []RateLimit `yaml:"rate_limits"`
+	RateLimitMaxKeys int         `yaml:"rate_limit_max_keys"`
+	LogAllowed       bool        `yaml:"log_allowed"`
+	LogBlocked       bool        `yaml:"log_blocked"`
+	BlacklistUA      []string    `yaml:"blacklist_ua"`
+	// BlacklistIPPrefix takes CIDRs ("104.28.0.0/20", "2001:db8::/32"), single IPs
+	// and legacy octet prefixes ending in a dot ("66.249.").
+	BlacklistIPPrefix []string `yaml:"blacklist_ip_prefix"`
+	// IPBlocklistFiles are loaded into the ip_prefix rule next to BlacklistIPPrefix:
+	// one entry per line, or a cloud provider's JSON range dump.
+	IPBlocklistFiles []string `yaml:"ip_blocklist_files"`
+	// IPAllowlist and IPAllowlistFiles name addresses that skip every rule.
+	IPAllowlist       []string `yaml:"ip_allowlist"`
+	IPAllowlistFiles  []string `yaml:"ip_allowlist_files"`
+	BlacklistReferrer []string `yaml:"blacklist_referrer"`
+	BlacklistRefRegex []string `yaml:"blacklist_ref_regex"`
+	// BlockThreshold is the total rule score at which a request is blocked (default 1).
+	BlockThreshold float64 `yaml:"block_threshold"`
+	// Rules sets the order, weight and enabled flag of the scoring rules. Rules not
+	// listed run afterwards in BotRuleNames order with weight 1.
+	Rules []BotRule `yaml:"rules"`
+	// Shadow runs the whole filter in dry-run: would-be blocks are logged as
+	// would_block and let through.
+	Shadow bool `yaml:"shadow"`
+	// BlockASNs and BlockASNCategories feed the asn rule; AllowASNs exempts
+	// networks from it, e.g. one cloud ASN used by a partner.
+	BlockASNs          []uint   `yaml:"block_asns"`
+	BlockASNCategories []string `yaml:"block_asn_categories"`
+	AllowASNs          []uint   `yaml:"allow_asns"`
+	// Bans temporarily block keys that keep getting blocked.
+	Bans BanPolicy `yaml:"bans"`
+	// Bypass lets QA devices through the filter.
+	Bypass Bypass `yaml:"bypass"`
+	// Fingerprint configures the fingerprint rule.
+	Fingerprint Fingerprint `yaml:"fingerprint"`
+	// Challenge serves a JavaScript check to requests scoring just below the
+	// block threshold.
+	Challenge Challenge `yaml:"challenge"`
+}
+
+// Challenge serves an interstitial with a JavaScript proof-of-work and browser
+// check to requests whose score reaches Threshold but not BlockThreshold. The
+// proof-of-work needs Difficulty leading zero bits (default 14) and must be
+// solved within ChallengeTTLSec (default 120); solving it sets a cookie, signed
+// with Secret, that lets the visitor through for CookieTTLSec (default 1800).
+// The page hashes with WebCrypto, which browsers only offer over HTTPS.
+type Challenge struct {
+	Enabled         bool    `yaml:"enabled"`
+	Threshold       float64 `yaml:"threshold"`
+	Difficulty      int     `yaml:"difficulty"`
+	Secret          string  `yaml:"secret"`
+	CookieTTLSec    int     `yaml:"cookie_ttl_sec"`
+	ChallengeTTLSec int     `yaml:"challenge_ttl_sec"`
 }
+
+// Fingerprint scores inconsistent request headers. Each anomaly found adds its
+// score from Anomalies (default FingerprintAnomalies' defaults; 0 turns one off)
+// and the rule scores the sum, capped at 1. MaxUAAgeDays (default 730) is how
+// old a browser version may be before outdated_ua counts.
+type Fingerprint struct {
+	Enabled      bool               `yaml:"enabled"`
+	Anomalies    map[string]float64 `yaml:"anomalies"`
+	MaxUAAgeDays int                `yaml:"max_ua_age_days"`
+}
+
+// FingerprintAnomalies are the anomalies the fingerprint rule detects, with their
+// default scores.
+var FingerprintAnomalies = map[string]float64{
+	// No Accept-Language, which every browser sends.
+	"missing_accept_language": 0.5,
+	// Sec-CH-UA-Mobile contradicts the User-Agent.
+	"ch_mobile_mismatch": 0.6,
+	// A desktop Sec-CH-UA-Platform on a mobile User-Agent.
+	"desktop_platform_on_mobile": 0.6,
+	// Headers in an order no browser sends them in.
+	"header_order": 0.3,
+	// A browser release older than MaxUAAgeDays.
+	"outdated_ua": 0.4,
+}
+
+// Bypass credentials skip the bot filter when sent as ?bypass=<token> or an
+// X-Bypass-Key header: a named key, or a link signed with LinkSecret by
+// POST /admin/bypass-links that expires after at most LinkTTLSec (default 86400).
+// An empty LinkSecret disables signed links.
+type Bypass struct {
+	Keys       []BypassKey `yaml:"keys"`
+	LinkSecret string      `yaml:"link_secret"`
+	LinkTTLSec int         `yaml:"link_ttl_sec"`
+}
+
+// BypassKey is a named bypass token. Only the hex SHA-256 of the token is stored
+// (go-redirect new-api-key); a zero Expires never expires.
+type BypassKey struct {
+	Name      string    `yaml:"name"`
+	KeySHA256 string    `yaml:"key_sha256"`
+	Expires   time.Time `yaml:"expires"`
+}
+
+// BanPolicy bans repeat offenders, fail2ban-style: Threshold blocks by one of
+// Rules from the same Key ("ip" or "subnet") within WindowSec ban it for the
+// next step of DurationsSec, so each repeat ban lasts longer (the last step
+// repeats). A key's ban count resets after ResetAfterSec without a ban.
+type BanPolicy struct {
+	Enabled bool `yaml:"enabled"`
+	// Rules default to rate_limit and user_agent.
+	Rules         []string `yaml:"rules"`
+	Key           string   `yaml:"key"`
+	Threshold     int      `yaml:"threshold"`
+	WindowSec     int      `yaml:"window_sec"`
+	DurationsSec  []int    `yaml:"durations_sec"`
+	ResetAfterSec int      `yaml:"reset_after_sec"`
+}
+
+// BanKeys are what a BanPolicy can ban: the client IP or its /24 (/64) subnet.
+var BanKeys = []string{"ip", "subnet"}
+
+// BotRule configures one bot filter rule. A rule scores a request from 0 (clean)
+// to 1 (bot) and adds score × Weight (default 1) to its total.
+type BotRule struct {
+	Name string `yaml:"name"`
+	// ID tells apart several instances of one rule; it defaults to Name.
+	ID      string   `yaml:"id"`
+	Weight  *float64 `yaml:"weight"`
+	Enabled *bool    `yaml:"enabled"`
+	// Shadow rules are scored and logged but only count toward would_block.
+	Shadow bool `yaml:"shadow"`
+	// Match replaces the rule's blacklist, e.g. to try new UA substrings in shadow
+	// next to the enforcing user_agent rule. Only for BotRulesWithMatch.
+	Match []string `yaml:"match"`
+}
+
+// BotRuleNames are the bot filter rules, in their default order.
+var BotRuleNames = []string{"referrer", "user_agent", "fingerprint", "rate_limit", "ip_prefix", "asn", "geo", "mobile_only"}
+
+// BotRulesWithMatch are the rules whose blacklist a BotRule.Match can replace.
+var BotRulesWithMatch = []string{"referrer", "user_agent", "ip_prefix"}
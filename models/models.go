@@ -14,27 +14,42 @@ const (
 	AdTypeClickAdilla = "4"
 	TypeRouteRedirect = "redirect"
 	TypeRoutePreSale  = "pre-sale"
+	TypeRouteArticle  = "article"
 	TypePostback      = "postback"
 )
 
 type Product struct {
-	ID           string  `json:"id" yaml:"id"`
-	Name         string  `json:"name" yaml:"name"`
-	Description  string  `json:"description" yaml:"description"`
-	URL          string  `json:"url" yaml:"url"`
-	Image        string  `json:"image" yaml:"image"`
-	Percentage   float64 `json:"percentage" yaml:"percentage"`
-	Komisi       string  `json:"komisi" yaml:"komisi"`
-	KomisiHingga string  `json:"komisi_hingga" yaml:"komisi_hingga"`
+	ID           string   `json:"id" yaml:"id"`
+	Name         string   `json:"name" yaml:"name"`
+	Description  string   `json:"description" yaml:"description"`
+	URL          string   `json:"url" yaml:"url"`
+	Image        string   `json:"image" yaml:"image"`
+	Percentage   float64  `json:"percentage" yaml:"percentage"`
+	Komisi       string   `json:"komisi" yaml:"komisi"`
+	KomisiHingga string   `json:"komisi_hingga" yaml:"komisi_hingga"`
+	Pool         string   `json:"pool,omitempty" yaml:"pool"`
+	Category     string   `json:"category,omitempty" yaml:"category"`
+	Tags         []string `json:"tags,omitempty" yaml:"tags"`
+	Price        float64  `json:"price,omitempty" yaml:"price"`
+	Sales        int      `json:"sales,omitempty" yaml:"sales"`
+	Commission   float64  `json:"commission,omitempty" yaml:"commission"`
+	// ASNs and ASNCategories restrict a rotation product to visitors from those
+	// networks; see the redirect handler.
+	ASNs          []uint   `json:"asns,omitempty" yaml:"asns"`
+	ASNCategories []string `json:"asn_categories,omitempty" yaml:"asn_categories"`
 }
 
 type GeoInfo struct {
-	Country   string  `json:"country"`
-	Region    string  `json:"region"`
-	City      string  `json:"city"`
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-	Timezone  string  `json:"timezone"`
+	Country     string  `json:"country"`
+	CountryCode string  `json:"country_code,omitempty"`
+	Region      string  `json:"region"`
+	City        string  `json:"city"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	Timezone    string  `json:"timezone"`
+	// ASN and ASOrg come from GeoLite2-ASN; ASN is 0 when unknown.
+	ASN   uint   `json:"asn,omitempty"`
+	ASOrg string `json:"as_org,omitempty"`
 }
 
 type LogEntry struct {
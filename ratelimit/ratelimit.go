@@ -0,0 +1,163 @@
+// Package ratelimit counts requests per key with sliding-window counters. Keys are
+// spread over mutex-guarded shards, and each shard evicts its least recently used
+// keys beyond its share of the memory cap, so a flood of distinct keys costs a
+// bounded amount of memory and never serialises all requests on one lock.
+package ratelimit
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const shardCount = 64
+
+// DefaultMaxKeys is the key cap used when New is given none.
+const DefaultMaxKeys = 100000
+
+// Limiter holds one counter per key. The zero value is not usable; call New.
+type Limiter struct {
+	shards   [shardCount]shard
+	maxKeys  int
+	perShard int
+}
+
+type shard struct {
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List // front is most recently used
+}
+
+type counter struct {
+	key    string
+	window time.Duration
+	start  time.Time // start of the current fixed window
+	prev   int       // requests in the window before start
+	cur    int       // requests since start
+}
+
+// New returns a limiter remembering at most maxKeys keys (DefaultMaxKeys if <= 0).
+func New(maxKeys int) *Limiter {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	l := &Limiter{maxKeys: maxKeys, perShard: (maxKeys + shardCount - 1) / shardCount}
+	for i := range l.shards {
+		l.shards[i].items = map[string]*list.Element{}
+		l.shards[i].order = list.New()
+	}
+	return l
+}
+
+func (l *Limiter) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &l.shards[h.Sum32()%shardCount]
+}
+
+// Hit records a request for key at now and returns the estimated number of
+// requests in the sliding window ending now, this one included: the current
+// fixed window's count plus the previous one's, weighted by its overlap.
+func (l *Limiter) Hit(key string, window time.Duration, now time.Time) int {
+	s := l.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c *counter
+	if el, ok := s.items[key]; ok {
+		s.order.MoveToFront(el)
+		c = el.Value.(*counter)
+		if c.window != window {
+			// The limit was reconfigured; start over rather than mix windows.
+			*c = counter{key: key, window: window, start: now}
+		}
+	} else {
+		c = &counter{key: key, window: window, start: now}
+		s.items[key] = s.order.PushFront(c)
+		if s.order.Len() > l.perShard {
+			oldest := s.order.Back()
+			s.order.Remove(oldest)
+			delete(s.items, oldest.Value.(*counter).key)
+		}
+	}
+	c.advance(now)
+	c.cur++
+	return c.estimate(now)
+}
+
+// advance moves the fixed window forward to contain now.
+func (c *counter) advance(now time.Time) {
+	elapsed := now.Sub(c.start)
+	switch {
+	case elapsed < c.window:
+	case elapsed < 2*c.window:
+		c.start, c.prev, c.cur = c.start.Add(c.window), c.cur, 0
+	default:
+		c.start, c.prev, c.cur = now, 0, 0
+	}
+}
+
+func (c *counter) estimate(now time.Time) int {
+	overlap := 1 - float64(now.Sub(c.start))/float64(c.window)
+	return c.cur + int(float64(c.prev)*overlap+0.5)
+}
+
+// MaxKeys is the cap the limiter was created with.
+func (l *Limiter) MaxKeys() int {
+	return l.maxKeys
+}
+
+// Len is the number of keys currently held.
+func (l *Limiter) Len() int {
+	n := 0
+	for i := range l.shards {
+		s := &l.shards[i]
+		s.mu.Lock()
+		n += s.order.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// State is one persisted counter.
+type State struct {
+	WindowSec float64   `json:"window_sec"`
+	Start     time.Time `json:"start"`
+	Prev      int       `json:"prev"`
+	Cur       int       `json:"cur"`
+}
+
+// Snapshot returns the counters whose windows have not run out at now.
+func (l *Limiter) Snapshot(now time.Time) map[string]State {
+	out := map[string]State{}
+	for i := range l.shards {
+		s := &l.shards[i]
+		s.mu.Lock()
+		for key, el := range s.items {
+			c := *el.Value.(*counter)
+			c.advance(now)
+			if c.prev+c.cur > 0 {
+				out[key] = State{WindowSec: c.window.Seconds(), Start: c.start, Prev: c.prev, Cur: c.cur}
+			}
+		}
+		s.mu.Unlock()
+	}
+	return out
+}
+
+// Restore loads counters from Snapshot, keeping any key already counted.
+func (l *Limiter) Restore(states map[string]State) {
+	for key, st := range states {
+		window := time.Duration(st.WindowSec * float64(time.Second))
+		if window <= 0 {
+			continue
+		}
+		s := l.shard(key)
+		s.mu.Lock()
+		if _, ok := s.items[key]; !ok && s.order.Len() < l.perShard {
+			s.items[key] = s.order.PushBack(&counter{key: key, window: window, start: st.Start, prev: st.Prev, cur: st.Cur})
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHitSlidingWindow(t *testing.T) {
+	l := New(0)
+	start := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		l.Hit("k", 10*time.Second, start)
+	}
+	// Halfway into the next window half of the previous window still counts.
+	if got := l.Hit("k", 10*time.Second, start.Add(15*time.Second)); got != 6 {
+		t.Errorf("hits = %d, want 6", got)
+	}
+	if got := l.Hit("k", 10*time.Second, start.Add(time.Minute)); got != 1 {
+		t.Errorf("hits after idle = %d, want 1", got)
+	}
+}
+
+func TestMaxKeysEvictsLeastRecentlyUsed(t *testing.T) {
+	l := New(shardCount * 2)
+	now := time.Now()
+	for i := 0; i < 10000; i++ {
+		l.Hit(fmt.Sprint("key", i), time.Minute, now)
+	}
+	if n := l.Len(); n > shardCount*2 {
+		t.Errorf("Len = %d, want at most %d", n, shardCount*2)
+	}
+	if got := l.Hit("key9999", time.Minute, now); got != 2 {
+		t.Errorf("recent key hits = %d, want 2", got)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	l := New(0)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		l.Hit("a", time.Minute, now)
+	}
+	l.Hit("expired", time.Second, now.Add(-time.Minute))
+
+	snap := l.Snapshot(now)
+	if _, ok := snap["expired"]; ok {
+		t.Error("snapshot kept an expired counter")
+	}
+	r := New(0)
+	r.Restore(snap)
+	if got := r.Hit("a", time.Minute, now); got != 4 {
+		t.Errorf("restored hits = %d, want 4", got)
+	}
+}
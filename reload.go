@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-redirect/audit"
+	"go-redirect/catalog"
+	"go-redirect/handlers"
+	"go-redirect/middleware"
+	"go-redirect/models"
+	"go-redirect/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// maxDiffEntries caps the change list logged with config_reloaded.
+const maxDiffEntries = 50
+
+// configReloader re-reads the config layers and applies it to handlers, the bot filter and
+// the catalog. Every part is validated before any is swapped, so a bad file leaves
+// the running config untouched.
+type configReloader struct {
+	layers utils.ConfigLayers
+	bots   interface {
+		Reconfigure(middleware.BotFilterConfig) error
+	}
+	catalog *catalog.Service
+
+	mu       sync.Mutex
+	current  *models.Config
+	modTimes map[string]time.Time
+}
+
+func newConfigReloader(layers utils.ConfigLayers, cfg *models.Config, bots interface {
+	Reconfigure(middleware.BotFilterConfig) error
+}, cat *catalog.Service) (*configReloader, error) {
+	r := &configReloader{layers: layers, bots: bots, catalog: cat, modTimes: fileTimes(watchedFiles(layers, cfg))}
+	if err := r.apply(cfg); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Config returns the config currently in effect.
+func (r *configReloader) Config() *models.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload reads the config layers and applies them. source ("sighup", "file") is
+// recorded in the logs and the audit trail with the system as actor.
+func (r *configReloader) Reload(source string) error {
+	return r.reload(audit.Entry{Actor: "system", Source: source})
+}
+
+// reload is Reload on behalf of the actor in e, which is completed with the
+// outcome and the changed values and recorded in the audit trail.
+func (r *configReloader) reload(e audit.Entry) error {
+	prev := r.Config()
+	// Remember the files even on failure so the watcher does not retry them every tick.
+	mod := fileTimes(watchedFiles(r.layers, prev))
+	cfg, err := r.layers.Load()
+	if err == nil {
+		err = r.apply(cfg)
+	}
+	if err == nil {
+		// Start watching IP list files the new config added.
+		for path, t := range fileTimes(watchedFiles(r.layers, cfg)) {
+			if _, ok := mod[path]; !ok {
+				mod[path] = t
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.modTimes = mod
+	r.mu.Unlock()
+
+	e.Action = "config.reload"
+	e.Target = strings.Join(r.layers.Files(), ",")
+	if err != nil {
+		e.Error = err.Error()
+		utils.LogInfo(utils.LogEntry{
+			Type: "config_reload_failed",
+			Extra: map[string]interface{}{
+				"source": e.Source,
+				"error":  err.Error(),
+			},
+		})
+	} else if prev != nil {
+		e.Before, e.After = changedValues(prev, cfg)
+	}
+	audit.Record(e)
+	return err
+}
+
+func (r *configReloader) apply(cfg *models.Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	botCfg := botFilterConfig(cfg)
+	if err := middleware.ValidateBotFilterConfig(botCfg); err != nil {
+		return fmt.Errorf("bot_filter: %w", err)
+	}
+	snap, err := r.catalog.Stage(cfg.Products)
+	if err != nil {
+		return fmt.Errorf("products: %w", err)
+	}
+	resolver, err := middleware.NewIPResolver(cfg.Proxy)
+	if err != nil {
+		return fmt.Errorf("proxy.trusted_cidrs%w", err)
+	}
+
+	if cfg.PreSale.HandoffSecret == "" {
+		utils.LogInfo(utils.LogEntry{
+			Type:  "handoff_secret_warning",
+			Extra: map[string]interface{}{"message": "pre_sale.handoff_secret not set; pre-sale hand-offs are not signed and clicks are not attributed to impressions"},
+		})
+	}
+
+	// Validated; nothing below can fail halfway.
+	if r.bots != nil {
+		if err := r.bots.Reconfigure(botCfg); err != nil {
+			return fmt.Errorf("bot_filter: %w", err)
+		}
+	}
+	handlers.ApplySettings(handlers.NewSettings(cfg))
+	middleware.SetIPResolver(resolver)
+	r.catalog.Commit(snap)
+
+	if prev := r.current; prev != nil {
+		changes := configDiff(prev, cfg)
+		utils.LogInfo(utils.LogEntry{
+			Type: "config_reloaded",
+			Extra: map[string]interface{}{
+				"changes":         changes,
+				"change_count":    len(changes),
+				"catalog_version": snap.Version,
+			},
+		})
+	}
+	r.current = cfg
+	return nil
+}
+
+// Watch reloads on SIGHUP and whenever the mtime of a config file or of an IP list
+// file it names changes.
+func (r *configReloader) Watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-hup:
+			_ = r.Reload("sighup")
+		case <-t.C:
+			if r.changed() {
+				_ = r.Reload("file")
+			}
+		}
+	}
+}
+
+func (r *configReloader) changed() bool {
+	mod := fileTimes(watchedFiles(r.layers, r.Config()))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for path, t := range mod {
+		if !t.Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedFiles is the config layers plus the bot filter's IP list files in cfg.
+func watchedFiles(layers utils.ConfigLayers, cfg *models.Config) []string {
+	files := layers.Files()
+	if cfg != nil {
+		files = append(files, cfg.BotFilter.IPBlocklistFiles...)
+		files = append(files, cfg.BotFilter.IPAllowlistFiles...)
+	}
+	return files
+}
+
+func fileTimes(files []string) map[string]time.Time {
+	mod := map[string]time.Time{}
+	for _, f := range files {
+		if st, err := os.Stat(f); err == nil {
+			mod[f] = st.ModTime()
+		}
+	}
+	return mod
+}
+
+// Handler serves POST /admin/reload-config.
+func (r *configReloader) Handler(c *fiber.Ctx) error {
+	if err := r.reload(audit.FromRequest(c)); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"status": "rejected", "error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"status": "reloaded", "catalog_version": r.catalog.Snapshot().Version})
+}
+
+// ConfigHandler serves GET /admin/config: the effective config with secrets redacted.
+func (r *configReloader) ConfigHandler(c *fiber.Ctx) error {
+	doc, err := utils.RedactedConfig(r.Config())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	var out interface{}
+	if err := doc.Decode(&out); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"files": r.layers.Files(), "config": out})
+}
+
+func botFilterConfig(cfg *models.Config) middleware.BotFilterConfig {
+	return middleware.BotFilterConfig{
+		AllowCountries:     cfg.BotFilter.AllowCountries,
+		BlacklistUA:        cfg.BotFilter.BlacklistUA,
+		BlacklistIPPrefix:  cfg.BotFilter.BlacklistIPPrefix,
+		IPBlocklistFiles:   cfg.BotFilter.IPBlocklistFiles,
+		IPAllowlist:        cfg.BotFilter.IPAllowlist,
+		IPAllowlistFiles:   cfg.BotFilter.IPAllowlistFiles,
+		BlacklistReferrer:  cfg.BotFilter.BlacklistReferrer,
+		BlacklistRefRegex:  cfg.BotFilter.BlacklistRefRegex,
+		RateLimitMax:       cfg.BotFilter.RateLimitMax,
+		RateLimitWindowSec: cfg.BotFilter.RateLimitWindowSec,
+		RateLimits:         rateLimitConfigs(cfg.BotFilter.RateLimits),
+		CampaignRateLimits: campaignRateLimits(cfg.Campaigns),
+		RateLimitMaxKeys:   cfg.BotFilter.RateLimitMaxKeys,
+		LogAllowed:         cfg.BotFilter.LogAllowed,
+		LogBlocked:         cfg.BotFilter.LogBlocked,
+		AllowMobileOnly:    cfg.BotFilter.AllowMobileOnly,
+		BlockThreshold:     cfg.BotFilter.BlockThreshold,
+		Rules:              botRuleConfigs(cfg.BotFilter.Rules),
+		Shadow:             cfg.BotFilter.Shadow,
+		ASNCategories:      cfg.ASNCategories,
+		BlockASNs:          cfg.BotFilter.BlockASNs,
+		BlockASNCategories: cfg.BotFilter.BlockASNCategories,
+		AllowASNs:          cfg.BotFilter.AllowASNs,
+		Bans:               banConfig(cfg.BotFilter.Bans),
+		Bypass:             bypassConfig(cfg.BotFilter.Bypass),
+		Fingerprint: middleware.FingerprintConfig{
+			Enabled:   cfg.BotFilter.Fingerprint.Enabled,
+			Anomalies: cfg.BotFilter.Fingerprint.Anomalies,
+			MaxUAAge:  time.Duration(cfg.BotFilter.Fingerprint.MaxUAAgeDays) * 24 * time.Hour,
+		},
+		Challenge: middleware.ChallengeConfig{
+			Enabled:      cfg.BotFilter.Challenge.Enabled,
+			Threshold:    cfg.BotFilter.Challenge.Threshold,
+			Difficulty:   cfg.BotFilter.Challenge.Difficulty,
+			Secret:       []byte(cfg.BotFilter.Challenge.Secret),
+			CookieTTL:    time.Duration(cfg.BotFilter.Challenge.CookieTTLSec) * time.Second,
+			ChallengeTTL: time.Duration(cfg.BotFilter.Challenge.ChallengeTTLSec) * time.Second,
+		},
+	}
+}
+
+func bypassConfig(b models.Bypass) middleware.BypassConfig {
+	out := middleware.BypassConfig{LinkSecret: []byte(b.LinkSecret)}
+	for _, k := range b.Keys {
+		out.Keys = append(out.Keys, middleware.BypassKey{Name: k.Name, KeySHA256: k.KeySHA256, Expires: k.Expires})
+	}
+	return out
+}
+
+func banConfig(b models.BanPolicy) middleware.BanConfig {
+	out := middleware.BanConfig{
+		Enabled:    b.Enabled,
+		Rules:      b.Rules,
+		Key:        b.Key,
+		Threshold:  b.Threshold,
+		Window:     time.Duration(b.WindowSec) * time.Second,
+		ResetAfter: time.Duration(b.ResetAfterSec) * time.Second,
+	}
+	for _, d := range b.DurationsSec {
+		out.Durations = append(out.Durations, time.Duration(d)*time.Second)
+	}
+	return out
+}
+
+func rateLimitConfigs(limits []models.RateLimit) []middleware.RateLimitConfig {
+	var out []middleware.RateLimitConfig
+	for _, l := range limits {
+		out = append(out, middleware.RateLimitConfig{Key: l.Key, Max: l.Max, Window: time.Duration(l.WindowSec) * time.Second})
+	}
+	return out
+}
+
+// campaignRateLimits keys the campaigns that set rate_limits by id.
+func campaignRateLimits(campaigns []models.Campaign) map[string][]middleware.RateLimitConfig {
+	out := map[string][]middleware.RateLimitConfig{}
+	for _, cp := range campaigns {
+		if len(cp.RateLimits) > 0 {
+			out[cp.ID] = rateLimitConfigs(cp.RateLimits)
+		}
+	}
+	return out
+}
+
+// botRuleConfigs resolves the optional weight and enabled flag of each rule.
+func botRuleConfigs(rules []models.BotRule) []middleware.RuleConfig {
+	var out []middleware.RuleConfig
+	for _, r := range rules {
+		rc := middleware.RuleConfig{Name: r.Name, ID: r.ID, Weight: 1, Enabled: true, Shadow: r.Shadow, Match: r.Match}
+		if r.Weight != nil {
+			rc.Weight = *r.Weight
+		}
+		if r.Enabled != nil {
+			rc.Enabled = *r.Enabled
+		}
+		out = append(out, rc)
+	}
+	return out
+}
+
+// configDiff lists changed config paths as "path: old -> new", with secret values
+// redacted. The list is sorted and capped at maxDiffEntries.
+func configDiff(oldCfg, newCfg *models.Config) []string {
+	before, after := changedValues(oldCfg, newCfg)
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	var changes []string
+	for k := range keys {
+		o, oldOK := before[k]
+		n, newOK := after[k]
+		switch {
+		case !oldOK:
+			changes = append(changes, fmt.Sprintf("%s: added %s", k, n))
+		case !newOK:
+			changes = append(changes, fmt.Sprintf("%s: removed", k))
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, o, n))
+		}
+	}
+	sort.Strings(changes)
+	if len(changes) > maxDiffEntries {
+		more := len(changes) - maxDiffEntries
+		changes = append(changes[:maxDiffEntries], fmt.Sprintf("... and %d more", more))
+	}
+	return changes
+}
+
+// changedValues returns the old and new value of every config path that differs,
+// with secret values redacted. A path missing from one side was added or removed.
+func changedValues(oldCfg, newCfg *models.Config) (before, after map[string]string) {
+	oldFlat, newFlat := flattenConfig(oldCfg), flattenConfig(newCfg)
+	before, after = map[string]string{}, map[string]string{}
+	redact := func(k, v string) string {
+		if utils.IsSecretKey(k) {
+			return "[redacted]"
+		}
+		return v
+	}
+	for k, o := range oldFlat {
+		if n, ok := newFlat[k]; !ok || n != o {
+			before[k] = redact(k, o)
+		}
+	}
+	for k, n := range newFlat {
+		if o, ok := oldFlat[k]; !ok || n != o {
+			after[k] = redact(k, n)
+		}
+	}
+	return before, after
+}
+
+func flattenConfig(cfg *models.Config) map[string]string {
+	out := map[string]string{}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return out
+	}
+	var tree interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return out
+	}
+	flattenInto(out, "", tree)
+	return out
+}
+
+func flattenInto(out map[string]string, prefix string, v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			p := k
+			if prefix != "" {
+				p = prefix + "." + k
+			}
+			flattenInto(out, p, child)
+		}
+	case []interface{}:
+		for i, child := range t {
+			flattenInto(out, fmt.Sprintf("%s[%d]", prefix, i), child)
+		}
+	default:
+		out[prefix] = fmt.Sprint(t)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-redirect/catalog"
+	"go-redirect/handlers"
+	"go-redirect/middleware"
+	"go-redirect/models"
+	"go-redirect/utils"
+)
+
+type fakeBots struct{ cfg middleware.BotFilterConfig }
+
+func (f *fakeBots) Reconfigure(cfg middleware.BotFilterConfig) error {
+	f.cfg = cfg
+	return nil
+}
+
+func writeConfig(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigReloadIsAllOrNothing(t *testing.T) {
+	t.Setenv("LOG_PATH", t.TempDir())
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+propeller: {aid: "1"}
+bot_filter: {blacklist_ua: [curl]}
+products:
+  - {name: A, url: "https://a.example", percentage: 100}
+`)
+	snap, err := catalog.NewSnapshot(nil, []models.Product{{ID: "csv-1", URL: "https://c.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat := catalog.NewStatic(snap)
+	bots := &fakeBots{}
+
+	r, err := newConfigReloader(utils.ConfigLayers{Path: path}, &models.Config{}, bots, cat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload("test"); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := handlers.CurrentSettings().Propeller.Aid; got != "1" {
+		t.Fatalf("propeller aid = %q, want 1", got)
+	}
+	if len(bots.cfg.BlacklistUA) != 1 || len(cat.Snapshot().YAMLProducts) != 1 {
+		t.Fatalf("bot filter or catalog not applied: %+v %+v", bots.cfg, cat.Snapshot().YAMLProducts)
+	}
+	version := cat.Snapshot().Version
+
+	// A bad regex must reject the whole file, including the valid propeller change.
+	writeConfig(t, path, `
+propeller: {aid: "2"}
+bot_filter: {blacklist_ref_regex: ["("]}
+products:
+  - {name: B, url: "https://b.example", percentage: 100}
+`)
+	err = r.Reload("test")
+	if err == nil || !strings.Contains(err.Error(), "bot_filter") {
+		t.Fatalf("expected bot_filter error, got %v", err)
+	}
+	if got := handlers.CurrentSettings().Propeller.Aid; got != "1" {
+		t.Fatalf("propeller aid = %q after failed reload, want 1", got)
+	}
+	if cat.Snapshot().Version != version || cat.Snapshot().YAMLProducts[0].Name != "A" {
+		t.Fatal("catalog swapped by a failed reload")
+	}
+}
+
+func TestConfigDiffRedactsSecrets(t *testing.T) {
+	oldCfg := &models.Config{Propeller: models.Propeller{Aid: "1"}}
+	newCfg := &models.Config{Propeller: models.Propeller{Aid: "2"}}
+	newCfg.PreSale.HandoffSecret = "s3cret"
+
+	changes := strings.Join(configDiff(oldCfg, newCfg), "\n")
+	if !strings.Contains(changes, "propeller.aid: 1 -> 2") {
+		t.Fatalf("missing aid change in %q", changes)
+	}
+	if strings.Contains(changes, "s3cret") {
+		t.Fatalf("secret leaked in %q", changes)
+	}
+}
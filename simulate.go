@@ -0,0 +1,365 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"go-redirect/geo"
+	"go-redirect/middleware"
+	"go-redirect/models"
+	"go-redirect/utils"
+)
+
+const defaultSimUA = "Mozilla/5.0 (Linux; Android 13; SM-A145F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Mobile Safari/537.36"
+
+// offlineFlags are shared by simulate and route-explain.
+type offlineFlags struct {
+	config    *string
+	products  *string
+	cityDB    *string
+	countryDB *string
+	asnDB     *string
+	botFilter *bool
+	ua        *string
+	referer   *string
+}
+
+func registerOfflineFlags(fs *flag.FlagSet, botFilterDefault bool) offlineFlags {
+	return offlineFlags{
+		config:    configFlag(fs),
+		products:  fs.String("products", defaultCSVPath, "product CSV"),
+		cityDB:    fs.String("city-db", "GeoLite2-City.mmdb", "GeoLite2 city database (skipped if missing)"),
+		countryDB: fs.String("country-db", "GeoLite2-Country.mmdb", "GeoLite2 country database for the geo rule (skipped if missing)"),
+		asnDB:     fs.String("asn-db", "GeoLite2-ASN.mmdb", "GeoLite2 ASN database for the asn rule (skipped if missing)"),
+		botFilter: fs.Bool("bot-filter", botFilterDefault, "run requests through the bot filter"),
+		ua:        fs.String("ua", defaultSimUA, "User-Agent header"),
+		referer:   fs.String("referer", "", "Referer header"),
+	}
+}
+
+// offlineRuntime builds the real runtime with logs redirected to a throwaway
+// directory, so driving the handlers never touches production logs.
+func offlineRuntime(f offlineFlags) (*runtime, func(), error) {
+	dir, err := os.MkdirTemp("", "go-redirect-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	os.Setenv("LOG_PATH", dir)
+	rt, err := newRuntime(runtimeOptions{
+		ConfigPath: *f.config,
+		CSVPath:    *f.products,
+		Geo:        geo.Paths{City: *f.cityDB, Country: *f.countryDB, ASN: *f.asnDB},
+	})
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	middleware.SetBotFilterEnabled(*f.botFilter)
+	// Synthetic requests arrive over app.Test's in-process connection (0.0.0.0);
+	// trust it so the visitor IPs sent as X-Forwarded-For are used.
+	proxy := rt.cfg.Proxy
+	proxy.TrustedCIDRs = append(append([]string(nil), proxy.TrustedCIDRs...), "0.0.0.0/32")
+	resolver, err := middleware.NewIPResolver(proxy)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	middleware.SetIPResolver(resolver)
+	middleware.ConsoleOutput = io.Discard
+	return rt, cleanup, nil
+}
+
+// runSimulate implements `go-redirect simulate`: it sends synthetic visitors through
+// the real handlers and reports the resulting product, variant and block split.
+func runSimulate(args []string) int {
+	fs := newFlagSet("simulate", "")
+	flags := registerOfflineFlags(fs, false)
+	n := fs.Int("n", 1000, "number of requests")
+	path := fs.String("path", "/", "request path and query, e.g. \"/pre-sale?campaign=popcash-id\"")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *n <= 0 {
+		return fail("-n must be positive")
+	}
+
+	rt, cleanup, err := offlineRuntime(flags)
+	if err != nil {
+		printConfigError(*flags.config, err)
+		return 1
+	}
+	defer cleanup()
+	app := rt.newApp()
+
+	statuses := map[int]int{}
+	for i := 0; i < *n; i++ {
+		req := httptest.NewRequest("GET", *path, nil)
+		req.Header.Set("User-Agent", *flags.ua)
+		if *flags.referer != "" {
+			req.Header.Set("Referer", *flags.referer)
+		}
+		// A distinct visitor per request keeps the rate limiter and sticky variants out of the way.
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("36.%d.%d.%d", rand.IntN(256), rand.IntN(256), 1+rand.IntN(254)))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			return fail("request %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+		statuses[resp.StatusCode]++
+	}
+
+	products := map[string]int{}
+	variants := map[string]int{}
+	blocks := map[string]int{}
+	wouldBlocks := map[string]int{}
+	challenges := map[string]int{}
+	var redirects int
+	if err := utils.ForEachLogEntry(func(e utils.LogEntry) {
+		switch e.Type {
+		case models.TypeRouteRedirect:
+			redirects++
+			products[e.ProductName]++
+		case models.TypeRoutePreSale:
+			if v, _ := e.Extra["variant"].(string); v != "" {
+				variants[v]++
+			}
+		case "block_request":
+			reason, _ := e.Extra["reason"].(string)
+			blocks[reason]++
+		case "would_block":
+			reason, _ := e.Extra["reason"].(string)
+			wouldBlocks[reason]++
+		case "challenge_issued":
+			reason, _ := e.Extra["reason"].(string)
+			challenges[reason]++
+		}
+	}); err != nil {
+		return fail("reading logs: %v", err)
+	}
+
+	fmt.Printf("simulated %d requests to %s\n\n", *n, *path)
+	printCounts("status", intKeys(statuses), *n, nil)
+	if redirects > 0 {
+		var expected map[string]float64
+		if u, err := url.Parse(*path); err == nil && u.Path == "/" && u.Query().Get("product") == "" {
+			expected = rotationShares(rt.catalog.Snapshot().YAMLProducts)
+		}
+		printCounts("redirect product", products, redirects, expected)
+	}
+	if len(variants) > 0 {
+		printCounts("pre-sale variant", variants, *n, nil)
+	}
+	if len(blocks) > 0 {
+		printCounts("block reason", blocks, *n, nil)
+	}
+	if len(wouldBlocks) > 0 {
+		printCounts("would-block reason (shadow)", wouldBlocks, *n, nil)
+	}
+	if len(challenges) > 0 {
+		printCounts("challenge reason", challenges, *n, nil)
+	}
+	return 0
+}
+
+// rotationShares is the configured share of each YAML rotation product.
+func rotationShares(products []models.Product) map[string]float64 {
+	total := 0.0
+	for _, p := range products {
+		total += p.Percentage
+	}
+	shares := map[string]float64{}
+	for _, p := range products {
+		if total > 0 {
+			shares[p.Name] += p.Percentage / total
+		}
+	}
+	return shares
+}
+
+func intKeys(m map[int]int) map[string]int {
+	out := make(map[string]int, len(m))
+	for k, v := range m {
+		out[fmt.Sprint(k)] = v
+	}
+	return out
+}
+
+// printCounts prints a count table sorted by count, with the expected share
+// alongside when known.
+func printCounts(title string, counts map[string]int, total int, expected map[string]float64) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	for k := range expected {
+		if _, ok := counts[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	header := fmt.Sprintf("%-48s %8s %9s", title, "count", "share")
+	if expected != nil {
+		header += fmt.Sprintf(" %9s", "expected")
+	}
+	fmt.Println(header)
+	for _, k := range keys {
+		line := fmt.Sprintf("  %-46s %8d %8.1f%%", k, counts[k], 100*float64(counts[k])/float64(total))
+		if expected != nil {
+			line += fmt.Sprintf(" %8.1f%%", 100*expected[k])
+		}
+		fmt.Println(line)
+	}
+	fmt.Println()
+}
+
+// runRouteExplain implements `go-redirect route-explain <url>`: it shows how the
+// campaign and passthrough policy treat the request's params, which products it can
+// reach, and what the real handlers answered and logged.
+func runRouteExplain(args []string) int {
+	fs := newFlagSet("route-explain", "<url>")
+	flags := registerOfflineFlags(fs, true)
+	ip := fs.String("ip", "36.70.1.1", "visitor IP (sent as X-Forwarded-For)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	u, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		return fail("%v", err)
+	}
+	target := u.RequestURI()
+
+	rt, cleanup, err := offlineRuntime(flags)
+	if err != nil {
+		printConfigError(*flags.config, err)
+		return 1
+	}
+	defer cleanup()
+
+	query := map[string]string{}
+	for k, v := range u.Query() {
+		query[k] = v[0]
+	}
+	fmt.Printf("request    GET %s\n", target)
+	fmt.Printf("visitor    ip=%s bot-filter=%v\n", *ip, *flags.botFilter)
+
+	policy := rt.cfg.Passthrough
+	campaign := "none (global passthrough)"
+	if id := query["campaign"]; id != "" {
+		campaign = fmt.Sprintf("%q not configured, using globals", id)
+		for _, cp := range rt.cfg.Campaigns {
+			if cp.ID == id {
+				campaign = id
+				if cp.Passthrough != nil {
+					policy = *cp.Passthrough
+				}
+			}
+		}
+	}
+	mode := policy.Mode
+	if mode == "" {
+		mode = "deny"
+	}
+	fmt.Printf("campaign   %s\n", campaign)
+	fmt.Printf("policy     mode=%s params=%v\n", mode, policy.Params)
+
+	if len(query) > 0 {
+		fmt.Println("params")
+		keys := make([]string, 0, len(query))
+		for k := range query {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		forwarded := utils.ApplyPassthrough(policy, query)
+		for _, k := range keys {
+			switch {
+			case utils.IsInternalParam(k):
+				fmt.Printf("  %-16s dropped (internal)\n", k)
+			case !utils.PassthroughAllows(policy, k):
+				fmt.Printf("  %-16s dropped (not allowed by %s policy)\n", k, mode)
+			default:
+				name := k
+				if to, ok := policy.Rename[k]; ok && to != "" {
+					name = to
+				}
+				note := ""
+				if forwarded[name] != query[k] {
+					note = fmt.Sprintf(" (transformed to %q)", forwarded[name])
+				}
+				fmt.Printf("  %-16s forwarded as %s%s\n", k, name, note)
+			}
+		}
+	}
+
+	if u.Path == "/" {
+		snap := rt.catalog.Snapshot()
+		fmt.Println("products")
+		if p, ok := snap.Get(query["product"]); ok {
+			fmt.Printf("  pinned   %s\n           %s\n", p.Name, utils.BuildAffiliateURL(p.URL, query, policy))
+		} else {
+			shares := rotationShares(snap.YAMLProducts)
+			for _, p := range snap.YAMLProducts {
+				fmt.Printf("  %5.1f%%   %s\n           %s\n", 100*shares[p.Name], p.Name, utils.BuildAffiliateURL(p.URL, query, policy))
+			}
+		}
+	}
+
+	// Only entries written by the request itself are shown, not startup warnings.
+	startup := 0
+	_ = utils.ForEachLogEntry(func(utils.LogEntry) { startup++ })
+
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("User-Agent", *flags.ua)
+	req.Header.Set("X-Forwarded-For", *ip)
+	if *flags.referer != "" {
+		req.Header.Set("Referer", *flags.referer)
+	}
+	resp, err := rt.newApp().Test(req, -1)
+	if err != nil {
+		return fail("request: %v", err)
+	}
+	resp.Body.Close()
+	fmt.Printf("response   %d", resp.StatusCode)
+	if loc := resp.Header.Get("Location"); loc != "" {
+		fmt.Printf(" -> %s", loc)
+	}
+	fmt.Println()
+
+	fmt.Println("logged")
+	seen := 0
+	if err := utils.ForEachLogEntry(func(e utils.LogEntry) {
+		if seen++; seen <= startup {
+			return
+		}
+		var details []string
+		if e.ProductName != "" {
+			details = append(details, fmt.Sprintf("product=%q", e.ProductName))
+		}
+		for _, k := range []string{"reason", "score", "shadow_score", "matched_ua", "ip_prefix", "countryCode", "variant", "presale_handoff", "sub_id"} {
+			if v, ok := e.Extra[k]; ok && v != "" {
+				details = append(details, fmt.Sprintf("%s=%v", k, v))
+			}
+		}
+		fmt.Printf("  %-24s %s\n", e.Type, strings.Join(details, " "))
+	}); err != nil {
+		return fail("reading logs: %v", err)
+	}
+	return 0
+}
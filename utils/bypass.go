@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"errors"
+	"time"
+)
+
+// Bypass link verification failures.
+var (
+	ErrBypassInvalid = errors.New("bypass token invalid")
+	ErrBypassExpired = errors.New("bypass token expired")
+)
+
+// BypassClaims names the device a signed bypass link was issued for.
+type BypassClaims struct {
+	Name      string `json:"n"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// SignBypass encodes claims under the bypass domain.
+func SignBypass(secret []byte, claims BypassClaims) string {
+	return signClaims(secret, bypassDomain, claims)
+}
+
+// VerifyBypass checks the signature, then the expiry.
+func VerifyBypass(secret []byte, token string, now time.Time) (BypassClaims, error) {
+	var claims BypassClaims
+	switch err := verifyClaims(secret, bypassDomain, token, now, &claims); err {
+	case nil:
+		return claims, nil
+	case errClaimsExpired:
+		return claims, ErrBypassExpired
+	default:
+		return claims, ErrBypassInvalid
+	}
+}
+
+func (c BypassClaims) expires() int64 { return c.ExpiresAt }
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"errors"
+	"time"
+)
+
+// Challenge token and cookie verification failures.
+var (
+	ErrChallengeInvalid = errors.New("challenge token invalid")
+	ErrChallengeExpired = errors.New("challenge token expired")
+)
+
+// ChallengeClaims describe a JavaScript challenge issued to a visitor, or, in a
+// pass cookie, only the visitor and how long the pass lasts. Target is the URL
+// the visitor continues to once solved; Source its type_ads.
+type ChallengeClaims struct {
+	Visitor    string `json:"v"`
+	Nonce      string `json:"c,omitempty"`
+	Difficulty int    `json:"d,omitempty"`
+	Target     string `json:"u,omitempty"`
+	Source     string `json:"s,omitempty"`
+	ExpiresAt  int64  `json:"e"`
+}
+
+// SignChallenge encodes the challenge served on the interstitial.
+func SignChallenge(secret []byte, claims ChallengeClaims) string {
+	return signClaims(secret, challengeDomain, claims)
+}
+
+// VerifyChallenge checks a challenge's signature, then its expiry.
+func VerifyChallenge(secret []byte, token string, now time.Time) (ChallengeClaims, error) {
+	return verifyChallengeClaims(secret, challengeDomain, token, now)
+}
+
+// SignChallengePass encodes the cookie set once a challenge is solved.
+func SignChallengePass(secret []byte, claims ChallengeClaims) string {
+	return signClaims(secret, challengePassDomain, claims)
+}
+
+// VerifyChallengePass checks a pass cookie's signature and expiry, and that it
+// was issued to visitor (a VisitorFingerprint), so a copied cookie is useless
+// from another IP or browser.
+func VerifyChallengePass(secret []byte, token, visitor string, now time.Time) (ChallengeClaims, error) {
+	claims, err := verifyChallengeClaims(secret, challengePassDomain, token, now)
+	if err == nil && claims.Visitor != visitor {
+		return claims, ErrChallengeInvalid
+	}
+	return claims, err
+}
+
+func (c ChallengeClaims) expires() int64 { return c.ExpiresAt }
+
+func verifyChallengeClaims(secret []byte, domain, token string, now time.Time) (ChallengeClaims, error) {
+	var claims ChallengeClaims
+	switch err := verifyClaims(secret, domain, token, now, &claims); err {
+	case nil:
+		return claims, nil
+	case errClaimsExpired:
+		return claims, ErrChallengeExpired
+	default:
+		return claims, ErrChallengeInvalid
+	}
+}
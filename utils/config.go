@@ -1,21 +1,124 @@
 package utils
 
 import (
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 
 	"go-redirect/models"
 
 	"gopkg.in/yaml.v3"
 )
 
+// LoadConfig reads a single config file strictly: unknown keys are rejected and the
+// result must pass ValidateConfig. All problems are returned together as ConfigErrors.
+// Use ConfigLayers to include the profile file and environment overrides.
 func LoadConfig(path string) (*models.Config, error) {
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
+	return ConfigLayers{Path: path}.Load()
+}
+
+// ParseConfig is LoadConfig for an in-memory document.
+func ParseConfig(data []byte) (*models.Config, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, err
 	}
+	if len(doc.Content) == 0 {
+		return decodeConfig(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+	}
+	return decodeConfig(doc.Content[0])
+}
+
+func decodeConfig(root *yaml.Node) (*models.Config, error) {
+	var errs ConfigErrors
+	checkKnownFields(root, reflect.TypeOf(models.Config{}), "", &errs)
+
+	// Unknown keys are already reported with their paths, so decode leniently and
+	// report semantic problems alongside them.
 	var cfg models.Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := root.Decode(&cfg); err != nil {
 		return nil, err
 	}
+	var semantic ConfigErrors
+	if errors.As(ValidateConfig(&cfg), &semantic) {
+		errs = append(errs, semantic...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	NormalizePassthrough(&cfg.Passthrough)
+	for _, cp := range cfg.Campaigns {
+		if cp.Passthrough != nil {
+			NormalizePassthrough(cp.Passthrough)
+		}
+	}
 	return &cfg, nil
 }
+
+// checkKnownFields walks the YAML tree alongside the Go type and reports every key
+// that has no matching field, e.g. "bot_filter.rate_limt_max".
+func checkKnownFields(n *yaml.Node, t reflect.Type, path string, errs *ConfigErrors) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			fields[name] = f.Type
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			p := joinPath(path, key.Value)
+			ft, ok := fields[key.Value]
+			if !ok {
+				where := fmt.Sprintf("line %d", key.Line)
+				if key.Line == 0 {
+					where = "set by environment"
+				}
+				errs.add(p, fmt.Sprintf("unknown field (%s)", where))
+				continue
+			}
+			checkKnownFields(val, ft, p, errs)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range n.Content {
+			checkKnownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			checkKnownFields(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value), errs)
+		}
+	}
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-redirect/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix  = "GOREDIRECT_"
+	envProfile = envPrefix + "PROFILE"
+	// envFileSuffix reads a secret's value from the named file, for mounted secrets.
+	envFileSuffix = "_FILE"
+	redacted      = "[redacted]"
+)
+
+// ConfigLayers describes how the effective config is assembled: the base YAML file,
+// an optional profile file next to it (config.<profile>.yaml) merged on top, and
+// GOREDIRECT_* environment overrides applied last.
+//
+// An override names a config path with "__" between levels and is upper-cased:
+// GOREDIRECT_BOT_FILTER__RATE_LIMIT_MAX=20, GOREDIRECT_PRODUCTS__0__PERCENTAGE=5.
+// Values are YAML scalars; a value starting with [ or { is parsed as a flow list or
+// map. Adding _FILE to a secret key (see IsSecretKey) reads the value from a file
+// instead, e.g. GOREDIRECT_CLICKADILLA__TOKEN_FILE=/run/secrets/clickadilla_token;
+// on any other key the suffix is part of the key's name.
+type ConfigLayers struct {
+	Path    string
+	Profile string
+	Env     []string
+}
+
+// ConfigLayersFromEnv uses the process environment and GOREDIRECT_PROFILE.
+func ConfigLayersFromEnv(path string) ConfigLayers {
+	return ConfigLayers{Path: path, Profile: os.Getenv(envProfile), Env: os.Environ()}
+}
+
+// Files returns the YAML files the config is read from, base first.
+func (l ConfigLayers) Files() []string {
+	files := []string{l.Path}
+	if l.Profile != "" {
+		ext := filepath.Ext(l.Path)
+		files = append(files, strings.TrimSuffix(l.Path, ext)+"."+l.Profile+ext)
+	}
+	return files
+}
+
+// Load assembles, strictly decodes and validates the effective config.
+func (l ConfigLayers) Load() (*models.Config, error) {
+	root, err := l.Node()
+	if err != nil {
+		return nil, err
+	}
+	return decodeConfig(root)
+}
+
+// Node returns the merged YAML document before decoding.
+func (l ConfigLayers) Node() (*yaml.Node, error) {
+	var root *yaml.Node
+	for _, path := range l.Files() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		if root == nil {
+			root = doc.Content[0]
+			continue
+		}
+		mergeNode(root, doc.Content[0])
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+
+	overrides := map[string]string{}
+	var keys []string
+	for _, kv := range l.Env {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(k, envPrefix) || k == envProfile {
+			continue
+		}
+		if name, ok := strings.CutSuffix(k, envFileSuffix); ok && IsSecretKey(envLeaf(name)) {
+			data, err := ioutil.ReadFile(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			k, v = name, strings.TrimRight(string(data), "\r\n")
+		}
+		if _, seen := overrides[k]; !seen {
+			keys = append(keys, k)
+		}
+		overrides[k] = v
+	}
+	// Sorted so the result does not depend on environment order.
+	sort.Strings(keys)
+	for _, k := range keys {
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(k, envPrefix)), "__")
+		if err := setNode(root, path, overrides[k]); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return root, nil
+}
+
+// envLeaf returns the last config path level an override variable names.
+func envLeaf(k string) string {
+	path := strings.Split(strings.TrimPrefix(k, envPrefix), "__")
+	return path[len(path)-1]
+}
+
+// mergeNode overlays src onto dst: maps merge key by key, anything else is replaced.
+func mergeNode(dst, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		*dst = *src
+		return
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, val := src.Content[i], src.Content[i+1]
+		if existing := mapValue(dst, key.Value); existing != nil {
+			mergeNode(existing, val)
+			continue
+		}
+		dst.Content = append(dst.Content, key, val)
+	}
+}
+
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setNode writes value at path, creating intermediate maps. Sequence elements are
+// addressed by index and must already exist.
+func setNode(n *yaml.Node, path []string, value string) error {
+	seg := path[0]
+	var child *yaml.Node
+	switch n.Kind {
+	case yaml.MappingNode:
+		child = mapValue(n, seg)
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: seg}, child)
+		}
+	case yaml.SequenceNode:
+		i, err := strconv.Atoi(seg)
+		if err != nil || i < 0 || i >= len(n.Content) {
+			return fmt.Errorf("%q is not an index of a %d-element list", seg, len(n.Content))
+		}
+		child = n.Content[i]
+	default:
+		return fmt.Errorf("cannot set %q inside a scalar", seg)
+	}
+	if len(path) > 1 {
+		return setNode(child, path[1:], value)
+	}
+
+	leaf := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if v := strings.TrimSpace(value); strings.HasPrefix(v, "[") || strings.HasPrefix(v, "{") {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(v), &doc); err != nil {
+			return err
+		}
+		leaf = doc.Content[0]
+	}
+	*child = *leaf
+	return nil
+}
+
+// IsSecretKey reports whether a config key holds a credential or a hash of one.
+func IsSecretKey(key string) bool {
+	k := strings.ToLower(key)
+	return strings.Contains(k, "secret") || strings.Contains(k, "token") || strings.Contains(k, "password") ||
+		strings.Contains(k, "sha256")
+}
+
+// RedactedConfig returns cfg as a YAML document with every non-empty secret
+// replaced, for printing the effective config.
+func RedactedConfig(cfg *models.Config) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := doc.Encode(cfg); err != nil {
+		return nil, err
+	}
+	redactNode(&doc)
+	return &doc, nil
+}
+
+func redactNode(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			if IsSecretKey(key.Value) && val.Kind == yaml.ScalarNode && val.Value != "" {
+				val.Value, val.Tag, val.Style = redacted, "!!str", 0
+				continue
+			}
+			redactNode(val)
+		}
+		return
+	}
+	for _, c := range n.Content {
+		redactNode(c)
+	}
+}
@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseConfigReportsPathQualifiedProblems(t *testing.T) {
+	_, err := ParseConfig([]byte(`
+bot_filter:
+  rate_limt_max: 10
+  rate_limit_window_sec: -1
+  allow_countries: ["ID", "indonesia"]
+  blacklist_ref_regex: ["ok", "("]
+  block_asn_categories: [hosting, datacenter]
+  bypass: {keys: [{name: qa, key_sha256: a9f7x2kq}]}
+  challenge: {enabled: true, threshold: 0.5, secret: 0123456789abcdef, difficulty: 40}
+asn_categories:
+  hosting: [16509]
+campaigns:
+  - id: a
+    passthrough: {mode: allow, transform: [{param: x, op: shout}]}
+  - id: a
+products:
+  - {id: p1, name: A, url: "https://a.example/?s={sub_id}", percentage: 10}
+  - {id: p1, name: B, url: "https://b.example/?s={sub id}", percentage: 10}
+`))
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	want := []string{
+		"bot_filter.rate_limt_max: unknown field",
+		"bot_filter.rate_limit_window_sec: must not be negative",
+		"bot_filter.allow_countries[1]:",
+		"bot_filter.blacklist_ref_regex[1]:",
+		"bot_filter.block_asn_categories[1]: unknown category",
+		"bot_filter.bypass.keys[0].key_sha256: must be 64",
+		"bot_filter.challenge.difficulty: must be between 0 and 24",
+		"campaigns[0].passthrough.transform[0].op: unknown op",
+		"campaigns[1].id: duplicate id",
+		"products[1].id: duplicate id",
+		"products[1].url: invalid placeholder",
+	}
+	got := err.Error()
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("missing %q in:\n%s", w, got)
+		}
+	}
+	if len(errs) != len(want) {
+		t.Errorf("got %d problems, want %d:\n%s", len(errs), len(want), got)
+	}
+}
+
+// useViews points ViewsDir at the repo's templates for the test.
+func useViews(t *testing.T) {
+	prev := ViewsDir
+	ViewsDir = "../views"
+	t.Cleanup(func() { ViewsDir = prev })
+}
+
+func TestLoadConfigAcceptsShippedConfig(t *testing.T) {
+	useViews(t)
+	if _, err := LoadConfig("../config/config.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigLayersProfileEnvAndSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "config.yaml")
+	write := func(path, body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(base, `
+clickadilla: {token: committed, postback_url: "https://c.example/in"}
+bot_filter: {rate_limit_max: 10, rate_limit_window_sec: 10, allow_countries: [ID]}
+products:
+  - {name: A, url: "https://a.example", percentage: 50}
+`)
+	write(filepath.Join(dir, "config.prod.yaml"), "bot_filter: {rate_limit_max: 30}\n")
+	write(filepath.Join(dir, "token"), "from-file\n")
+
+	cfg, err := ConfigLayers{
+		Path:    base,
+		Profile: "prod",
+		Env: []string{
+			"GOREDIRECT_CLICKADILLA__TOKEN_FILE=" + filepath.Join(dir, "token"),
+			"GOREDIRECT_BOT_FILTER__ALLOW_COUNTRIES=[ID, MY]",
+			"GOREDIRECT_PRODUCTS__0__PERCENTAGE=5",
+			"GOREDIRECT_PRE_SALE__HANDOFF_SECRET=12345",
+			"UNRELATED=1",
+		},
+	}.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BotFilter.RateLimitMax != 30 || cfg.BotFilter.RateLimitWindowSec != 10 {
+		t.Errorf("profile not merged: %+v", cfg.BotFilter)
+	}
+	if cfg.ClickAdilla.Token != "from-file" || cfg.PreSale.HandoffSecret != "12345" {
+		t.Errorf("secrets = %q, %q", cfg.ClickAdilla.Token, cfg.PreSale.HandoffSecret)
+	}
+	if len(cfg.BotFilter.AllowCountries) != 2 || cfg.Products[0].Percentage != 5 {
+		t.Errorf("env overrides not applied: %+v %+v", cfg.BotFilter.AllowCountries, cfg.Products[0])
+	}
+
+	doc, err := RedactedConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := yaml.Marshal(doc)
+	if strings.Contains(string(out), "from-file") || strings.Contains(string(out), "12345") {
+		t.Errorf("secret leaked:\n%s", out)
+	}
+}
+
+func TestConfigLayersReadFilesForSecretKeysOnly(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(base, []byte("bot_filter: {rate_limit_max: 10}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	root, err := ConfigLayers{
+		Path: base,
+		Env:  []string{"GOREDIRECT_BOT_FILTER__RATE_LIMIT_MAX_FILE=/does/not/exist"},
+	}.Node()
+	if err != nil {
+		t.Fatalf("non-secret _FILE key must not be read as a file: %v", err)
+	}
+	filter := mapValue(root, "bot_filter")
+	if v := mapValue(filter, "rate_limit_max_file"); v == nil || v.Value != "/does/not/exist" {
+		t.Errorf("expected a literal rate_limit_max_file key, got %+v", v)
+	}
+	if v := mapValue(filter, "rate_limit_max"); v == nil || v.Value != "10" {
+		t.Errorf("rate_limit_max overridden: %+v", v)
+	}
+}
+
+func TestParseConfigNormalisesRenameKeys(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+passthrough: {rename: {ZoneID: sub_id4}}
+campaigns:
+  - id: a
+    passthrough: {rename: {Sub1: s1}}
+products:
+  - {name: A, url: "https://a.example", percentage: 10}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Passthrough.Rename["zoneid"] != "sub_id4" || cfg.Campaigns[0].Passthrough.Rename["sub1"] != "s1" {
+		t.Errorf("rename keys not lower-cased: %v %v", cfg.Passthrough.Rename, cfg.Campaigns[0].Passthrough.Rename)
+	}
+	if _, err := ParseConfig([]byte("passthrough: {rename: {zoneid: a, ZoneID: b}}\n")); err == nil || !strings.Contains(err.Error(), "case-insensitive") {
+		t.Errorf("expected a clash between rename keys, got %v", err)
+	}
+}
+
+func TestShippedConfigListsEveryInternalParam(t *testing.T) {
+	data, err := os.ReadFile("../config/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, comment, _ := strings.Cut(string(data), "Internal keys (")
+	comment, _, _ = strings.Cut(comment, ")")
+	listed := map[string]bool{}
+	for _, k := range strings.Split(comment, ",") {
+		listed[strings.Join(strings.Fields(strings.TrimPrefix(strings.TrimSpace(k), "#")), "")] = true
+	}
+	for k := range internalParams {
+		if !listed[k] {
+			t.Errorf("config.yaml passthrough comment does not list %q", k)
+		}
+	}
+	if len(listed) != len(internalParams) {
+		t.Errorf("config.yaml lists %d internal keys, internalParams has %d", len(listed), len(internalParams))
+	}
+}
+
+func TestParseConfigNeedsHandoffSecretToReject(t *testing.T) {
+	_, err := ParseConfig([]byte("pre_sale: {reject_invalid: true}\n"))
+	if err == nil || !strings.Contains(err.Error(), "pre_sale.handoff_secret: is required") {
+		t.Errorf("expected a missing hand-off secret, got %v", err)
+	}
+	if _, err := ParseConfig([]byte("pre_sale: {reject_invalid: true, handoff_secret: s3cret}\n")); err != nil {
+		t.Errorf("secret set: %v", err)
+	}
+}
+
+func TestParseConfigChecksVariantTemplates(t *testing.T) {
+	useViews(t)
+	_, err := ParseConfig([]byte(`
+pre_sale:
+  experiment:
+    variants:
+      - {name: control, template: pre-sale, weight: 1}
+      - {name: typo, template: pre-sael, weight: 1}
+campaigns:
+  - id: a
+    pre_sale: {variants: [{name: b, template: pre-sale-minimal, weight: 1}]}
+`))
+	var errs ConfigErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "pre_sale.experiment.variants[1].template" {
+		t.Errorf("expected only the misspelt template, got %v", err)
+	}
+}
@@ -15,6 +15,8 @@ import (
 // Mappings per requirement:
 // - "Link Produk" => image
 // - "Link Komisi Ekstra" => url
+// - "Kategori" => category, "Tags" => tags (separated by ";", "|" or ",")
+// - "Harga" (e.g., "114,0RB", "2,3JT") => price, "Penjualan" (e.g., "10RB+") => sales, "Komisi" => commission
 // Weighting: Prefer numeric value from "Komisi" (e.g., "Rp13.680" => 13680). If missing/zero, fallback to percentage from "Komisi hingga" (e.g., "12,5%" => 12.5). If both missing, fallback to 1.
 func LoadProductsCSV(path string) ([]models.Product, error) {
 	f, err := os.Open(path)
@@ -103,10 +105,14 @@ func LoadProductsCSV(path string) ([]models.Product, error) {
 		url := get(row, "Link Komisi Ekstra")
 		komisiStr := get(row, "Komisi")
 		komisiHinggaStr := get(row, "Komisi hingga")
+		pool := get(row, "Pool")
+		category := get(row, "Kategori")
+		tags := splitTags(get(row, "Tags"))
 		if id == "" && desc == "" && url == "" && image == "" {
 			continue
 		}
-		weight := parseKomisi(komisiStr)
+		commission := parseKomisi(komisiStr)
+		weight := commission
 		if weight <= 0 {
 			weight = parseKomisiHingga(komisiHinggaStr)
 			if weight <= 0 {
@@ -123,9 +129,45 @@ func LoadProductsCSV(path string) ([]models.Product, error) {
 			Percentage:   weight,
 			Komisi:       komisiStr,
 			KomisiHingga: komisiHinggaStr,
+			Pool:         pool,
+			Category:     category,
+			Tags:         tags,
+			Price:        parseShortNumber(get(row, "Harga")),
+			Sales:        int(parseShortNumber(get(row, "Penjualan"))),
+			Commission:   commission,
 		}
 		products = append(products, p)
 	}
 
 	return products, nil
 }
+
+// parseShortNumber parses Shopee-style abbreviated numbers: "114,0RB" => 114000,
+// "2,3JT" => 2300000, "10RB+" => 10000, "850" => 850. Unparseable input gives 0.
+func parseShortNumber(s string) float64 {
+	s = strings.ToUpper(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "+")))
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(s, "RB"):
+		mult, s = 1e3, strings.TrimSuffix(s, "RB")
+	case strings.HasSuffix(s, "JT"):
+		mult, s = 1e6, strings.TrimSuffix(s, "JT")
+	}
+	s = strings.ReplaceAll(strings.ReplaceAll(s, ".", ""), ",", ".")
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v * mult
+}
+
+// splitTags splits a tag cell on ";", "|" or "," and drops empty entries.
+func splitTags(s string) []string {
+	var tags []string
+	for _, t := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '|' || r == ',' }) {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
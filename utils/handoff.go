@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Hand-off token verification failures.
+var (
+	ErrHandoffMalformed = errors.New("handoff token malformed")
+	ErrHandoffForged    = errors.New("handoff token signature mismatch")
+	ErrHandoffExpired   = errors.New("handoff token expired")
+)
+
+// HandoffClaims is what the pre-sale page vouches for when it hands a visitor to the redirect.
+type HandoffClaims struct {
+	ProductID    string `json:"p"`
+	Campaign     string `json:"c,omitempty"`
+	Variant      string `json:"x,omitempty"`
+	Article      string `json:"a,omitempty"`
+	Visitor      string `json:"v"`
+	ImpressionID string `json:"i"`
+	IssuedAt     int64  `json:"t"`
+	ExpiresAt    int64  `json:"e"`
+}
+
+// SignHandoff encodes claims under the hand-off domain.
+func SignHandoff(secret []byte, claims HandoffClaims) string {
+	return signClaims(secret, handoffDomain, claims)
+}
+
+// VerifyHandoff checks the signature first and only then the expiry, so an
+// expired token is known to be genuine.
+func VerifyHandoff(secret []byte, token string, now time.Time) (HandoffClaims, error) {
+	var claims HandoffClaims
+	switch err := verifyClaims(secret, handoffDomain, token, now, &claims); err {
+	case nil:
+		return claims, nil
+	case errClaimsForged:
+		return claims, ErrHandoffForged
+	case errClaimsExpired:
+		return claims, ErrHandoffExpired
+	default:
+		return claims, ErrHandoffMalformed
+	}
+}
+
+func (c HandoffClaims) expires() int64 { return c.ExpiresAt }
+
+// VisitorFingerprint binds a token to the visitor without storing raw IP/UA in it.
+func VisitorFingerprint(ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(ip + "|" + userAgent))
+	return hex.EncodeToString(sum[:8])
+}
+
+// RandomID returns n random bytes hex-encoded.
+func RandomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -6,11 +6,28 @@ import (
 	"regexp"
 	"slices"
 	"strings"
+
+	"go-redirect/models"
 )
 
 // BuildAffiliateURL will replace all placeholders {key} in baseURL with queryParams[key] if present,
 // otherwise fallback ke sub_id, lalu tambahin extra query yg ga ada di template.
-func BuildAffiliateURL(baseURL string, queryParams map[string]string) string {
+// Extra query params go through the passthrough policy (filter, rename, transform) first;
+// renamed/transformed values are also visible to placeholders.
+func BuildAffiliateURL(baseURL string, incoming map[string]string, policy models.PassthroughPolicy) string {
+	forwarded := ApplyPassthrough(policy, incoming)
+	queryParams := make(map[string]string, len(incoming)+len(forwarded))
+	for k, v := range incoming {
+		if strings.EqualFold(k, "bypass") {
+			// A bypass token is a credential; never substitute it into a template.
+			continue
+		}
+		queryParams[k] = v
+	}
+	for k, v := range forwarded {
+		queryParams[k] = v
+	}
+
 	re := regexp.MustCompile(`\{([^}]+)\}`)
 	matches := re.FindAllStringSubmatch(baseURL, -1)
 
@@ -72,13 +89,13 @@ func BuildAffiliateURL(baseURL string, queryParams map[string]string) string {
 			}
 		}
 	}
-	keys := make([]string, 0, len(queryParams))
-	for k := range queryParams {
+	keys := make([]string, 0, len(forwarded))
+	for k := range forwarded {
 		keys = append(keys, k)
 	}
 	slices.Sort(keys)
 	for _, k := range keys {
-		v := queryParams[k]
+		v := forwarded[k]
 		if strings.Contains(baseURL, "{"+k+"}") { // ada di template
 			continue
 		}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
@@ -24,6 +26,10 @@ func init() {
 var Logs []LogEntry
 var logMu sync.Mutex
 
+// ASNLookup fills ASN and ASOrg of entries with an IP; set by main when the ASN
+// database is loaded.
+var ASNLookup func(ip string) (asn uint, org string, ok bool)
+
 type LogEntry struct {
 	Type        string                 `json:"type"`
 	Timestamp   time.Time              `json:"timestamp"`
@@ -37,10 +43,16 @@ type LogEntry struct {
 	Referer     string                 `json:"referer,omitempty"`
 	QueryParams map[string]string      `json:"query_params,omitempty"`
 	Headers     map[string]string      `json:"headers,omitempty"`
+	ASN         uint                   `json:"asn,omitempty"`
+	ASOrg       string                 `json:"as_org,omitempty"`
 	Extra       map[string]interface{} `json:"extra,omitempty"`
 }
 
 func LogInfo(entry LogEntry) error {
+	if entry.IP != "" && entry.ASN == 0 && ASNLookup != nil {
+		entry.ASN, entry.ASOrg, _ = ASNLookup(entry.IP)
+	}
+
 	logMu.Lock()
 	defer logMu.Unlock()
 
@@ -124,6 +136,63 @@ func LogsSummary() map[string]interface{} {
 	}
 }
 
+// LogFolder returns the log directory: LOG_PATH (Fly.io volume) or ./logs for development.
+func LogFolder() string {
+	if folder := os.Getenv("LOG_PATH"); folder != "" {
+		return folder
+	}
+	return "logs"
+}
+
+// ForEachLogEntry streams every entry of every daily log file, oldest file first.
+// Lines that fail to decode are skipped.
+func ForEachLogEntry(fn func(LogEntry)) error {
+	return ForEachLogEntryIn(LogFolder(), fn)
+}
+
+// ForEachLogEntryIn is ForEachLogEntry for another log directory, e.g. a downloaded copy.
+func ForEachLogEntryIn(folder string, fn func(LogEntry)) error {
+	files, err := filepath.Glob(fmt.Sprintf("%s/log-*.jsonl", folder))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, filename := range files {
+		file, err := os.Open(filename)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry LogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			fn(entry)
+		}
+		file.Close()
+	}
+	return nil
+}
+
+// FlushLogs waits for in-flight LogInfo calls and fsyncs today's log file, so entries
+// written just before shutdown survive a suspended or stopped volume.
+func FlushLogs() error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	filename := fmt.Sprintf("%s/log-%s.jsonl", LogFolder(), time.Now().In(wibLocation).Format("2006-01-02"))
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
 func LogFatal(entry LogEntry, code int) {
 	_ = LogInfo(entry)
 	os.Exit(code)
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"go-redirect/models"
+)
+
+// internalParams are our own routing/control keys and must never reach the merchant.
+var internalParams = map[string]struct{}{
+	"bypass":   {},
+	"from":     {},
+	"redirect": {},
+	"auto":     {},
+	"expires":  {},
+	"product":  {},
+	"campaign": {},
+	"handoff":  {},
+	"article":  {},
+}
+
+// IsInternalParam reports whether key is one of our own control params.
+func IsInternalParam(key string) bool {
+	_, ok := internalParams[strings.ToLower(key)]
+	return ok
+}
+
+// PassthroughAllows reports whether an incoming param may be forwarded under policy.
+// It checks the original (not renamed) key.
+func PassthroughAllows(policy models.PassthroughPolicy, key string) bool {
+	if IsInternalParam(key) {
+		return false
+	}
+	listed := false
+	for _, p := range policy.Params {
+		if strings.EqualFold(strings.TrimSpace(p), key) {
+			listed = true
+			break
+		}
+	}
+	if strings.EqualFold(policy.Mode, "allow") {
+		return listed
+	}
+	return !listed
+}
+
+// ApplyPassthrough filters, renames and transforms incoming params according to policy
+// and returns the set that should be forwarded to the merchant. Rename keys are
+// matched case-insensitively, like Params; NormalizePassthrough lower-cases them.
+func ApplyPassthrough(policy models.PassthroughPolicy, params map[string]string) map[string]string {
+	out := make(map[string]string, len(params))
+	for k, v := range params {
+		if !PassthroughAllows(policy, k) {
+			continue
+		}
+		if to, ok := policy.Rename[strings.ToLower(k)]; ok && to != "" {
+			k = to
+		}
+		out[k] = v
+	}
+	for _, t := range policy.Transform {
+		op := strings.ToLower(t.Op)
+		v, ok := out[t.Param]
+		if !ok && op != "default" {
+			continue
+		}
+		out[t.Param] = transformValue(op, t.Value, v)
+	}
+	return out
+}
+
+// NormalizePassthrough lower-cases the policy's rename keys so ApplyPassthrough
+// can look incoming params up by their lower-cased name.
+func NormalizePassthrough(p *models.PassthroughPolicy) {
+	if len(p.Rename) == 0 {
+		return
+	}
+	rename := make(map[string]string, len(p.Rename))
+	for from, to := range p.Rename {
+		rename[strings.ToLower(from)] = to
+	}
+	p.Rename = rename
+}
+
+// TransformOps lists the ops transformValue understands.
+var TransformOps = map[string]struct{}{
+	"lower": {}, "upper": {}, "trim": {}, "prefix": {}, "suffix": {},
+	"truncate": {}, "default": {}, "sha256": {},
+}
+
+// transformValue applies op, already lower-cased, with its argument arg to v.
+func transformValue(op, arg, v string) string {
+	switch op {
+	case "lower":
+		return strings.ToLower(v)
+	case "upper":
+		return strings.ToUpper(v)
+	case "trim":
+		return strings.TrimSpace(v)
+	case "prefix":
+		return arg + v
+	case "suffix":
+		return v + arg
+	case "truncate":
+		if n, err := strconv.Atoi(arg); err == nil && n >= 0 && len(v) > n {
+			return v[:n]
+		}
+	case "default":
+		if v == "" {
+			return arg
+		}
+	case "sha256":
+		sum := sha256.Sum256([]byte(v))
+		return hex.EncodeToString(sum[:])
+	}
+	return v
+}
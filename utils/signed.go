@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Every signed token is base64url(json) + "." + base64url(hmac-sha256), the MAC
+// taken over domain + "." + body. Each token kind has its own domain so one
+// never verifies as another.
+const (
+	handoffDomain       = "handoff"
+	bypassDomain        = "bypass"
+	challengeDomain     = "challenge"
+	challengePassDomain = "challenge_pass"
+)
+
+// Failures from verifyClaims; each token kind maps them onto its own errors.
+var (
+	errClaimsMalformed = errors.New("token malformed")
+	errClaimsForged    = errors.New("token signature mismatch")
+	errClaimsExpired   = errors.New("token expired")
+)
+
+// expiring is implemented by every claims type so verifyClaims can check expiry.
+type expiring interface {
+	expires() int64
+}
+
+func signClaims(secret []byte, domain string, v interface{}) string {
+	payload, _ := json.Marshal(v)
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + base64.RawURLEncoding.EncodeToString(claimsMAC(secret, domain, body))
+}
+
+// verifyClaims checks the signature first and only then decodes into v and
+// checks the expiry, so an expired token is known to be genuine.
+func verifyClaims(secret []byte, domain, token string, now time.Time, v expiring) error {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok || body == "" || sig == "" {
+		return errClaimsMalformed
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return errClaimsMalformed
+	}
+	if !hmac.Equal(got, claimsMAC(secret, domain, body)) {
+		return errClaimsForged
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return errClaimsMalformed
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return errClaimsMalformed
+	}
+	if now.Unix() > v.expires() {
+		return errClaimsExpired
+	}
+	return nil
+}
+
+func claimsMAC(secret []byte, domain, body string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(domain + "." + body))
+	return m.Sum(nil)
+}
@@ -0,0 +1,516 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-redirect/ipset"
+	"go-redirect/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ConfigError is one problem in a config file, qualified by its YAML path
+// (e.g. "bot_filter.blacklist_ref_regex[2]").
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ConfigErrors collects every problem found, so one run reports them all.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, ce := range e {
+		lines[i] = ce.Error()
+	}
+	return "invalid config:\n  " + strings.Join(lines, "\n  ")
+}
+
+func (e *ConfigErrors) add(path, msg string) {
+	*e = append(*e, ConfigError{Path: path, Message: msg})
+}
+
+var (
+	countryCodeRe = regexp.MustCompile(`^[A-Z]{2}$`)
+	placeholderRe = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// ViewsDir is where pre-sale variant templates must exist, relative to the
+// working directory the server runs in.
+var ViewsDir = "views"
+
+// ValidateConfig checks the semantic rules strict decoding cannot: regexes compile,
+// country codes are ISO 3166 alpha-2, URL templates are well formed, rates and
+// weights are non-negative, product/campaign/variant names are unique and
+// variant templates exist under ViewsDir.
+func ValidateConfig(cfg *models.Config) error {
+	var errs ConfigErrors
+
+	bf := cfg.BotFilter
+	for i, cc := range bf.AllowCountries {
+		if !countryCodeRe.MatchString(cc) {
+			errs.add(fmt.Sprintf("bot_filter.allow_countries[%d]", i), fmt.Sprintf("%q is not an upper-case ISO 3166 alpha-2 code", cc))
+		}
+	}
+	for i, pat := range bf.BlacklistRefRegex {
+		if _, err := regexp.Compile(pat); err != nil {
+			errs.add(fmt.Sprintf("bot_filter.blacklist_ref_regex[%d]", i), err.Error())
+		}
+	}
+	validateIPList(&errs, "bot_filter.blacklist_ip_prefix", bf.BlacklistIPPrefix)
+	validateIPFiles(&errs, "bot_filter.ip_blocklist_files", bf.IPBlocklistFiles)
+	validateIPList(&errs, "bot_filter.ip_allowlist", bf.IPAllowlist)
+	validateIPFiles(&errs, "bot_filter.ip_allowlist_files", bf.IPAllowlistFiles)
+	if bf.RateLimitMax < 0 {
+		errs.add("bot_filter.rate_limit_max", "must not be negative")
+	}
+	if bf.RateLimitWindowSec < 0 {
+		errs.add("bot_filter.rate_limit_window_sec", "must not be negative")
+	}
+	if bf.RateLimitMaxKeys < 0 {
+		errs.add("bot_filter.rate_limit_max_keys", "must not be negative")
+	}
+	validateRateLimits(&errs, "bot_filter.rate_limits", bf.RateLimits)
+	validateBans(&errs, "bot_filter.bans", bf.Bans)
+	validateBypass(&errs, "bot_filter.bypass", bf.Bypass)
+	validateFingerprint(&errs, "bot_filter.fingerprint", bf.Fingerprint)
+	validateChallenge(&errs, "bot_filter.challenge", bf.Challenge, bf.BlockThreshold)
+	if bf.BlockThreshold < 0 {
+		errs.add("bot_filter.block_threshold", "must not be negative")
+	}
+	ruleIDs := map[string]int{}
+	for i, r := range bf.Rules {
+		path := fmt.Sprintf("bot_filter.rules[%d]", i)
+		if !containsString(models.BotRuleNames, r.Name) {
+			errs.add(path+".name", fmt.Sprintf("unknown rule %q (one of %s)", r.Name, strings.Join(models.BotRuleNames, ", ")))
+		}
+		id := r.ID
+		if id == "" {
+			id = r.Name
+		}
+		if first, dup := ruleIDs[id]; dup {
+			errs.add(path+".id", fmt.Sprintf("duplicate rule id %q (also bot_filter.rules[%d]); set id to run a rule twice", id, first))
+		} else {
+			ruleIDs[id] = i
+		}
+		if r.Match != nil && !containsString(models.BotRulesWithMatch, r.Name) {
+			errs.add(path+".match", fmt.Sprintf("only supported by %s", strings.Join(models.BotRulesWithMatch, ", ")))
+		}
+		if r.Weight != nil && *r.Weight < 0 {
+			errs.add(path+".weight", "must not be negative")
+		}
+		if r.Name == "ip_prefix" {
+			validateIPList(&errs, path+".match", r.Match)
+		}
+	}
+
+	categoryNames := make([]string, 0, len(cfg.ASNCategories))
+	for name := range cfg.ASNCategories {
+		categoryNames = append(categoryNames, name)
+	}
+	sort.Strings(categoryNames)
+	for _, name := range categoryNames {
+		if name == "" {
+			errs.add("asn_categories", "category names must not be empty")
+		}
+		validateASNs(&errs, "asn_categories."+name, cfg.ASNCategories[name])
+	}
+	validateASNs(&errs, "bot_filter.block_asns", bf.BlockASNs)
+	validateASNs(&errs, "bot_filter.allow_asns", bf.AllowASNs)
+	validateASNCategories(&errs, "bot_filter.block_asn_categories", bf.BlockASNCategories, cfg.ASNCategories)
+
+	for path, u := range map[string]string{
+		"propeller.postback_url":   cfg.Propeller.PostbackURL,
+		"galaksion.postback_url":   cfg.Galaksion.PostbackURL,
+		"popcash.postback_url":     cfg.Popcash.PostbackURL,
+		"clickadilla.postback_url": cfg.ClickAdilla.PostbackURL,
+	} {
+		if u != "" {
+			validateURLTemplate(&errs, path, u)
+		}
+	}
+
+	if cfg.PreSale.RejectInvalid && cfg.PreSale.HandoffSecret == "" {
+		errs.add("pre_sale.handoff_secret", "is required when reject_invalid is set")
+	}
+	if cfg.PreSale.HandoffTTLSec < 0 {
+		errs.add("pre_sale.handoff_ttl_sec", "must not be negative")
+	}
+	validateExperiment(&errs, "pre_sale.experiment", cfg.PreSale.Experiment)
+	validatePassthrough(&errs, "passthrough", cfg.Passthrough)
+
+	campaignIDs := map[string]int{}
+	for i, cp := range cfg.Campaigns {
+		path := fmt.Sprintf("campaigns[%d]", i)
+		if cp.ID == "" {
+			errs.add(path+".id", "is required")
+		} else if first, dup := campaignIDs[cp.ID]; dup {
+			errs.add(path+".id", fmt.Sprintf("duplicate id %q (also campaigns[%d])", cp.ID, first))
+		} else {
+			campaignIDs[cp.ID] = i
+		}
+		if cp.Passthrough != nil {
+			validatePassthrough(&errs, path+".passthrough", *cp.Passthrough)
+		}
+		if cp.PreSale != nil {
+			validateExperiment(&errs, path+".pre_sale", *cp.PreSale)
+		}
+		validateRateLimits(&errs, path+".rate_limits", cp.RateLimits)
+	}
+
+	validateAdmin(&errs, cfg.Admin)
+	validateIPList(&errs, "proxy.trusted_cidrs", cfg.Proxy.TrustedCIDRs)
+	for i, h := range cfg.Proxy.ClientIPHeaders {
+		if strings.TrimSpace(h) == "" {
+			errs.add(fmt.Sprintf("proxy.client_ip_headers[%d]", i), "must not be empty")
+		}
+	}
+
+	productIDs := map[string]int{}
+	for i, p := range cfg.Products {
+		path := fmt.Sprintf("products[%d]", i)
+		if p.URL == "" {
+			errs.add(path+".url", "is required")
+		} else {
+			validateURLTemplate(&errs, path+".url", p.URL)
+		}
+		if p.Percentage < 0 {
+			errs.add(path+".percentage", "must not be negative")
+		}
+		validateASNs(&errs, path+".asns", p.ASNs)
+		validateASNCategories(&errs, path+".asn_categories", p.ASNCategories, cfg.ASNCategories)
+		if p.ID == "" {
+			continue
+		}
+		if first, dup := productIDs[p.ID]; dup {
+			errs.add(path+".id", fmt.Sprintf("duplicate id %q (also products[%d])", p.ID, first))
+		} else {
+			productIDs[p.ID] = i
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateURLTemplate checks an absolute http(s) URL whose {placeholders} are
+// balanced and named with letters, digits and underscores.
+func validateURLTemplate(errs *ConfigErrors, path, tmpl string) {
+	rest := tmpl
+	for {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			break
+		}
+		if rest[open] == '}' {
+			errs.add(path, "unbalanced '}' in URL template")
+			return
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] == '{' {
+			errs.add(path, "unterminated '{' in URL template")
+			return
+		}
+		name := rest[open+1 : open+1+end]
+		if !placeholderRe.MatchString(name) {
+			errs.add(path, fmt.Sprintf("invalid placeholder {%s}", name))
+			return
+		}
+		rest = rest[open+1+end+1:]
+	}
+
+	u, err := url.Parse(placeholderPattern.ReplaceAllString(tmpl, "x"))
+	if err != nil {
+		errs.add(path, err.Error())
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		errs.add(path, "must be an http or https URL")
+	} else if u.Host == "" {
+		errs.add(path, "missing host")
+	}
+}
+
+var placeholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// validateIPList checks every entry parses as a CIDR, IP or legacy octet prefix.
+func validateIPList(errs *ConfigErrors, path string, entries []string) {
+	for i, e := range entries {
+		if _, err := ipset.ParsePrefix(e); err != nil {
+			errs.add(fmt.Sprintf("%s[%d]", path, i), err.Error())
+		}
+	}
+}
+
+// validateIPFiles loads each list file once to catch missing files and bad lines.
+func validateIPFiles(errs *ConfigErrors, path string, files []string) {
+	for i, f := range files {
+		if err := new(ipset.Set).AddFile(f); err != nil {
+			errs.add(fmt.Sprintf("%s[%d]", path, i), err.Error())
+		}
+	}
+}
+
+// validateRateLimits checks keys are known and listed once, and windows positive.
+func validateRateLimits(errs *ConfigErrors, path string, limits []models.RateLimit) {
+	seen := map[string]bool{}
+	for i, l := range limits {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case !containsString(models.RateLimitKeys, l.Key):
+			errs.add(p+".key", fmt.Sprintf("unknown key %q (one of %s)", l.Key, strings.Join(models.RateLimitKeys, ", ")))
+		case seen[l.Key]:
+			errs.add(p+".key", fmt.Sprintf("%q listed twice", l.Key))
+		}
+		seen[l.Key] = true
+		if l.Max < 0 {
+			errs.add(p+".max", "must not be negative")
+		}
+		if l.WindowSec <= 0 {
+			errs.add(p+".window_sec", "must be positive")
+		}
+	}
+}
+
+func validateBans(errs *ConfigErrors, path string, b models.BanPolicy) {
+	for i, name := range b.Rules {
+		if !containsString(models.BotRuleNames, name) {
+			errs.add(fmt.Sprintf("%s.rules[%d]", path, i), fmt.Sprintf("unknown rule %q (one of %s)", name, strings.Join(models.BotRuleNames, ", ")))
+		}
+	}
+	if b.Key != "" && !containsString(models.BanKeys, b.Key) {
+		errs.add(path+".key", fmt.Sprintf("unknown key %q (one of %s)", b.Key, strings.Join(models.BanKeys, ", ")))
+	}
+	if b.Threshold < 0 {
+		errs.add(path+".threshold", "must not be negative")
+	}
+	if b.WindowSec < 0 {
+		errs.add(path+".window_sec", "must not be negative")
+	}
+	if b.ResetAfterSec < 0 {
+		errs.add(path+".reset_after_sec", "must not be negative")
+	}
+	for i, d := range b.DurationsSec {
+		if d <= 0 {
+			errs.add(fmt.Sprintf("%s.durations_sec[%d]", path, i), "must be positive")
+		}
+	}
+}
+
+func validateBypass(errs *ConfigErrors, path string, b models.Bypass) {
+	names := map[string]bool{}
+	for i, k := range b.Keys {
+		p := fmt.Sprintf("%s.keys[%d]", path, i)
+		switch {
+		case k.Name == "":
+			errs.add(p+".name", "is required")
+		case names[k.Name]:
+			errs.add(p+".name", fmt.Sprintf("duplicate name %q", k.Name))
+		}
+		names[k.Name] = true
+		if !sha256HexRe.MatchString(k.KeySHA256) {
+			errs.add(p+".key_sha256", "must be 64 lower-case hex digits (go-redirect new-api-key)")
+		}
+	}
+	if b.LinkSecret != "" && len(b.LinkSecret) < 16 {
+		errs.add(path+".link_secret", "must be at least 16 characters")
+	}
+	if b.LinkTTLSec < 0 {
+		errs.add(path+".link_ttl_sec", "must not be negative")
+	}
+}
+
+func validateFingerprint(errs *ConfigErrors, path string, f models.Fingerprint) {
+	names := make([]string, 0, len(f.Anomalies))
+	for name := range f.Anomalies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := path + ".anomalies." + name
+		if _, ok := models.FingerprintAnomalies[name]; !ok {
+			known := make([]string, 0, len(models.FingerprintAnomalies))
+			for k := range models.FingerprintAnomalies {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			errs.add(p, fmt.Sprintf("unknown anomaly (one of %s)", strings.Join(known, ", ")))
+		} else if score := f.Anomalies[name]; score < 0 || score > 1 {
+			errs.add(p, "must be between 0 and 1")
+		}
+	}
+	if f.MaxUAAgeDays < 0 {
+		errs.add(path+".max_ua_age_days", "must not be negative")
+	}
+}
+
+func validateASNs(errs *ConfigErrors, path string, asns []uint) {
+	for i, n := range asns {
+		if n == 0 {
+			errs.add(fmt.Sprintf("%s[%d]", path, i), "0 is not an AS number")
+		}
+	}
+}
+
+// validateASNCategories checks every name is defined under asn_categories.
+func validateASNCategories(errs *ConfigErrors, path string, names []string, categories map[string][]uint) {
+	for i, name := range names {
+		if _, ok := categories[name]; !ok {
+			errs.add(fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("unknown category %q (define it under asn_categories)", name))
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var sha256HexRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func validateAdmin(errs *ConfigErrors, a models.Admin) {
+	if a.Listen != "" {
+		if _, port, err := net.SplitHostPort(a.Listen); err != nil || port == "" {
+			errs.add("admin.listen", fmt.Sprintf("%q must be host:port, e.g. 127.0.0.1:8081", a.Listen))
+		}
+	}
+	names := map[string]string{}
+	checkName := func(path, name string) {
+		if name == "" {
+			errs.add(path+".name", "is required")
+		} else if first, dup := names[name]; dup {
+			errs.add(path+".name", fmt.Sprintf("duplicate name %q (also %s)", name, first))
+		} else {
+			names[name] = path
+		}
+	}
+	checkRole := func(path, role string) {
+		if role != models.RoleViewer && role != models.RoleOperator {
+			errs.add(path+".role", fmt.Sprintf("%q must be %s or %s", role, models.RoleViewer, models.RoleOperator))
+		}
+	}
+	for i, u := range a.Users {
+		path := fmt.Sprintf("admin.users[%d]", i)
+		checkName(path, u.Name)
+		checkRole(path, u.Role)
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			errs.add(path+".password_hash", "must be a bcrypt hash (go-redirect hash-password)")
+		}
+	}
+	for i, k := range a.APIKeys {
+		path := fmt.Sprintf("admin.api_keys[%d]", i)
+		checkName(path, k.Name)
+		checkRole(path, k.Role)
+		if !sha256HexRe.MatchString(k.KeySHA256) {
+			errs.add(path+".key_sha256", "must be 64 lower-case hex digits (go-redirect new-api-key)")
+		}
+	}
+}
+
+func validatePassthrough(errs *ConfigErrors, path string, p models.PassthroughPolicy) {
+	switch strings.ToLower(p.Mode) {
+	case "", "allow", "deny":
+	default:
+		errs.add(path+".mode", fmt.Sprintf("%q must be allow or deny", p.Mode))
+	}
+	seen := map[string]string{}
+	for from, to := range p.Rename {
+		if strings.TrimSpace(to) == "" {
+			errs.add(path+".rename."+from, "target name is empty")
+		}
+		if other, ok := seen[strings.ToLower(from)]; ok {
+			errs.add(path+".rename."+from, fmt.Sprintf("same param as %q, names are case-insensitive", other))
+		}
+		seen[strings.ToLower(from)] = from
+	}
+	for i, t := range p.Transform {
+		tp := fmt.Sprintf("%s.transform[%d]", path, i)
+		if t.Param == "" {
+			errs.add(tp+".param", "is required")
+		}
+		if _, ok := TransformOps[strings.ToLower(t.Op)]; !ok {
+			errs.add(tp+".op", fmt.Sprintf("unknown op %q", t.Op))
+		}
+		if strings.EqualFold(t.Op, "truncate") {
+			if n, err := strconv.Atoi(t.Value); err != nil || n < 0 {
+				errs.add(tp+".value", "truncate needs a non-negative integer")
+			}
+		}
+	}
+}
+
+func validateExperiment(errs *ConfigErrors, path string, exp models.PreSaleExperiment) {
+	names := map[string]bool{}
+	for i, v := range exp.Variants {
+		vp := fmt.Sprintf("%s.variants[%d]", path, i)
+		switch {
+		case v.Name == "":
+			errs.add(vp+".name", "is required")
+		case names[v.Name]:
+			errs.add(vp+".name", fmt.Sprintf("duplicate variant %q", v.Name))
+		}
+		names[v.Name] = true
+		if v.Template == "" {
+			errs.add(vp+".template", "is required")
+		} else if _, err := os.Stat(filepath.Join(ViewsDir, v.Template+".html")); err != nil {
+			errs.add(vp+".template", fmt.Sprintf("no %s.html in %s", v.Template, ViewsDir))
+		}
+		if v.Weight < 0 {
+			errs.add(vp+".weight", "must not be negative")
+		}
+		if v.CountdownSec < 0 {
+			errs.add(vp+".countdown_sec", "must not be negative")
+		}
+	}
+	if exp.Winner != "" && !names[exp.Winner] {
+		errs.add(path+".winner", fmt.Sprintf("%q is not one of the variants", exp.Winner))
+	}
+}
+
+// maxChallengeDifficulty keeps the proof-of-work to a few seconds on a phone.
+const maxChallengeDifficulty = 24
+
+func validateChallenge(errs *ConfigErrors, path string, ch models.Challenge, blockThreshold float64) {
+	if blockThreshold <= 0 {
+		blockThreshold = 1
+	}
+	if ch.Enabled {
+		if ch.Secret == "" {
+			errs.add(path+".secret", "is required when enabled")
+		}
+		if ch.Threshold <= 0 || ch.Threshold >= blockThreshold {
+			errs.add(path+".threshold", fmt.Sprintf("must be above 0 and below the block threshold (%g)", blockThreshold))
+		}
+	}
+	if ch.Secret != "" && len(ch.Secret) < 16 {
+		errs.add(path+".secret", "must be at least 16 characters")
+	}
+	if ch.Difficulty < 0 || ch.Difficulty > maxChallengeDifficulty {
+		errs.add(path+".difficulty", fmt.Sprintf("must be between 0 and %d", maxChallengeDifficulty))
+	}
+	if ch.CookieTTLSec < 0 {
+		errs.add(path+".cookie_ttl_sec", "must not be negative")
+	}
+	if ch.ChallengeTTLSec < 0 {
+		errs.add(path+".challenge_ttl_sec", "must not be negative")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go-redirect/catalog"
+	"go-redirect/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runValidateConfig implements `go-redirect validate-config`: it loads the config
+// exactly as startup does (profile and environment overrides included), builds the
+// catalog from it and the product CSV, and prints every problem found.
+func runValidateConfig(args []string) int {
+	fs := newFlagSet("validate-config", "[config.yaml]")
+	configPath := configFlag(fs)
+	csvPath := fs.String("products", defaultCSVPath, "product CSV to check with the config (empty to skip)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		*configPath = fs.Arg(0)
+	}
+
+	cfg, _, ok := loadConfig(*configPath)
+	if !ok {
+		return 1
+	}
+	var csvProducts int
+	if *csvPath != "" {
+		products, err := utils.LoadProductsCSV(*csvPath)
+		if err != nil {
+			return fail("%s: %v", *csvPath, err)
+		}
+		if _, err := catalog.NewSnapshot(cfg.Products, products); err != nil {
+			return fail("%s: %v", *csvPath, err)
+		}
+		csvProducts = len(products)
+	}
+	fmt.Printf("%s: OK (%d products, %d csv products, %d campaigns)\n", *configPath, len(cfg.Products), csvProducts, len(cfg.Campaigns))
+	return 0
+}
+
+// runPrintConfig implements `go-redirect print-config`: it prints the effective
+// config as YAML with secrets redacted.
+func runPrintConfig(args []string) int {
+	fs := newFlagSet("print-config", "[config.yaml]")
+	configPath := configFlag(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		*configPath = fs.Arg(0)
+	}
+
+	cfg, layers, ok := loadConfig(*configPath)
+	if !ok {
+		return 1
+	}
+	doc, err := utils.RedactedConfig(cfg)
+	if err != nil {
+		return fail("%v", err)
+	}
+	fmt.Printf("# effective config from %s\n", strings.Join(layers.Files(), " + "))
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return fail("%v", err)
+	}
+	return 0
+}
+
+func printConfigError(path string, err error) {
+	var errs utils.ConfigErrors
+	if !errors.As(err, &errs) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d problem(s)\n", path, len(errs))
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "  %s\n", e.Error())
+	}
+}